@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// cpuAffinity is the OCI 1.1 process.execCPUAffinity, cpu lists like
+// "0-3,7".
+type cpuAffinity struct {
+	Initial string `json:"initial,omitempty"`
+	Final   string `json:"final,omitempty"`
+}
+
+// readExecCPUAffinity reads execCPUAffinity from a process.json. The
+// field is newer than the vendored runtime-spec, so specs.Process doesn't
+// carry it.
+func readExecCPUAffinity(path string) (*cpuAffinity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var process struct {
+		ExecCPUAffinity *cpuAffinity `json:"execCPUAffinity,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&process); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse '%s'", path)
+	}
+	return process.ExecCPUAffinity, nil
+}
+
+func cpuSetOf(list string) (*unix.CPUSet, error) {
+	cpus, err := parseCPUList(list)
+	if err != nil {
+		return nil, err
+	}
+	set := &unix.CPUSet{}
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return set, nil
+}
+
+// withInitialAffinity runs attach on a thread with the initial affinity,
+// which the attached process inherits while it is set up and moved into
+// the container's cgroup, and restores the thread's affinity afterwards.
+func withInitialAffinity(initial string, attach func() error) error {
+	if initial == "" {
+		return attach()
+	}
+	set, err := cpuSetOf(initial)
+	if err != nil {
+		return errors.Wrap(err, "invalid initial cpu affinity")
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	old := &unix.CPUSet{}
+	if err := unix.SchedGetaffinity(0, old); err != nil {
+		return err
+	}
+	if err := unix.SchedSetaffinity(0, set); err != nil {
+		return errors.Wrap(err, "failed to set initial cpu affinity")
+	}
+	defer unix.SchedSetaffinity(0, old)
+	return attach()
+}
+
+// setFinalAffinity sets the affinity of the process once it is in the
+// container's cgroup.
+func setFinalAffinity(pid int, final string) error {
+	if final == "" {
+		return nil
+	}
+	set, err := cpuSetOf(final)
+	if err != nil {
+		return errors.Wrap(err, "invalid final cpu affinity")
+	}
+	return unix.SchedSetaffinity(pid, set)
+}
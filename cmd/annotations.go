@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// annotationBool returns the boolean value of annotation key, or def if it
+// isn't set.
+func annotationBool(spec *specs.Spec, key string, def bool) (bool, error) {
+	value, ok := spec.Annotations[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value '%s' for annotation %s", value, key)
+	}
+	return b, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+func appArmorEnabled() bool {
+	data, err := ioutil.ReadFile("/sys/module/apparmor/parameters/enabled")
+	return err == nil && strings.TrimSpace(string(data)) == "Y"
+}
+
+// currentAppArmorProfile returns the profile the runtime is confined by,
+// "unconfined" if there is none.
+func currentAppArmorProfile() string {
+	data, err := ioutil.ReadFile("/proc/self/attr/current")
+	if err != nil {
+		return "unconfined"
+	}
+	// e.g. "lxc-container-default-cgns (enforce)"
+	profile := strings.TrimSpace(string(data))
+	if i := strings.Index(profile, " ("); i > 0 {
+		profile = profile[:i]
+	}
+	if profile == "" {
+		return "unconfined"
+	}
+	return profile
+}
+
+// canLoadAppArmorProfiles reports whether liblxc will be able to load the
+// profile it generates.
+func canLoadAppArmorProfiles() bool {
+	if isNested() {
+		return false
+	}
+	return unix.Access("/sys/kernel/security/apparmor/.load", unix.W_OK) == nil
+}
+
+const (
+	appArmorRuntimeDefault = "runtime/default"
+	// appArmorDefaultProfile is the runtime's default profile, which is
+	// loaded when a container first uses it.
+	appArmorDefaultProfile = "crio-lxc-default"
+)
+
+// appArmorDefaultProfileText follows the default profile of other OCI
+// runtimes: everything but mounts and writes to sensitive parts of /proc
+// and /sys is allowed.
+const appArmorDefaultProfileText = `#include <tunables/global>
+
+profile crio-lxc-default flags=(attach_disconnected,mediate_deleted) {
+  #include <abstractions/base>
+
+  network,
+  capability,
+  file,
+  umount,
+
+  signal (receive) peer=unconfined,
+  signal (send,receive) peer=crio-lxc-default,
+
+  deny @{PROC}/* w,
+  deny @{PROC}/{[^1-9],[^1-9][^0-9],[^1-9s][^0-9y][^0-9s],[^1-9][^0-9][^0-9][^0-9/]*}/** w,
+  deny @{PROC}/sys/[^k]** w,
+  deny @{PROC}/sys/kernel/{?,??,[^s][^h][^m]**} w,
+  deny @{PROC}/sysrq-trigger rwklx,
+  deny @{PROC}/kcore rwklx,
+
+  deny mount,
+
+  deny /sys/[^f]*/** wklx,
+  deny /sys/f[^s]*/** wklx,
+  deny /sys/fs/[^c]*/** wklx,
+  deny /sys/fs/c[^g]*/** wklx,
+  deny /sys/fs/cg[^r]*/** wklx,
+  deny /sys/firmware/** rwklx,
+  deny /sys/kernel/security/** rwklx,
+
+  ptrace (trace,read,tracedby,readby) peer=crio-lxc-default,
+}
+`
+
+// appArmorProfileLoaded checks the kernel's list of loaded profiles.
+func appArmorProfileLoaded(name string) (bool, error) {
+	f, err := os.Open("/sys/kernel/security/apparmor/profiles")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// e.g. "crio-lxc-default (enforce)"
+		if strings.HasPrefix(scanner.Text(), name+" (") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// loadAppArmorProfiles loads the default profile if the config uses it and
+// it isn't loaded yet.
+func loadAppArmorProfiles(cfg *lxcConfig) error {
+	profiles := cfg.Get("lxc.apparmor.profile")
+	if len(profiles) == 0 || profiles[len(profiles)-1] != appArmorDefaultProfile {
+		return nil
+	}
+
+	loaded, err := appArmorProfileLoaded(appArmorDefaultProfile)
+	if err != nil {
+		return errors.Wrap(err, "failed to list apparmor profiles")
+	}
+	if loaded {
+		return nil
+	}
+	if !canLoadAppArmorProfiles() {
+		return fmt.Errorf("apparmor profile %s is not loaded and can't be loaded from here", appArmorDefaultProfile)
+	}
+
+	cmd := exec.Command("apparmor_parser", "-Kr")
+	cmd.Stdin = strings.NewReader(appArmorDefaultProfileText)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to load apparmor profile %s: %s", appArmorDefaultProfile, strings.TrimSpace(string(out)))
+	}
+
+	// make sure lxc won't fail to switch to it later
+	loaded, err = appArmorProfileLoaded(appArmorDefaultProfile)
+	if err != nil {
+		return errors.Wrap(err, "failed to list apparmor profiles")
+	}
+	if !loaded {
+		return fmt.Errorf("apparmor profile %s is not loaded after loading it", appArmorDefaultProfile)
+	}
+	return nil
+}
+
+// configureAppArmor applies the spec's profile, or the runtime's default
+// profile if the spec has none and --default-apparmor is set. "unconfined"
+// is set explicitly, so that it overrides the profile of liblxc's default
+// config. Without a profile, when the runtime itself is confined (e.g.
+// cri-o inside an LXD container), the container needs a profile that
+// allows nesting: liblxc's generated profile if it can be loaded here,
+// otherwise the current profile.
+func configureAppArmor(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	if !appArmorEnabled() {
+		return nil
+	}
+
+	profile := spec.Process.ApparmorProfile
+	if profile == "" && ctx.GlobalBool("default-apparmor") {
+		profile = appArmorRuntimeDefault
+	}
+	if profile != "" {
+		if profile == appArmorRuntimeDefault {
+			profile = appArmorDefaultProfile
+		}
+		if err := cfg.Set("lxc.apparmor.profile", profile); err != nil {
+			return errors.Wrap(err, "failed to set apparmor profile")
+		}
+		return nil
+	}
+
+	if currentAppArmorProfile() == "unconfined" {
+		return nil
+	}
+
+	if !canLoadAppArmorProfiles() {
+		return cfg.Set("lxc.apparmor.profile", "unchanged")
+	}
+	if err := cfg.Set("lxc.apparmor.profile", "generated"); err != nil {
+		return errors.Wrap(err, "failed to set apparmor profile")
+	}
+	if err := cfg.Set("lxc.apparmor.allow_nesting", "1"); err != nil {
+		return errors.Wrap(err, "failed to allow apparmor nesting")
+	}
+	return nil
+}
+
+// appliedAppArmor is the apparmor profile the config applies, as it is
+// recorded in the container's metadata.
+func appliedAppArmor(cfg *lxcConfig) string {
+	if !appArmorEnabled() {
+		return "disabled"
+	}
+	profiles := cfg.Get("lxc.apparmor.profile")
+	if len(profiles) == 0 {
+		return profileLXCDefault
+	}
+	return profiles[len(profiles)-1]
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// Containers created with --attachable get their stdio from a relay
+// process instead of create's stdio. The relay passes the output on to
+// create's stdout and stderr as before, and to every client connected to
+// the attach socket, in frames of a stream byte, a big endian uint32
+// length and the data. What clients send is written to the container's
+// stdin if it has a terminal, and dropped otherwise.
+
+const (
+	streamStdout = 1
+	streamStderr = 2
+	// maxFrameSize is the read size of the relay, frames are never
+	// bigger.
+	maxFrameSize = 32 * 1024
+	// attachClientBacklog is how many frames may be queued for a
+	// client before it is dropped.
+	attachClientBacklog = 64
+	attachCloseTimeout  = time.Second
+)
+
+func attachSocketPath(containerID string) string {
+	return runtimePath(containerID, attachSocketName)
+}
+
+var stdioRelayCmd = cli.Command{
+	Name:      "stdio-relay",
+	Usage:     "internal: relay a container's stdio to attach clients",
+	ArgsUsage: "<containerID>",
+	Hidden:    true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "terminal",
+			Usage: "fd 4 is the pty master, instead of stdin, stdout and stderr pipes at fds 4, 5 and 6",
+		},
+	},
+	Action: doStdioRelay,
+}
+
+var attachCmd = cli.Command{
+	Name:   "attach",
+	Usage:  "attaches to the stdio of a container created with --attachable",
+	Action: doAttach,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to attach to
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "don't forward stdin to the container",
+		},
+	},
+}
+
+// relayStdio starts the stdio relay of the container and connects cmd,
+// the container's monitor, to it. The returned function closes create's
+// copies of the container's stdio.
+func relayStdio(ctx *cli.Context, containerID string, cmd *exec.Cmd, process *specs.Process) (func(), int, error) {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: attachSocketPath(containerID), Net: "unix"})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to listen on attach socket")
+	}
+	defer listener.Close()
+	listenerFile, err := listener.File()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer listenerFile.Close()
+
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, 0, err
+	}
+	args := []string{"--root", RUNTIME_ROOT, "--lxc-path", LXC_PATH, "stdio-relay"}
+	if process.Terminal {
+		args = append(args, "--terminal")
+	}
+	relay := exec.Command(binary, append(args, containerID)...)
+	relay.Stdin = stdio[0]
+	relay.Stdout = stdio[1]
+	relay.Stderr = stdio[2]
+	relay.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+
+	// the container's ends, closed in create once the monitor has them
+	var containerEnds []*os.File
+	closeAll := func() {
+		for _, f := range containerEnds {
+			f.Close()
+		}
+	}
+
+	if process.Terminal {
+		if ctx.IsSet("console-socket") {
+			return nil, 0, fmt.Errorf("--attachable and --console-socket both want the pty master")
+		}
+		master, slave, err := openPty()
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to allocate pty")
+		}
+		defer master.Close()
+		containerEnds = append(containerEnds, slave)
+		if err := setConsoleSize(master, process.ConsoleSize); err != nil {
+			closeAll()
+			return nil, 0, errors.Wrap(err, "failed to set console size")
+		}
+		relay.ExtraFiles = []*os.File{listenerFile, master}
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		cmd.Stderr = slave
+		cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true, Setctty: true}
+	} else {
+		relayEnds := []*os.File{}
+		for i := 0; i < 3; i++ {
+			r, w, err := os.Pipe()
+			if err != nil {
+				closeAll()
+				for _, f := range relayEnds {
+					f.Close()
+				}
+				return nil, 0, err
+			}
+			// stdin is written by the relay, stdout and stderr are
+			// read
+			if i == 0 {
+				containerEnds = append(containerEnds, r)
+				relayEnds = append(relayEnds, w)
+			} else {
+				containerEnds = append(containerEnds, w)
+				relayEnds = append(relayEnds, r)
+			}
+		}
+		defer func() {
+			for _, f := range relayEnds {
+				f.Close()
+			}
+		}()
+		relay.ExtraFiles = append([]*os.File{listenerFile}, relayEnds...)
+		cmd.Stdin = containerEnds[0]
+		cmd.Stdout = containerEnds[1]
+		cmd.Stderr = containerEnds[2]
+	}
+
+	if err := relay.Start(); err != nil {
+		closeAll()
+		return nil, 0, errors.Wrap(err, "failed to start stdio relay")
+	}
+	log.Debugf("started stdio relay pid %d", relay.Process.Pid)
+	return closeAll, relay.Process.Pid, nil
+}
+
+// attachClients are the connections of a relay. Each client has its own
+// queue of frames, written by a goroutine of its own, so that a client
+// that doesn't read can't hold up the container's output. Clients whose
+// queue is full are dropped.
+type attachClients struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]chan []byte
+	writers sync.WaitGroup
+}
+
+func (ac *attachClients) add(conn net.Conn) {
+	frames := make(chan []byte, attachClientBacklog)
+	ac.mu.Lock()
+	ac.conns[conn] = frames
+	ac.mu.Unlock()
+
+	ac.writers.Add(1)
+	go func() {
+		defer ac.writers.Done()
+		for frame := range frames {
+			if _, err := conn.Write(frame); err != nil {
+				ac.remove(conn)
+				// closeAll may have removed it already
+				conn.Close()
+				return
+			}
+		}
+		conn.Close()
+	}()
+}
+
+func (ac *attachClients) remove(conn net.Conn) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if frames, ok := ac.conns[conn]; ok {
+		delete(ac.conns, conn)
+		close(frames)
+		conn.Close()
+	}
+}
+
+// broadcast queues a frame for all clients, dropping the ones that fell
+// behind.
+func (ac *attachClients) broadcast(stream byte, data []byte) {
+	frame := make([]byte, 5+len(data))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	for conn, frames := range ac.conns {
+		select {
+		case frames <- frame:
+		default:
+			log.Warnf("dropping attach client, it is too slow")
+			delete(ac.conns, conn)
+			close(frames)
+			conn.Close()
+		}
+	}
+}
+
+// closeAll closes the clients once their queued frames are written,
+// giving them attachCloseTimeout to read them.
+func (ac *attachClients) closeAll() {
+	ac.mu.Lock()
+	deadline := time.Now().Add(attachCloseTimeout)
+	for conn, frames := range ac.conns {
+		conn.SetWriteDeadline(deadline)
+		close(frames)
+	}
+	ac.conns = map[net.Conn]chan []byte{}
+	ac.mu.Unlock()
+	ac.writers.Wait()
+}
+
+// relayOutput copies a stream of the container to the relay's own copy of
+// the stream and the clients until EOF.
+func relayOutput(clients *attachClients, stream byte, src io.Reader, dst io.Writer) {
+	buf := make([]byte, maxFrameSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			// create's caller may be gone, the clients still want
+			// the output
+			if dst != nil {
+				if _, err := dst.Write(buf[:n]); err != nil {
+					dst = nil
+				}
+			}
+			clients.broadcast(stream, buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func doStdioRelay(ctx *cli.Context) error {
+	listener, err := net.FileListener(os.NewFile(3, "attach.sock"))
+	if err != nil {
+		return errors.Wrap(err, "invalid attach socket")
+	}
+	defer listener.Close()
+	// writes to a gone create's caller fail with EPIPE instead
+	signal.Ignore(unix.SIGPIPE)
+
+	clients := &attachClients{conns: map[net.Conn]chan []byte{}}
+	terminal := ctx.Bool("terminal")
+	var stdin io.Writer
+	var wg sync.WaitGroup
+	if terminal {
+		master := os.NewFile(4, "ptmx")
+		stdin = master
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relayOutput(clients, streamStdout, master, os.Stdout)
+		}()
+	} else {
+		stdinPipe := os.NewFile(4, "stdin")
+		stdin = stdinPipe
+		go func() {
+			io.Copy(stdinPipe, os.Stdin)
+			stdinPipe.Close()
+		}()
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			relayOutput(clients, streamStdout, os.NewFile(5, "stdout"), os.Stdout)
+		}()
+		go func() {
+			defer wg.Done()
+			relayOutput(clients, streamStderr, os.NewFile(6, "stderr"), os.Stderr)
+		}()
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			clients.add(conn)
+			go func() {
+				defer clients.remove(conn)
+				if terminal {
+					io.Copy(stdin, conn)
+				} else {
+					io.Copy(ioutil.Discard, conn)
+				}
+			}()
+		}
+	}()
+
+	// the output ends once the container and everything else holding
+	// its stdio exited
+	wg.Wait()
+	listener.Close()
+	clients.closeAll()
+	os.Remove(attachSocketPath(ctx.Args().Get(0)))
+	return nil
+}
+
+func doAttach(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "attach", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	conn, err := net.Dial("unix", attachSocketPath(containerID))
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) || isConnRefused(err) {
+			return fmt.Errorf("container '%s' is not attachable, it must be created with --attachable and running", containerID)
+		}
+		return errors.Wrap(err, "failed to connect to attach socket")
+	}
+	defer conn.Close()
+
+	if !ctx.Bool("no-stdin") {
+		go func() {
+			io.Copy(conn, os.Stdin)
+			if uc, ok := conn.(*net.UnixConn); ok {
+				uc.CloseWrite()
+			}
+		}()
+	}
+
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "failed to read from attach socket")
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > maxFrameSize {
+			return fmt.Errorf("invalid frame size %d", size)
+		}
+		dst := os.Stdout
+		if header[0] == streamStderr {
+			dst = os.Stderr
+		}
+		if _, err := io.CopyN(dst, conn, int64(size)); err != nil {
+			return errors.Wrap(err, "failed to copy output")
+		}
+	}
+}
+
+func isConnRefused(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	return ok && (sysErr.Err == unix.ECONNREFUSED || sysErr.Err == unix.ENOENT)
+}
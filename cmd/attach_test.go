@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestAttachClientsDropsSlowClient(t *testing.T) {
+	clients := &attachClients{conns: map[net.Conn]chan []byte{}}
+	slow, slowPeer := net.Pipe()
+	defer slowPeer.Close()
+	fast, fastPeer := net.Pipe()
+	defer fastPeer.Close()
+	clients.add(slow)
+	clients.add(fast)
+
+	// one frame in the slow client's write, a full queue and one more
+	frames := attachClientBacklog + 2
+	buf := make([]byte, 6)
+	for i := 0; i < frames; i++ {
+		// the slow client never reads, broadcast must not block on it
+		clients.broadcast(streamStdout, []byte{'x'})
+		if _, err := io.ReadFull(fastPeer, buf); err != nil {
+			t.Fatalf("fast client: %v", err)
+		}
+	}
+
+	clients.mu.Lock()
+	_, slowLeft := clients.conns[slow]
+	_, fastLeft := clients.conns[fast]
+	clients.mu.Unlock()
+	if slowLeft || !fastLeft {
+		t.Errorf("slow client kept %v, fast client kept %v", slowLeft, fastLeft)
+	}
+	clients.closeAll()
+}
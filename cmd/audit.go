@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The audit log records who invoked the operations that change
+// containers, and their outcome, one json object per line. The file is
+// only ever appended to.
+
+// auditedCommands are the commands recorded in the audit log.
+var auditedCommands = map[string]bool{
+	"create": true,
+	"start":  true,
+	"kill":   true,
+	"stop":   true,
+	"delete": true,
+	"exec":   true,
+}
+
+type auditRecord struct {
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	ContainerID string    `json:"id,omitempty"`
+	UID         int       `json:"uid"`
+	User        string    `json:"user,omitempty"`
+	PID         int       `json:"pid"`
+	// Parent is the command line of the invoking process.
+	ParentPID int    `json:"ppid"`
+	Parent    string `json:"parent,omitempty"`
+	// LoginUID is the audit login uid, which sudo and su don't change.
+	LoginUID string `json:"loginuid,omitempty"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+}
+
+func procCmdline(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Replace(string(data), "\x00", " ", -1))
+}
+
+// recordAudit appends the record of a command to the audit log at path.
+func recordAudit(path string, command string, containerID string, opErr error) error {
+	if !auditedCommands[command] {
+		return nil
+	}
+	rec := auditRecord{
+		Time:        time.Now().UTC(),
+		Command:     command,
+		ContainerID: containerID,
+		UID:         os.Getuid(),
+		PID:         os.Getpid(),
+		ParentPID:   os.Getppid(),
+		Outcome:     "success",
+	}
+	if u, err := user.LookupId(strconv.Itoa(rec.UID)); err == nil {
+		rec.User = u.Username
+	}
+	rec.Parent = procCmdline(rec.ParentPID)
+	if data, err := ioutil.ReadFile("/proc/self/loginuid"); err == nil {
+		// 4294967295 is unset
+		if loginUID := strings.TrimSpace(string(data)); loginUID != "4294967295" {
+			rec.LoginUID = loginUID
+		}
+	}
+	if opErr != nil {
+		rec.Outcome, _ = errorKind(opErr)
+		rec.Error = opErr.Error()
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// appends of one write don't interleave, the lock keeps records whole
+	// on filesystems where they might
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
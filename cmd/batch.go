@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// kill and delete work on several containers at once when given more than
+// one ID or --all-containers, e.g. to drain a node. The containers are
+// handled one after the other, configureLogging changes the process wide
+// logging for each, and a result line is printed for each.
+
+var batchFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "all-containers",
+		Usage: "act on all containers, or all with the status given with --status",
+	},
+	cli.StringFlag{
+		Name:  "status",
+		Usage: "with --all-containers, only act on containers with this status (created, running or stopped)",
+	},
+}
+
+func isBatch(ctx *cli.Context) bool {
+	return ctx.NArg() > 1 || ctx.Bool("all-containers")
+}
+
+// batchIDs returns the containers of a batch.
+func batchIDs(ctx *cli.Context) ([]string, error) {
+	if !ctx.Bool("all-containers") {
+		if ctx.IsSet("status") {
+			return nil, fmt.Errorf("--status needs --all-containers")
+		}
+		return ctx.Args(), nil
+	}
+	if ctx.NArg() > 0 {
+		return nil, fmt.Errorf("container IDs can't be given with --all-containers")
+	}
+	states, err := listStates()
+	if err != nil {
+		return nil, err
+	}
+	status := ctx.String("status")
+	ids := []string{}
+	for _, s := range states {
+		if status == "" || s.Status == status {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids, nil
+}
+
+// runBatch runs op for the containers of the batch and prints the result
+// for each in ID order.
+func runBatch(ctx *cli.Context, op func(*cli.Context, string) error) error {
+	ids, err := batchIDs(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(ids)
+
+	// a container's log level must not carry over to the next one
+	level := log.InfoLevel
+	if logger, ok := log.Log.(*log.Logger); ok {
+		level = logger.Level
+	}
+	results := make([]error, len(ids))
+	for i, id := range ids {
+		results[i] = op(ctx, id)
+		log.SetLevel(level)
+	}
+
+	failed := 0
+	for i, id := range ids {
+		if results[i] != nil {
+			failed++
+			fmt.Fprintf(os.Stdout, "%s: error: %v\n", id, results[i])
+		} else {
+			fmt.Fprintf(os.Stdout, "%s: ok\n", id)
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%s failed for %d of %d containers", ctx.Command.Name, failed, len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// knownCapabilities are the capabilities lxc.cap.drop can refer to, in
+// kernel order.
+var knownCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+}
+
+// capStrategy selects how the bounding set is written to the lxc config:
+// "keep" lists the capabilities to retain, "drop" the ones to remove and
+// "auto" picks keep, unless the spec grants every capability.
+var capStrategy = "auto"
+
+// lxcCapName converts an OCI capability name (CAP_SYS_ADMIN) into the form
+// lxc expects (sys_admin).
+func lxcCapName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "CAP_"))
+}
+
+func configureCapabilities(cfg *lxcConfig, spec *specs.Spec) error {
+	// liblxc only restricts the bounding set, the other sets follow from
+	// it for the init process.
+	if spec.Process.Capabilities == nil {
+		return cfg.Set("lxc.cap.keep", "none")
+	}
+
+	keep := map[string]bool{}
+	for _, c := range spec.Process.Capabilities.Bounding {
+		name := strings.ToUpper(c)
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		keep[name] = true
+	}
+
+	var kept, dropped []string
+	for _, c := range knownCapabilities {
+		if keep[c] {
+			kept = append(kept, lxcCapName(c))
+			delete(keep, c)
+		} else {
+			dropped = append(dropped, lxcCapName(c))
+		}
+	}
+	// Capabilities newer than this binary can only be kept, since
+	// dropping works on the names we know about.
+	var unknown []string
+	for c := range keep {
+		unknown = append(unknown, c)
+	}
+	sort.Strings(unknown)
+	for _, c := range unknown {
+		kept = append(kept, lxcCapName(c))
+	}
+
+	strategy := capStrategy
+	if strategy == "auto" {
+		// Keeping is safer: capabilities added by newer kernels are
+		// dropped too. An empty drop list means everything is granted.
+		strategy = "keep"
+		if len(dropped) == 0 {
+			return nil
+		}
+	}
+
+	switch strategy {
+	case "keep":
+		if len(kept) == 0 {
+			return cfg.Set("lxc.cap.keep", "none")
+		}
+		for _, c := range kept {
+			if err := cfg.Set("lxc.cap.keep", c); err != nil {
+				return errors.Wrapf(err, "failed to keep capability %s", c)
+			}
+		}
+	case "drop":
+		if len(unknown) > 0 {
+			return fmt.Errorf("cannot use the drop strategy with unknown capabilities: %s", strings.Join(unknown, ", "))
+		}
+		for _, c := range dropped {
+			if err := cfg.Set("lxc.cap.drop", c); err != nil {
+				return errors.Wrapf(err, "failed to drop capability %s", c)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid capability strategy '%s'", capStrategy)
+	}
+	return nil
+}
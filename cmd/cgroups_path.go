@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// systemdCgroup is set by --systemd-cgroup, cgroupsPath is in the
+// slice:prefix:name form of runc's systemd driver then.
+var systemdCgroup = false
+
+// relativeCgroupsMode is set by --relative-cgroups, one of auto, true and
+// false.
+var relativeCgroupsMode = "auto"
+
+// relativeCgroups reports whether container cgroups are created below the
+// runtime's own cgroup instead of below the hierarchy roots. With auto
+// they are when running nested, and when only the runtime's subtree of
+// the unified hierarchy is writable, as in a systemd unit with Delegate=
+// or a user slice.
+func relativeCgroups() bool {
+	switch relativeCgroupsMode {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if isNested() {
+		return true
+	}
+	if layout, err := cgroupLayout(); err != nil || layout != "unified" {
+		return false
+	}
+	own := filepath.Join("/sys/fs/cgroup", ownCgroup())
+	return own != "/sys/fs/cgroup" &&
+		unix.Access("/sys/fs/cgroup/cgroup.procs", unix.W_OK) != nil &&
+		unix.Access(filepath.Join(own, "cgroup.procs"), unix.W_OK) == nil
+}
+
+// configureRelativeCgroups makes lxc create the container's cgroups below
+// the runtime's own cgroup, where it only has a delegated subtree.
+func configureRelativeCgroups(cfg *lxcConfig) error {
+	if !relativeCgroups() {
+		return nil
+	}
+	if !lxcFeatureAvailable("relative cgroups") {
+		log.Warnf("liblxc %s can't create relative cgroups, the container may fail to start", lxc.Version())
+		return nil
+	}
+	return cfg.Set("lxc.cgroup.relative", "1")
+}
+
+// expandSlice turns a systemd slice name into its path, e.g.
+// "kubepods-besteffort.slice" into
+// "kubepods.slice/kubepods-besteffort.slice".
+func expandSlice(slice string) (string, error) {
+	if !strings.HasSuffix(slice, ".slice") || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid slice name '%s'", slice)
+	}
+	if slice == "-.slice" {
+		return "", nil
+	}
+	name := strings.TrimSuffix(slice, ".slice")
+	path := ""
+	prefix := ""
+	for _, part := range strings.Split(name, "-") {
+		if part == "" {
+			return "", fmt.Errorf("invalid slice name '%s'", slice)
+		}
+		prefix += part
+		path = filepath.Join(path, prefix+".slice")
+		prefix += "-"
+	}
+	return path, nil
+}
+
+// systemdCgroupDir converts slice:prefix:name to the cgroup directory
+// systemd would use for the container's scope.
+func systemdCgroupDir(cgroupsPath string) (string, error) {
+	parts := strings.Split(cgroupsPath, ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("cgroupsPath '%s' is not in the slice:prefix:name form of --systemd-cgroup", cgroupsPath)
+	}
+	slice, prefix, name := parts[0], parts[1], parts[2]
+	if slice == "" {
+		slice = "system.slice"
+	}
+	slicePath, err := expandSlice(slice)
+	if err != nil {
+		return "", err
+	}
+	unit := name
+	if !strings.HasSuffix(name, ".slice") {
+		unit = prefix + "-" + name + ".scope"
+	}
+	return filepath.Join(slicePath, unit), nil
+}
+
+// specCgroupDir returns the container's cgroup directory, relative to the
+// hierarchy roots, or "" if the spec leaves it to lxc.
+func specCgroupDir(spec *specs.Spec) (string, error) {
+	if spec.Linux == nil || spec.Linux.CgroupsPath == "" {
+		return "", nil
+	}
+	if systemdCgroup {
+		return systemdCgroupDir(spec.Linux.CgroupsPath)
+	}
+	// cleaned as an absolute path, so that it can't escape the root
+	return strings.TrimPrefix(filepath.Clean("/"+spec.Linux.CgroupsPath), "/"), nil
+}
+
+// configureCgroupDir places the container's cgroup where the spec asks.
+// With relative cgroups lxc places it below the runtime's own cgroup, and
+// cgroupsPath is ignored.
+func configureCgroupDir(cfg *lxcConfig, spec *specs.Spec) error {
+	dir, err := specCgroupDir(spec)
+	if err != nil || dir == "" {
+		return err
+	}
+	if relativeCgroups() {
+		log.Warnf("ignoring cgroupsPath %s, containers use relative cgroups", spec.Linux.CgroupsPath)
+		return nil
+	}
+	return cfg.Set("lxc.cgroup.dir", dir)
+}
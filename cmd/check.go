@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var checkCmd = cli.Command{
+	Name:   "check",
+	Usage:  "checks that the host can run containers",
+	Action: doCheck,
+}
+
+// hostCheck is a single host prerequisite. Optional checks only produce a
+// warning when they fail.
+type hostCheck struct {
+	name     string
+	optional bool
+	check    func() (string, error)
+}
+
+var hostChecks = []hostCheck{
+	{"liblxc version", false, checkLiblxcVersion},
+	{"liblxc features", true, checkLXCFeatures},
+	{"cgroup layout", false, checkCgroupLayout},
+	{"namespaces", false, checkNamespaces},
+	{"user namespaces", true, checkUserNamespaces},
+	{"seccomp", true, checkSeccomp},
+	{"apparmor", true, checkAppArmor},
+	{"selinux", true, checkSELinux},
+	{"nesting", true, checkNested},
+}
+
+func doCheck(ctx *cli.Context) error {
+	failed := 0
+	for _, hc := range hostChecks {
+		detail, err := hc.check()
+		switch {
+		case err == nil:
+			fmt.Fprintf(os.Stdout, "%-16s ok    %s\n", hc.name, detail)
+		case hc.optional:
+			fmt.Fprintf(os.Stdout, "%-16s warn  %v\n", hc.name, err)
+		default:
+			fmt.Fprintf(os.Stdout, "%-16s FAIL  %v\n", hc.name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d host check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkLiblxcVersion() (string, error) {
+	if !lxc.VersionAtLeast(3, 0, 0) {
+		return "", fmt.Errorf("liblxc %s is too old, 3.0.0 or newer is required", lxc.Version())
+	}
+	return lxc.Version(), nil
+}
+
+// cgroupLayout returns "unified", "hybrid" or "legacy".
+func cgroupLayout() (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs("/sys/fs/cgroup", &st); err != nil {
+		return "", err
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return "unified", nil
+	}
+	if err := unix.Statfs("/sys/fs/cgroup/unified", &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return "hybrid", nil
+	}
+	return "legacy", nil
+}
+
+func checkCgroupLayout() (string, error) {
+	layout, err := cgroupLayout()
+	if err != nil {
+		return "", fmt.Errorf("cgroups not mounted on /sys/fs/cgroup: %v", err)
+	}
+	return layout, nil
+}
+
+func checkNamespaces() (string, error) {
+	var missing []string
+	for _, ns := range []string{"ipc", "mnt", "net", "pid", "uts", "cgroup"} {
+		if _, err := os.Stat("/proc/self/ns/" + ns); err != nil {
+			missing = append(missing, ns)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("kernel lacks namespace support: %s", strings.Join(missing, ", "))
+	}
+	return "ipc mnt net pid uts cgroup", nil
+}
+
+func checkUserNamespaces() (string, error) {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return "", fmt.Errorf("kernel lacks user namespace support")
+	}
+	data, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err != nil {
+		return "", err
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", err
+	}
+	if max == 0 {
+		return "", fmt.Errorf("user namespaces are disabled (user.max_user_namespaces = 0)")
+	}
+	return fmt.Sprintf("max %d", max), nil
+}
+
+func checkSeccomp() (string, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Seccomp:") {
+			return "supported", nil
+		}
+	}
+	return "", fmt.Errorf("kernel lacks seccomp support")
+}
+
+func checkAppArmor() (string, error) {
+	data, err := ioutil.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil || strings.TrimSpace(string(data)) != "Y" {
+		return "", fmt.Errorf("not enabled")
+	}
+	return "enabled", nil
+}
+
+func checkSELinux() (string, error) {
+	data, err := ioutil.ReadFile("/sys/fs/selinux/enforce")
+	if err != nil {
+		return "", fmt.Errorf("not enabled")
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return "enforcing", nil
+	}
+	return "permissive", nil
+}
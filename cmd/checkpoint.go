@@ -0,0 +1,217 @@
+package main
+
+// go-lxc's Migrate can't pass a page server, the checkpoint is made with
+// liblxc's migrate directly.
+
+/*
+#include <stdlib.h>
+#include <lxc/lxccontainer.h>
+
+static int migrate_container(char *name, char *lxcpath, unsigned int cmd, struct migrate_opts *opts)
+{
+	struct lxc_container *c;
+	int ret;
+
+	c = lxc_container_new(name, lxcpath);
+	if (!c)
+		return -1;
+	ret = c->migrate(c, cmd, opts, sizeof(*opts));
+	lxc_container_put(c);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var checkpointCmd = cli.Command{
+	Name:   "checkpoint",
+	Usage:  "checkpoints a running container with CRIU",
+	Action: doCheckpoint,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to checkpoint
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "image-path",
+			Usage: "directory to write the checkpoint images to",
+		},
+		cli.BoolFlag{
+			Name:  "pre-dump",
+			Usage: "only dump the memory, leaving the container running, for a later incremental checkpoint",
+		},
+		cli.StringFlag{
+			Name:  "parent-path",
+			Usage: "images of a previous pre-dump, relative to --image-path (e.g. ../pre-dump)",
+		},
+		cli.StringFlag{
+			Name:  "page-server",
+			Usage: "send the memory pages to a criu page-server at ADDRESS:PORT instead of writing them to --image-path",
+		},
+		cli.BoolFlag{
+			Name:  "leave-running",
+			Usage: "keep the container running after the checkpoint",
+		},
+		cli.BoolTFlag{
+			Name:  "tcp-established",
+			Usage: "checkpoint established TCP connections",
+		},
+		cli.BoolTFlag{
+			Name:  "file-locks",
+			Usage: "checkpoint file locks",
+		},
+	},
+}
+
+func doCheckpoint(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "checkpoint", 1)
+	}
+	imagePath := ctx.String("image-path")
+	if imagePath == "" {
+		return fmt.Errorf("missing --image-path")
+	}
+	if !hostSysAdmin() {
+		return fmt.Errorf("checkpoints require CAP_SYS_ADMIN on the host")
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if !running {
+		return errNotRunning(containerID, "cannot checkpoint")
+	}
+
+	if err := os.MkdirAll(imagePath, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create image path '%s'", imagePath)
+	}
+
+	// liblxc always runs criu with --tcp-established and --file-locks,
+	// the flags are only there to make that explicit.
+	for _, flag := range []string{"tcp-established", "file-locks"} {
+		if !ctx.BoolT(flag) {
+			return fmt.Errorf("--%s=false is not supported, liblxc always enables it", flag)
+		}
+	}
+
+	// Pre-dumps copy memory while the container keeps running, so that
+	// the final dump only has to copy what changed since and the
+	// container is frozen for a shorter time.
+	migrateCmd := uint(lxc.MIGRATE_DUMP)
+	if ctx.Bool("pre-dump") {
+		migrateCmd = lxc.MIGRATE_PRE_DUMP
+	}
+	parentPath := ctx.String("parent-path")
+	if parentPath != "" {
+		if filepath.IsAbs(parentPath) {
+			return fmt.Errorf("--parent-path must be relative to --image-path")
+		}
+		if _, err := os.Stat(filepath.Join(imagePath, parentPath)); err != nil {
+			return errors.Wrap(err, "invalid --parent-path")
+		}
+	}
+
+	// A page server, e.g. on the host the container migrates to, gets
+	// the memory while it is dumped, the images only have the rest.
+	var pageServerAddress, pageServerPort string
+	if pageServer := ctx.String("page-server"); pageServer != "" {
+		pageServerAddress, pageServerPort, err = net.SplitHostPort(pageServer)
+		if err != nil || pageServerAddress == "" || pageServerPort == "" {
+			return fmt.Errorf("invalid --page-server '%s', must be ADDRESS:PORT", pageServer)
+		}
+	}
+
+	sp := startSpan("lxc checkpoint")
+	sp.attrs["pre-dump"] = fmt.Sprint(ctx.Bool("pre-dump"))
+	err = migrateContainer(containerID, migrateCmd, migrateOptions{
+		Directory:         imagePath,
+		PredumpDir:        parentPath,
+		PageServerAddress: pageServerAddress,
+		PageServerPort:    pageServerPort,
+		Stop:              !ctx.Bool("pre-dump") && !ctx.Bool("leave-running"),
+		Verbose:           debug,
+	})
+	sp.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to checkpoint container to '%s'", imagePath)
+	}
+	return nil
+}
+
+// migrateOptions are the options of liblxc's struct migrate_opts that
+// checkpoint uses.
+type migrateOptions struct {
+	Directory         string
+	PredumpDir        string
+	PageServerAddress string
+	PageServerPort    string
+	Stop              bool
+	Verbose           bool
+}
+
+// cString returns s as a C string to be freed, NULL for "".
+func cString(s string) *C.char {
+	if s == "" {
+		return nil
+	}
+	return C.CString(s)
+}
+
+// migrateContainer runs a liblxc migrate command on the container.
+func migrateContainer(containerID string, cmd uint, opts migrateOptions) error {
+	name := C.CString(containerID)
+	defer C.free(unsafe.Pointer(name))
+	lxcpath := C.CString(LXC_PATH)
+	defer C.free(unsafe.Pointer(lxcpath))
+
+	var copts C.struct_migrate_opts
+	copts.directory = cString(opts.Directory)
+	copts.predump_dir = cString(opts.PredumpDir)
+	copts.pageserver_address = cString(opts.PageServerAddress)
+	copts.pageserver_port = cString(opts.PageServerPort)
+	copts.stop = C.bool(opts.Stop)
+	copts.verbose = C.bool(opts.Verbose)
+	for _, s := range []*C.char{copts.directory, copts.predump_dir, copts.pageserver_address, copts.pageserver_port} {
+		if s != nil {
+			defer C.free(unsafe.Pointer(s))
+		}
+	}
+
+	if ret := C.migrate_container(name, lxcpath, C.uint(cmd), &copts); ret != 0 {
+		return fmt.Errorf("liblxc migrate failed (%d), see the lxc log", int(ret))
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// The CNI support follows the CNI spec's conventions for executing
+// plugins directly, so that standalone users get standard networking
+// without a CNI library.
+
+// cniState is what delete needs to tear the network down again. The
+// network list is saved, so that DEL works even if the config changed.
+// NetNS is the /proc path of the init's namespace, InitPid and
+// InitStartTime identify the init it belongs to.
+type cniState struct {
+	BinDir        string          `json:"binDir"`
+	NetNS         string          `json:"netns"`
+	InitPid       int             `json:"initPid"`
+	InitStartTime uint64          `json:"initStartTime"`
+	IfName        string          `json:"ifName"`
+	Network       json.RawMessage `json:"network"`
+	Result        json.RawMessage `json:"result,omitempty"`
+}
+
+type cniNetworkList struct {
+	CNIVersion string            `json:"cniVersion"`
+	Name       string            `json:"name"`
+	Plugins    []json.RawMessage `json:"plugins"`
+}
+
+type cniError struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	Details string `json:"details,omitempty"`
+}
+
+func cniStatePath(containerID string) string {
+	return runtimePath(containerID, cniFileName)
+}
+
+// loadCNINetwork finds the network list with the given name in confDir.
+// Single plugin .conf files are wrapped into a list.
+func loadCNINetwork(confDir, name string) ([]byte, error) {
+	files, err := ioutil.ReadDir(confDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+
+	for _, fname := range names {
+		ext := filepath.Ext(fname)
+		if ext != ".conflist" && ext != ".conf" && ext != ".json" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(confDir, fname))
+		if err != nil {
+			return nil, err
+		}
+		var list cniNetworkList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, errors.Wrapf(err, "invalid CNI config %s", fname)
+		}
+		if list.Name != name {
+			continue
+		}
+		if ext == ".conflist" {
+			return data, nil
+		}
+		list.Plugins = []json.RawMessage{data}
+		return json.Marshal(list)
+	}
+	return nil, fmt.Errorf("CNI network '%s' not found in %s", name, confDir)
+}
+
+// execCNIPlugin runs a single plugin of the list, passing prevResult.
+func execCNIPlugin(command string, containerID string, list *cniNetworkList, plugin json.RawMessage, prevResult json.RawMessage, st *cniState) ([]byte, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(plugin, &conf); err != nil {
+		return nil, errors.Wrap(err, "invalid CNI plugin config")
+	}
+	conf["name"] = list.Name
+	conf["cniVersion"] = list.CNIVersion
+	if len(prevResult) > 0 {
+		conf["prevResult"] = prevResult
+	}
+	pluginType, _ := conf["type"].(string)
+	if pluginType == "" || strings.Contains(pluginType, "/") {
+		return nil, fmt.Errorf("invalid CNI plugin type '%s'", pluginType)
+	}
+	stdin, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(filepath.Join(st.BinDir, pluginType))
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+containerID,
+		"CNI_NETNS="+st.NetNS,
+		"CNI_IFNAME="+st.IfName,
+		"CNI_PATH="+st.BinDir,
+	)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var cerr cniError
+		if json.Unmarshal(stdout.Bytes(), &cerr) == nil && cerr.Msg != "" {
+			return nil, fmt.Errorf("CNI plugin %s %s failed: %s (code %d) %s", pluginType, command, cerr.Msg, cerr.Code, cerr.Details)
+		}
+		return nil, errors.Wrapf(err, "CNI plugin %s %s failed: %s", pluginType, command, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// cniAdd runs ADD for the network's plugins against the network namespace
+// of the init pid and saves the result for cniDel. On failure the plugins
+// are cleaned up again.
+func cniAdd(containerID, confDir, binDir, network string, initPid int, initStartTime uint64) error {
+	data, err := loadCNINetwork(confDir, network)
+	if err != nil {
+		return err
+	}
+	st := &cniState{
+		BinDir:        binDir,
+		NetNS:         fmt.Sprintf("/proc/%d/ns/net", initPid),
+		InitPid:       initPid,
+		InitStartTime: initStartTime,
+		IfName:        "eth0",
+		Network:       data,
+	}
+	list := &cniNetworkList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return errors.Wrap(err, "invalid CNI network list")
+	}
+
+	var result json.RawMessage
+	for _, plugin := range list.Plugins {
+		out, err := execCNIPlugin("ADD", containerID, list, plugin, result, st)
+		if err != nil {
+			st.Result = result
+			if delErr := cniRunDel(containerID, st); delErr != nil {
+				log.Warnf("failed to clean up CNI network: %v", delErr)
+			}
+			return err
+		}
+		result = out
+	}
+	st.Result = result
+
+	stateData, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cniStatePath(containerID), stateData, 0600)
+}
+
+func cniRunDel(containerID string, st *cniState) error {
+	list := &cniNetworkList{}
+	if err := json.Unmarshal(st.Network, list); err != nil {
+		return errors.Wrap(err, "invalid CNI network list")
+	}
+	// The namespace is gone once the container stopped, plugins have to
+	// cope with an empty CNI_NETNS on DEL. The /proc path is only used
+	// while the recorded init is alive, a process that reused the pid
+	// would have its own namespace torn down otherwise.
+	if !pidAlive(st.InitPid, st.InitStartTime) {
+		st.NetNS = ""
+	}
+	for i := len(list.Plugins) - 1; i >= 0; i-- {
+		if _, err := execCNIPlugin("DEL", containerID, list, list.Plugins[i], st.Result, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cniDel tears down the network set up by cniAdd, if there is one.
+func cniDel(containerID string) error {
+	data, err := ioutil.ReadFile(cniStatePath(containerID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := &cniState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return errors.Wrap(err, "invalid CNI state")
+	}
+	if err := cniRunDel(containerID, st); err != nil {
+		return err
+	}
+	return os.Remove(cniStatePath(containerID))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// With --config-cache, translated configs are cached by a hash of the
+// spec, the flags, the runtime config and the host properties and device
+// nodes the translation depends on. The container ID, which also shows up
+// in the spec's paths, is replaced by configCacheIDPlaceholder before
+// hashing and translating, so that containers from the same spec share
+// an entry. Entries live below the runtime root, which is cleared on
+// reboot along with host changes like a new kernel.
+
+const (
+	// configCacheIDPlaceholder can't occur in IDs, see validContainerID.
+	configCacheIDPlaceholder = "{{crio-lxc-id}}"
+	// maxConfigCacheEntries bounds the cache, the oldest entries are
+	// removed beyond it.
+	maxConfigCacheEntries = 256
+)
+
+// configCacheEntry is a translated config with placeholders.
+type configCacheEntry struct {
+	Items []lxcConfigItem    `json:"items"`
+	Files []configCachedFile `json:"files,omitempty"`
+}
+
+type configCachedFile struct {
+	Path string `json:"path"`
+	Data []byte `json:"data"`
+}
+
+func configCacheDir() string {
+	// IDs can't start with a dot, so this doesn't collide with a
+	// container's runtime dir
+	return filepath.Join(RUNTIME_ROOT, ".config-cache")
+}
+
+// flagValues lists the values of a set of flags, sorted by name.
+func flagValues(names []string, value func(string) interface{}) []string {
+	sort.Strings(names)
+	values := []string{}
+	for _, name := range names {
+		values = append(values, fmt.Sprintf("%s=%v", name, value(name)))
+	}
+	return values
+}
+
+// configCacheSkipFlags are the create flags that differ between
+// containers and don't change the translation. The bundle's config.json
+// is the spec, read in addition only for the domainname.
+var configCacheSkipFlags = map[string]bool{
+	"bundle":         true,
+	"pid-file":       true,
+	"console-socket": true,
+}
+
+// configCacheDevices describes the host device nodes the translation
+// looks at, the GPUs, the spec's devices and the runtime config's.
+func configCacheDevices(spec *specs.Spec, rc *runtimeConfig) ([]string, error) {
+	paths := []string{}
+	if gpus := requestedGPUs(spec); gpus != "" {
+		devices, err := nvidiaDevices(gpus)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, devices...)
+	}
+	if spec.Linux != nil {
+		for _, dev := range spec.Linux.Devices {
+			paths = append(paths, dev.Path)
+		}
+	}
+	for _, dev := range rc.Devices {
+		paths = append(paths, dev.Path)
+	}
+
+	devices := []string{}
+	for _, path := range paths {
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			devices = append(devices, path+" missing")
+			continue
+		}
+		devices = append(devices, fmt.Sprintf("%s %o %d", path, st.Mode, uint64(st.Rdev)))
+	}
+	return devices, nil
+}
+
+// configCacheSpec returns the part of the spec the translation reads. The
+// args, the environment, the hooks and the annotations of other tools
+// differ between containers whose configs are the same, only the
+// variables and the hook that request GPUs and crio-lxc's own and the lxc
+// config annotations are kept.
+func configCacheSpec(spec *specs.Spec) *specs.Spec {
+	s := *spec
+	s.Version = ""
+	if spec.Process != nil {
+		process := *spec.Process
+		process.Args = nil
+		process.Env = nil
+		for _, env := range spec.Process.Env {
+			if strings.HasPrefix(env, "NVIDIA_") {
+				process.Env = append(process.Env, env)
+			}
+		}
+		s.Process = &process
+	}
+	s.Hooks = nil
+	if spec.Hooks != nil {
+		for _, hook := range spec.Hooks.Prestart {
+			if isNvidiaHook(hook) {
+				s.Hooks = &specs.Hooks{Prestart: []specs.Hook{{Path: hook.Path}}}
+				break
+			}
+		}
+	}
+	s.Annotations = map[string]string{}
+	for key, value := range spec.Annotations {
+		if strings.HasPrefix(key, ANNOTATION_PREFIX) || strings.HasPrefix(key, LXC_CONFIG_ANNOTATION_PREFIX) {
+			s.Annotations[key] = value
+		}
+	}
+	return &s
+}
+
+// configCacheKey hashes everything the translation of the templated spec
+// depends on.
+func configCacheKey(ctx *cli.Context, spec *specs.Spec) (string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "", err
+	}
+	flags := []string{}
+	for _, name := range ctx.FlagNames() {
+		if !configCacheSkipFlags[name] {
+			flags = append(flags, name)
+		}
+	}
+	domainname, err := readBundleDomainname(ctx.String("bundle"))
+	if err != nil {
+		return "", err
+	}
+	rc, err := loadRuntimeConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	devices, err := configCacheDevices(spec, rc)
+	if err != nil {
+		return "", err
+	}
+	key := struct {
+		Spec          *specs.Spec
+		Domainname    string
+		Flags         []string
+		GlobalFlags   []string
+		RuntimeConfig *runtimeConfig
+		Devices       []string
+		LXCVersion    string
+		Kernel        string
+		AppArmor      string
+		LoadProfile   bool
+		Nested        bool
+		HostSysAdmin  bool
+		Relative      bool
+	}{
+		Spec:          configCacheSpec(spec),
+		Domainname:    domainname,
+		Flags:         flagValues(flags, ctx.Generic),
+		GlobalFlags:   flagValues(ctx.GlobalFlagNames(), ctx.GlobalGeneric),
+		RuntimeConfig: rc,
+		Devices:       devices,
+		LXCVersion:    lxc.Version(),
+		Kernel:        string(bytes.TrimRight(uts.Release[:], "\x00")),
+		AppArmor:      currentAppArmorProfile(),
+		LoadProfile:   canLoadAppArmorProfiles(),
+		Nested:        isNested(),
+		HostSysAdmin:  hostSysAdmin(),
+		Relative:      relativeCgroups(),
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// instantiate fills in the container ID.
+func (e *configCacheEntry) instantiate(containerID string) *lxcConfig {
+	cfg := &lxcConfig{}
+	for _, item := range e.Items {
+		cfg.items = append(cfg.items, lxcConfigItem{
+			Key:   item.Key,
+			Value: strings.Replace(item.Value, configCacheIDPlaceholder, containerID, -1),
+		})
+	}
+	for _, f := range e.Files {
+		path := strings.Replace(f.Path, configCacheIDPlaceholder, containerID, -1)
+		data := []byte(strings.Replace(string(f.Data), configCacheIDPlaceholder, containerID, -1))
+		cfg.files = append(cfg.files, lxcConfigFile{path, data})
+	}
+	return cfg
+}
+
+func readConfigCache(key string) (*configCacheEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(configCacheDir(), key+".json"))
+	if err != nil {
+		return nil, err
+	}
+	entry := &configCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func writeConfigCache(key string, entry *configCacheEntry) error {
+	if err := os.MkdirAll(configCacheDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(configCacheDir(), key+".json"), data, 0600); err != nil {
+		return err
+	}
+	return pruneConfigCache()
+}
+
+// pruneConfigCache removes the oldest entries beyond maxConfigCacheEntries.
+func pruneConfigCache() error {
+	entries, err := ioutil.ReadDir(configCacheDir())
+	if err != nil {
+		return err
+	}
+	if len(entries) <= maxConfigCacheEntries {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, fi := range entries[:len(entries)-maxConfigCacheEntries] {
+		os.Remove(filepath.Join(configCacheDir(), fi.Name()))
+	}
+	return nil
+}
+
+// translateContainer is configureContainer, going through the cache with
+// --config-cache. What depends on the files the spec's paths name is
+// looked at once the container ID is filled in, see resolveBindCreate.
+func translateContainer(ctx *cli.Context, containerID string, spec *specs.Spec) (*lxcConfig, error) {
+	if !ctx.GlobalBool("config-cache") {
+		cfg := &lxcConfig{}
+		if err := configureContainer(ctx, cfg, containerID, spec); err != nil {
+			return nil, err
+		}
+		resolveBindCreate(cfg)
+		return cfg, nil
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	templated := []byte(strings.Replace(string(data), containerID, configCacheIDPlaceholder, -1))
+	templatedSpec := &specs.Spec{}
+	if err := json.Unmarshal(templated, templatedSpec); err != nil {
+		return nil, err
+	}
+	key, err := configCacheKey(ctx, templatedSpec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hash spec")
+	}
+
+	var cfg *lxcConfig
+	if entry, err := readConfigCache(key); err == nil {
+		log.Debugf("using cached config %s", key)
+		cfg = entry.instantiate(containerID)
+	} else {
+		translated := &lxcConfig{}
+		if err := configureContainer(ctx, translated, configCacheIDPlaceholder, templatedSpec); err != nil {
+			return nil, err
+		}
+		entry := &configCacheEntry{Items: translated.items}
+		for _, f := range translated.files {
+			entry.Files = append(entry.Files, configCachedFile{Path: f.path, Data: f.data})
+		}
+		// the cache is an optimization, creates don't fail with it
+		if err := writeConfigCache(key, entry); err != nil {
+			log.Warnf("failed to cache config: %v", err)
+		}
+		cfg = entry.instantiate(containerID)
+	}
+	resolveBindCreate(cfg)
+	return cfg, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestConfigCacheSpec(t *testing.T) {
+	newSpec := func(name string) *specs.Spec {
+		return &specs.Spec{
+			Version: "1.0.2",
+			Process: &specs.Process{
+				Args: []string{"/bin/" + name},
+				Env:  []string{"NAME=" + name, "NVIDIA_VISIBLE_DEVICES=0"},
+				Cwd:  "/",
+			},
+			Hooks: &specs.Hooks{Prestart: []specs.Hook{{Path: "/usr/bin/" + name}}},
+			Annotations: map[string]string{
+				"io.kubernetes.cri-o.ContainerName":                 name,
+				ANNOTATION_SECCOMP:                                  seccompRuntimeDefault,
+				LXC_CONFIG_ANNOTATION_PREFIX + "lxc.prlimit.nofile": "1024",
+			},
+		}
+	}
+	a, b := configCacheSpec(newSpec("a")), configCacheSpec(newSpec("b"))
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("specs that translate the same differ:\n%+v\n%+v", a, b)
+	}
+	if len(a.Annotations) != 2 {
+		t.Errorf("kept annotations %v, want the crio-lxc and lxc config ones", a.Annotations)
+	}
+	if !reflect.DeepEqual(a.Process.Env, []string{"NVIDIA_VISIBLE_DEVICES=0"}) {
+		t.Errorf("kept environment %v, want the NVIDIA variables", a.Process.Env)
+	}
+
+	c := newSpec("a")
+	c.Process.Cwd = "/tmp"
+	if reflect.DeepEqual(a, configCacheSpec(c)) {
+		t.Errorf("specs that translate differently are the same")
+	}
+}
+
+func TestResolveBindCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crio-lxc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &lxcConfig{items: []lxcConfigItem{
+		{Key: "lxc.mount.entry", Value: dir + " mnt none rbind,ro," + bindCreatePlaceholder},
+		{Key: "lxc.mount.entry", Value: file + " etc/resolv.conf none bind," + bindCreatePlaceholder},
+		{Key: "lxc.mount.entry", Value: filepath.Join(dir, "missing") + " missing none bind," + bindCreatePlaceholder},
+		{Key: "lxc.mount.entry", Value: "proc proc proc nosuid"},
+	}}
+	resolveBindCreate(cfg)
+	want := []string{
+		dir + " mnt none rbind,ro,create=dir",
+		file + " etc/resolv.conf none bind,create=file",
+		filepath.Join(dir, "missing") + " missing none bind",
+		"proc proc proc nosuid",
+	}
+	for i, item := range cfg.items {
+		if item.Value != want[i] {
+			t.Errorf("mount entry %q, want %q", item.Value, want[i])
+		}
+	}
+}
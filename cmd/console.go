@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"unsafe"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// openPty allocates a new pseudo terminal pair, returning the master and
+// the slave.
+func openPty() (*os.File, *os.File, error) {
+	masterFd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open /dev/ptmx")
+	}
+	master := os.NewFile(uintptr(masterFd), "/dev/ptmx")
+
+	unlock := 0
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, master.Fd(), unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		return nil, nil, errors.Wrap(errno, "failed to unlock pty")
+	}
+
+	ptyNum, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.Wrap(err, "failed to get pty number")
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNum)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.Wrapf(err, "failed to open pty slave '%s'", slavePath)
+	}
+
+	return master, slave, nil
+}
+
+// setConsoleSize sets the initial window size of the pty from the spec.
+func setConsoleSize(pty *os.File, size *specs.Box) error {
+	if size == nil {
+		return nil
+	}
+	ws := &unix.Winsize{
+		Row: uint16(size.Height),
+		Col: uint16(size.Width),
+	}
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// sendConsole passes the pty master to the caller over the console socket,
+// as described in runc's docs/terminals.md.
+func sendConsole(socketPath string, master *os.File) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to console socket '%s'", socketPath)
+	}
+	defer conn.Close()
+
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("console socket '%s' is not a unix socket", socketPath)
+	}
+
+	oob := unix.UnixRights(int(master.Fd()))
+	if _, _, err := uc.WriteMsgUnix([]byte(master.Name()), oob, nil); err != nil {
+		return errors.Wrap(err, "failed to send pty master")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var consoleCmd = cli.Command{
+	Name:   "console",
+	Usage:  "attaches the terminal to the console of a running container",
+	Action: doConsole,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to attach to
+`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "tty",
+			Usage: "tty to attach to, 0 is the console",
+		},
+		cli.StringFlag{
+			Name:  "escape",
+			Usage: "detach with Ctrl+<escape> q",
+			Value: "a",
+		},
+	},
+}
+
+func doConsole(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "console", 1)
+	}
+
+	escape := ctx.String("escape")
+	if len(escape) != 1 || escape[0] < 'a' || escape[0] > 'z' {
+		return fmt.Errorf("escape must be a letter from a to z")
+	}
+	if _, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS); err != nil {
+		return fmt.Errorf("console needs a terminal on stdin")
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if !running {
+		return errNotRunning(containerID, "cannot attach to console")
+	}
+
+	opts := lxc.DefaultConsoleOptions
+	opts.Tty = ctx.Int("tty")
+	opts.StdinFd = os.Stdin.Fd()
+	opts.StdoutFd = os.Stdout.Fd()
+	opts.StderrFd = os.Stderr.Fd()
+	// liblxc takes the control character, 1 for Ctrl+a
+	opts.EscapeCharacter = rune(escape[0]-'a') + 1
+
+	fmt.Fprintf(os.Stderr, "Connected to tty %d of %s, type <Ctrl+%s q> to detach\n\n", opts.Tty, containerID, escape)
+	if err := c.Console(opts); err != nil {
+		return errors.Wrap(err, "failed to attach to console")
+	}
+	return nil
+}
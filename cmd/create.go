@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"golang.org/x/sys/unix"
 
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -28,14 +32,60 @@ var createCmd = cli.Command{
 			Usage: "set bundle directory",
 			Value: ".",
 		},
-		cli.IntFlag{
+		cli.StringFlag{
 			Name:  "console-socket",
-			Usage: "pty master FD", // TODO not handled yet
+			Usage: "path to a unix socket that will receive the pty master",
 		},
 		cli.StringFlag{
 			Name:  "pid-file",
 			Usage: "path to write container PID", // TODO not handled yet
 		},
+		cli.BoolFlag{
+			Name:  "attachable",
+			Usage: "relay the container's stdio, so that attach can connect to it",
+		},
+		cli.IntFlag{
+			Name:  "preserve-fds",
+			Usage: "pass N additional file descriptors to the container (stdio + $LISTEN_FDS + N in total)",
+		},
+		cli.BoolFlag{
+			Name:  "no-pivot",
+			Usage: "switch to the rootfs with chroot instead of pivot_root (liblxc only does when / is the initramfs)",
+		},
+		cli.BoolFlag{
+			Name:  "no-new-keyring",
+			Usage: "do not create a new session keyring for the container",
+		},
+		cli.StringFlag{
+			Name:   "network-bridge",
+			Usage:  "attach containers with a new network namespace to this bridge",
+			EnvVar: "CRIO_LXC_NETWORK_BRIDGE",
+		},
+		cli.StringFlag{
+			Name:  "network-address",
+			Usage: "static address (CIDR) for --network-bridge, DHCP is expected otherwise",
+		},
+		cli.StringFlag{
+			Name:  "network-gateway",
+			Usage: "default gateway for --network-address",
+		},
+		cli.StringFlag{
+			Name:   "cni-network",
+			Usage:  "set up containers with a new network namespace using this CNI network",
+			EnvVar: "CRIO_LXC_CNI_NETWORK",
+		},
+		cli.StringFlag{
+			Name:   "cni-conf-dir",
+			Usage:  "directory with CNI network configs",
+			Value:  "/etc/cni/net.d",
+			EnvVar: "CRIO_LXC_CNI_CONF_DIR",
+		},
+		cli.StringFlag{
+			Name:   "cni-bin-dir",
+			Usage:  "directory with CNI plugins",
+			Value:  "/opt/cni/bin",
+			EnvVar: "CRIO_LXC_CNI_BIN_DIR",
+		},
 	},
 }
 
@@ -47,149 +97,586 @@ func doCreate(ctx *cli.Context) error {
 	}
 	log.Infof("creating container %s", containerID)
 
-	exists, err := containerExists(containerID)
-	if err != nil {
-		return errors.Wrap(err, "failed to check if container exists")
+	// cri-o sends SIGTERM when create takes too long, roll back then as
+	// well as on errors so that the ID can be used again. The daemon's
+	// signals stop the daemon once the create it runs is done.
+	rb := &createRollback{containerID: containerID}
+	if !daemonMode {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, unix.SIGTERM, unix.SIGINT, unix.SIGHUP)
+		defer signal.Stop(signals)
+		go func() {
+			sig := <-signals
+			rb.run()
+			err := fmt.Errorf("create of '%s' interrupted by %s", containerID, sig)
+			log.Errorf("%v", err)
+			// the spans of interrupted creates are the interesting ones
+			flushTracing(err)
+			os.Exit(128 + int(sig.(unix.Signal)))
+		}()
 	}
-	if exists {
-		return fmt.Errorf("container '%s' already exists", containerID)
+
+	started := time.Now()
+	err := createContainer(ctx, containerID, rb)
+	// the runtime dir is gone when create was rolled back
+	if err == nil {
+		recordTimings(containerID, "create", started, nil)
+		notifyState(ctx, containerID, "created")
 	}
+	return err
+}
 
+func createContainer(ctx *cli.Context, containerID string, rb *createRollback) (retErr error) {
 	c, err := lxc.NewContainer(containerID, LXC_PATH)
 	if err != nil {
 		return errors.Wrap(err, "failed to create new container")
 	}
 	defer c.Release()
 
-	spec, err := readBundleSpec(filepath.Join(ctx.String("bundle"), "config.json"))
+	checkNoPivot(ctx)
+
+	bundle, err := resolveBundle(ctx.String("bundle"))
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve bundle path")
+	}
+
+	sp := startSpan("parse spec")
+	spec, err := readBundleSpec(filepath.Join(bundle, "config.json"))
+	sp.End(err)
 	if err != nil {
 		return errors.Wrap(err, "couldn't load bundle spec")
 	}
 
-	if err := os.MkdirAll(filepath.Join(LXC_PATH, containerID), 0770); err != nil {
+	if err := normalizeSpec(spec); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+	if err := checkSeccompErrnoRet(bundle); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+	if err := validateSpec(spec); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+
+	if err := resolveBundlePaths(bundle, spec); err != nil {
+		return errors.Wrap(err, "invalid bundle paths")
+	}
+
+	runtimeCfg, err := loadRuntimeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	runtimeCfg.applyToSpec(spec)
+
+	processExt, err := readBundleProcessExt(bundle)
+	if err != nil {
+		return errors.Wrap(err, "couldn't load bundle spec")
+	}
+	var sched *schedAttr
+	if processExt.Scheduler != nil {
+		sched, err = schedAttrOf(processExt.Scheduler)
+		if err != nil {
+			return errors.Wrap(err, "invalid process scheduler")
+		}
+	}
+	ioprio := -1
+	if processExt.IOPriority != nil {
+		ioprio, err = ioprioOf(processExt.IOPriority)
+		if err != nil {
+			return errors.Wrap(err, "invalid process IO priority")
+		}
+		if processExt.IOPriority.Class == "IOPRIO_CLASS_RT" && !hostSysAdmin() {
+			log.Warnf("not setting the realtime IO class without CAP_SYS_ADMIN on the host")
+			ioprio = -1
+		}
+	}
+
+	if err := validateContainerID(containerID); err != nil {
+		return err
+	}
+
+	lock, err := lockContainer(containerID)
+	if err != nil {
+		return err
+	}
+	// roll back before releasing the lock, so no other create sees the
+	// partial state
+	defer func() {
+		if retErr != nil {
+			rb.run()
+		}
+		lock.Close()
+	}()
+
+	// Checked under the lock, so that two creates of the same ID can't
+	// both succeed.
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if exists {
+		return errExists(containerID)
+	}
+	if err := checkIDCollision(containerID); err != nil {
+		return err
+	}
+	rb.setOwnsDir()
+
+	if err := os.MkdirAll(lxcDir(containerID), 0770); err != nil {
 		return errors.Wrap(err, "failed to create container dir")
 	}
+	if err := checkInside(LXC_PATH, lxcDir(containerID)); err != nil {
+		return err
+	}
+	if err := checkInside(RUNTIME_ROOT, runtimeDir(containerID)); err != nil {
+		return err
+	}
+
+	md := &containerMetadata{Bundle: bundle, Annotations: spec.Annotations, OCIVersion: spec.Version}
+	if err := writeMetadata(containerID, md); err != nil {
+		return errors.Wrap(err, "failed to save container metadata")
+	}
 
-	if err := makeSyncFifo(filepath.Join(LXC_PATH, containerID)); err != nil {
-		return errors.Wrap(err, "failed to make sync fifo")
+	preserveFds, err := preservedFds(ctx)
+	if err != nil {
+		return err
+	}
+	// the sync socket is passed after the preserved fds
+	if err := makeSyncDir(containerID, spec, 3+preserveFds); err != nil {
+		return errors.Wrap(err, "failed to make sync dir")
+	}
+
+	if ctx.GlobalBool("debug") {
+		c.SetVerbosity(lxc.Verbose)
 	}
 
-	if err := configureContainer(ctx, c, spec); err != nil {
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	sp = startSpan("translate config")
+	cfg, err := translateContainer(ctx, containerID, spec)
+	sp.End(err)
+	if err != nil {
 		return errors.Wrap(err, "failed to configure container")
 	}
+	// not part of the translation, whose cache works on the spec
+	if err := configureUnified(cfg, bundle); err != nil {
+		return errors.Wrap(err, "failed to configure cgroup v2 resources")
+	}
+	if err := runtimeCfg.configureDevices(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure default devices")
+	}
+	// the monitor logs to the same file as this process, so that errors
+	// of the start are found there
+	logFile := lxcLogFile(ctx, containerID)
+	if files := cfg.Get("lxc.log.file"); len(files) > 0 {
+		logFile = files[len(files)-1]
+	} else if err := cfg.Set("lxc.log.file", logFile); err != nil {
+		return errors.Wrap(err, "failed to set log file")
+	}
+
+	hooks, err := containerOCIHooks(ctx, bundle, spec)
+	if err != nil {
+		return errors.Wrap(err, "failed to load oci hooks")
+	}
+	if err := configureOCIHooks(cfg, containerID, hooks); err != nil {
+		return errors.Wrap(err, "failed to configure oci hooks")
+	}
+
+	if err := loadAppArmorProfiles(cfg); err != nil {
+		return errors.Wrap(err, "failed to load apparmor profile")
+	}
+
+	// for auditing which profiles were applied, and whether by request
+	if md.Seccomp, err = seccompSource(ctx, spec); err != nil {
+		return err
+	}
+	md.AppArmor = appliedAppArmor(cfg)
+	if md.RealtimeBudget, err = prepareRealtimeBudget(spec); err != nil {
+		return errors.Wrap(err, "failed to prepare realtime budget")
+	}
+	if err := writeMetadata(containerID, md); err != nil {
+		releaseRealtimeBudget(md.RealtimeBudget)
+		return errors.Wrap(err, "failed to save container metadata")
+	}
+
+	if err := relabelMounts(spec); err != nil {
+		return errors.Wrap(err, "failed to relabel mounts")
+	}
+
+	if err := writeEnvFile(containerID, spec.Process.Env); err != nil {
+		return errors.Wrap(err, "failed to write environment file")
+	}
+
+	// Write out final config file for debugging and use with lxc-attach,
+	// the internal command starts the container from it.
+	savedConfigFile := lxcPath(containerID, configFileName)
+	sp = startSpan("write config")
+	err = cfg.Write(savedConfigFile)
+	sp.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save config file to '%s'", savedConfigFile)
+	}
+	// Loading the config makes liblxc check it before anything is started.
+	// Start from an empty config, not whatever liblxc may have loaded
+	// when the container object was created.
+	c.ClearConfig()
+	sp = startSpan("load config")
+	err = c.LoadConfigFile(savedConfigFile)
+	sp.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load config file '%s'", savedConfigFile)
+	}
+
+	proxyPid, err := startNotifyProxy(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to start notify proxy")
+	}
+	rb.addProcess(proxyPid)
+
+	log.Infof("created sync dir, executing %#v", spec.Process.Args)
+
+	logOffset := lxcLogOffset(logFile)
+	sp = startSpan("lxc start")
+	err = startContainer(ctx, c, spec, rb)
+	sp.End(err)
+	if err != nil {
+		return errors.Wrap(withLXCErrors(err, logFile, logOffset), "failed to start the container init")
+	}
+
+	// The init execs the container process, which keeps the scheduling
+	// attributes and IO priority.
+	if sched != nil || ioprio >= 0 {
+		pid, err := containerInitPid(c, containerID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get container init pid")
+		}
+		if pid == 0 {
+			return errNotRunning(containerID, "init exited before its scheduling was set")
+		}
+		if sched != nil {
+			if err := setSchedAttr(pid, sched); err != nil {
+				return errors.Wrap(err, "failed to set the scheduler of the container init")
+			}
+		}
+		if ioprio >= 0 {
+			if err := setIOPrio(pid, ioprio); err != nil {
+				return errors.Wrap(err, "failed to set the IO priority of the container init")
+			}
+		}
+	}
 
-	log.Infof("created syncfifo, executing %#v", spec.Process.Args)
+	oomPid, err := startOOMMonitor(containerID)
+	if err != nil {
+		return err
+	}
+	rb.addProcess(oomPid)
 
-	if err := startContainer(c, spec); err != nil {
-		return errors.Wrap(err, "failed to start the container init")
+	if network := ctx.String("cni-network"); network != "" && wantsOwnNetwork(spec) {
+		md, err := readMetadata(containerID)
+		if err != nil {
+			return err
+		}
+		sp = startSpan("cni add")
+		err = cniAdd(containerID, ctx.String("cni-conf-dir"), ctx.String("cni-bin-dir"), network, md.InitPid, md.InitStartTime)
+		sp.End(err)
+		if err != nil {
+			return errors.Wrap(err, "failed to set up CNI network")
+		}
 	}
 
 	log.Infof("created container %s in lxcdir %s", containerID, LXC_PATH)
 	return nil
 }
 
-func configureContainer(ctx *cli.Context, c *lxc.Container, spec *specs.Spec) error {
-	if ctx.Bool("debug") {
-		c.SetVerbosity(lxc.Verbose)
+func configureContainer(ctx *cli.Context, cfg *lxcConfig, containerID string, spec *specs.Spec) error {
+	if err := configureDefaults(ctx, cfg); err != nil {
+		return errors.Wrap(err, "failed to configure lxc defaults")
 	}
 
-	if err := configureLogging(ctx, c); err != nil {
-		return errors.Wrap(err, "failed to configure logging")
+	if err := configureLogLevel(cfg, spec); err != nil {
+		return err
 	}
 
 	// rootfs
 	// todo Root.Readonly? - use lxc.rootfs.options
-	if err := c.SetConfigItem("lxc.rootfs.path", spec.Root.Path); err != nil {
+	if err := cfg.Set("lxc.rootfs.path", spec.Root.Path); err != nil {
 		return errors.Wrapf(err, "failed to set rootfs: '%s'", spec.Root.Path)
 	}
-	if err := c.SetConfigItem("lxc.rootfs.managed", "0"); err != nil {
+	if err := cfg.Set("lxc.rootfs.managed", "0"); err != nil {
 		return errors.Wrap(err, "failed to set rootfs.managed to 0")
 	}
 
-	for _, envVar := range spec.Process.Env {
-		if err := c.SetConfigItem("lxc.environment", envVar); err != nil {
-			return fmt.Errorf("error setting environment variable '%s': %v", envVar, err)
-		}
-	}
-
 	for _, ms := range spec.Mounts {
-		opts := strings.Join(ms.Options, ",")
-		mnt := fmt.Sprintf("%s %s %s %s", ms.Source, ms.Destination, ms.Type, opts)
-		if err := c.SetConfigItem("lxc.mount.entry", mnt); err != nil {
+		// relabeling is done by create, see relabelMounts
+		options := withoutRelabelOptions(ms.Options)
+		if ms.Type == "proc" {
+			var err error
+			options, err = procMountOptions(ctx, spec, options)
+			if err != nil {
+				return err
+			}
+		}
+		if isBindMount(ms) {
+			if create := bindCreateOption(ms, options); create != "" {
+				options = append(options, create)
+			}
+		}
+		opts := strings.Join(options, ",")
+		// relative to the rootfs, lxc ignores absolute mount points
+		// outside of lxc.rootfs.path
+		mnt := fmt.Sprintf("%s %s %s %s", ms.Source, strings.TrimPrefix(ms.Destination, "/"), ms.Type, opts)
+		if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
 			return errors.Wrap(err, "failed to set mount config")
 		}
 	}
 
-	mnt := fmt.Sprintf("/var/lib/lxc/%s/syncfifo syncfifo none ro,bind,create=file", c.Name())
-	if err := c.SetConfigItem("lxc.mount.entry", mnt); err != nil {
-		return errors.Wrap(err, "failed to set syncfifo mount config entry")
+	if err := configureSync(cfg, containerID); err != nil {
+		return errors.Wrap(err, "failed to configure sync")
+	}
+
+	if err := configureNotifySocket(cfg, containerID); err != nil {
+		return errors.Wrap(err, "failed to configure notify socket")
 	}
 
-	if err := c.SetConfigItem("lxc.init.cwd", spec.Process.Cwd); err != nil {
+	if err := cfg.Set("lxc.init.cwd", spec.Process.Cwd); err != nil {
 		return errors.Wrap(err, "failed to set CWD")
 	}
 
-	if err := c.SetConfigItem("lxc.uts.name", spec.Hostname); err != nil {
-		return errors.Wrap(err, "failed to set hostname")
+	if err := configureUTS(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure UTS namespace")
 	}
 
-	argsString := strings.Join(spec.Process.Args, " ")
-	if err := c.SetConfigItem("lxc.execute.cmd", argsString); err != nil {
-		return errors.Wrap(err, "failed to set lxc.execute.cmd")
+	if err := configureNetwork(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure network")
+	}
+
+	privileged := isPrivileged(spec)
+	if privileged {
+		log.Debugf("configuring a privileged container")
+		if err := configurePrivileged(cfg, spec); err != nil {
+			return errors.Wrap(err, "failed to configure privileged container")
+		}
+	} else if err := configureAppArmor(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure apparmor")
+	}
+
+	if err := configureRelativeCgroups(cfg); err != nil {
+		return errors.Wrap(err, "failed to configure relative cgroups")
+	}
 
+	if err := configureCgroupDir(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure cgroup path")
 	}
-	if err := c.SetConfigItem("lxc.hook.version", "1"); err != nil {
+
+	if err := configureRlimits(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure rlimits")
+	}
+
+	if err := configureResources(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure resources")
+	}
+
+	if err := cfg.Set("lxc.hook.version", "1"); err != nil {
 		return errors.Wrap(err, "failed to set hook version")
 	}
 
-	// capabilities?
+	if !privileged {
+		if err := configureCapabilities(cfg, spec); err != nil {
+			return errors.Wrap(err, "failed to configure capabilities")
+		}
+	}
+
+	if err := configureSeccomp(ctx, cfg, containerID, spec); err != nil {
+		return errors.Wrap(err, "failed to configure seccomp")
+	}
+
+	if err := configureKeyring(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure session keyring")
+	}
+
+	if err := configureGPUs(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure GPUs")
+	}
+
+	if err := configureLXCHooks(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure lxc hooks")
+	}
+
+	if err := configureRawConfig(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to apply raw lxc config")
+	}
 
 	// if !spec.Process.Terminal {
 	// 	passFdsToContainer()
 	// }
 
-	// Write out final config file for debugging and use with lxc-attach:
-	// Do not edit config after this.
-	savedConfigFile := filepath.Join(lxc.DefaultConfigPath(), c.Name(), "config")
-	if err := c.SaveConfigFile(savedConfigFile); err != nil {
-		return errors.Wrapf(err, "failed to save config file to '%s'", savedConfigFile)
+	return checkIsolated(ctx, cfg)
+}
+
+// configureKeyring disables the per container session keyring, which
+// liblxc creates by default, for hosts where the keyctl syscalls are blocked.
+func configureKeyring(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	noNewKeyring, err := annotationBool(spec, ANNOTATION_NO_NEW_KEYRING, ctx.Bool("no-new-keyring"))
+	if err != nil {
+		return err
 	}
+	if !noNewKeyring {
+		return nil
+	}
+	return cfg.Set("lxc.keyring.session", "0")
+}
 
-	return nil
+func envFilePath(containerID string) string {
+	return runtimePath(containerID, envFileName)
 }
 
-func makeSyncFifo(dir string) error {
-	fifoFilename := filepath.Join(dir, "syncfifo")
-	prevMask := unix.Umask(0000)
-	defer unix.Umask(prevMask)
-	if err := unix.Mkfifo(fifoFilename, 0622); err != nil {
-		return errors.Wrapf(err, "failed to make fifo '%s'", fifoFilename)
+// writeEnvFile writes the process environment NUL separated, so that the
+// internal command can pass values through to lxc verbatim.
+func writeEnvFile(containerID string, env []string) error {
+	var buf bytes.Buffer
+	for _, envVar := range env {
+		if i := strings.Index(envVar, "="); i <= 0 {
+			return fmt.Errorf("invalid environment variable '%s'", envVar)
+		}
+		if strings.Contains(envVar, "\x00") {
+			return fmt.Errorf("environment variable '%s' contains a NUL byte", envVar)
+		}
+		buf.WriteString(envVar)
+		buf.WriteByte(0)
 	}
-	return nil
+	return writeFileAtomic(envFilePath(containerID), buf.Bytes(), 0600)
 }
 
-func startContainer(c *lxc.Container, spec *specs.Spec) error {
+// stdio is what create passes to the container as its stdio, the CLI's
+// own or, in the daemon, that of the CLI that forwarded the create.
+var stdio = []*os.File{os.Stdin, os.Stdout, os.Stderr}
+
+// attachStdio connects cmd to our stdio, or for terminal processes to a new
+// pty whose master is sent to --console-socket. The returned function
+// closes our copies of the pty.
+func attachStdio(ctx *cli.Context, cmd *exec.Cmd, process *specs.Process) (func(), error) {
+	if !process.Terminal {
+		cmd.Stdin = stdio[0]
+		cmd.Stdout = stdio[1]
+		cmd.Stderr = stdio[2]
+		return func() {}, nil
+	}
+
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to allocate pty")
+	}
+	closePty := func() {
+		master.Close()
+		slave.Close()
+	}
+
+	if err := setConsoleSize(master, process.ConsoleSize); err != nil {
+		closePty()
+		return nil, errors.Wrap(err, "failed to set console size")
+	}
+
+	if ctx.IsSet("console-socket") {
+		if err := sendConsole(ctx.String("console-socket"), master); err != nil {
+			closePty()
+			return nil, errors.Wrap(err, "failed to send console")
+		}
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true, Setctty: true}
+	return closePty, nil
+}
+
+func startContainer(ctx *cli.Context, c *lxc.Container, spec *specs.Spec, rb *createRollback) error {
 	binary, err := os.Readlink("/proc/self/exe")
 	if err != nil {
 		return err
 	}
 
+	// "internal" is handled by the C constructor in internal.go before
+	// the go runtime starts, see internalCmd.
 	cmd := exec.Command(
 		binary,
 		"internal",
 		c.Name(),
-		spec.Root.Path,
-		filepath.Join("/var/lib/lxc", c.Name(), "config"),
+		LXC_PATH,
+		lxcPath(c.Name(), configFileName),
+		envFilePath(c.Name()),
+		exitStatusPath(c.Name()),
 	)
 
-	if !spec.Process.Terminal {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	var closeStdio func()
+	if ctx.Bool("attachable") {
+		var relayPid int
+		closeStdio, relayPid, err = relayStdio(ctx, c.Name(), cmd, spec.Process)
+		rb.addProcess(relayPid)
+	} else {
+		closeStdio, err = attachStdio(ctx, cmd, spec.Process)
 	}
+	if err != nil {
+		return err
+	}
+	defer closeStdio()
 
-	cmdErr := cmd.Start()
+	// Pass fds 3 .. 3+N-1 through the "internal" process, lxc will
+	// inherit them into the container init since we don't daemonize.
+	preserveFds, err := preservedFds(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < preserveFds; i++ {
+		fd := uintptr(3 + i)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, os.NewFile(fd, fmt.Sprintf("fd%d", fd)))
+	}
+	syncSocket, err := listenSyncSocket(c.Name())
+	if err != nil {
+		return err
+	}
+	defer syncSocket.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, syncSocket)
+	setMonitorEnv(ctx, cmd)
 
-	return cmdErr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	syncSocket.Close()
+	rb.setMonitor(cmd.Process.Pid)
+
+	// The internal process becomes the lxc monitor, which starts the
+	// container init.
+	if err := recordInitPid(c, c.Name()); err != nil {
+		log.Warnf("failed to record init pid, falling back to lxc queries: %v", err)
+	}
+	return waitSyncSocket(c.Name())
+}
+
+// listenFds is the number of socket activation fds passed to the runtime.
+func listenFds() (int, error) {
+	listenFds := os.Getenv("LISTEN_FDS")
+	if listenFds == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(listenFds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LISTEN_FDS value '%s'", listenFds)
+	}
+	return n, nil
+}
 
+// preservedFds is the number of fds after stdio that are passed on to the
+// container process: the socket activation ones and --preserve-fds.
+func preservedFds(ctx *cli.Context) (int, error) {
+	preserveFds := ctx.Int("preserve-fds")
+	if preserveFds < 0 {
+		return 0, fmt.Errorf("invalid --preserve-fds value %d", preserveFds)
+	}
+	n, err := listenFds()
+	if err != nil {
+		return 0, err
+	}
+	return preserveFds + n, nil
 }
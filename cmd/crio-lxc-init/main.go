@@ -0,0 +1,162 @@
+// crio-lxc-init is bind mounted into a container and run by lxc as its
+// init. It waits on the sync socket until "crio-lxc start" connects, then
+// execs the container process. If that fails, the reason is sent back over
+// the socket so that start can report it; on success the socket is closed
+// by the exec. See the initsync package for the protocol.
+//
+// exec runs it as "init exec <options> command...", to set up what lxc
+// attach can't before the command runs: e.g. LISTEN_PID must be the pid
+// the command has in the container, which only the process itself knows.
+//
+// It must be built statically (CGO_ENABLED=0), since it runs against the
+// container's rootfs.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/lxc/crio-lxc/cmd/internal/initsync"
+	"golang.org/x/sys/unix"
+)
+
+func fail(conn net.Conn, kind string, err error) {
+	msg := initsync.InitError{Kind: kind, Message: err.Error()}
+	fmt.Fprintf(os.Stderr, "crio-lxc-init: %s: %s\n", msg.Kind, msg.Message)
+	if conn != nil {
+		json.NewEncoder(conn).Encode(msg)
+		conn.Close()
+	}
+	os.Exit(1)
+}
+
+func execError(err error) string {
+	switch err {
+	case unix.ENOENT:
+		return "missing binary"
+	case unix.EACCES, unix.EPERM:
+		return "permission denied"
+	default:
+		return "exec failure"
+	}
+}
+
+// lookPath finds the binary of a command in $PATH, unless it is a path.
+func lookPath(name string) (string, error) {
+	if filepath.Base(name) != name {
+		return name, nil
+	}
+	return exec.LookPath(name)
+}
+
+// execWithOptions applies opts and execs args. The credentials and
+// no_new_privs are set for this thread only, which is the one that execs.
+func execWithOptions(opts initsync.ExecOptions, args []string) {
+	runtime.LockOSThread()
+	for _, rl := range opts.Rlimits {
+		if err := unix.Setrlimit(rl.Resource, &unix.Rlimit{Cur: rl.Soft, Max: rl.Hard}); err != nil {
+			fail(nil, "setup failure", fmt.Errorf("failed to set rlimit %d: %v", rl.Resource, err))
+		}
+	}
+	if opts.User != nil {
+		gids := []int{}
+		for _, gid := range opts.User.AdditionalGids {
+			gids = append(gids, int(gid))
+		}
+		if err := unix.Setgroups(gids); err != nil {
+			fail(nil, "setup failure", fmt.Errorf("failed to set additional groups: %v", err))
+		}
+		if err := unix.Setresgid(int(opts.User.GID), int(opts.User.GID), int(opts.User.GID)); err != nil {
+			fail(nil, "setup failure", fmt.Errorf("failed to set gid: %v", err))
+		}
+		if err := unix.Setresuid(int(opts.User.UID), int(opts.User.UID), int(opts.User.UID)); err != nil {
+			fail(nil, "setup failure", fmt.Errorf("failed to set uid: %v", err))
+		}
+	}
+	if opts.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			fail(nil, "setup failure", fmt.Errorf("failed to set no_new_privs: %v", err))
+		}
+	}
+	if opts.ListenFds > 0 {
+		os.Setenv("LISTEN_FDS", strconv.Itoa(opts.ListenFds))
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+	path, err := lookPath(args[0])
+	if err != nil {
+		fail(nil, "missing binary", err)
+	}
+	err = unix.Exec(path, args, os.Environ())
+	fail(nil, execError(err), fmt.Errorf("failed to exec '%s': %v", path, err))
+}
+
+// waitStart answers pings until start connects, and returns start's
+// connection.
+func waitStart(l net.Listener) net.Conn {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fail(nil, "setup failure", err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		switch {
+		case err == nil && line == initsync.Ping:
+			conn.Write([]byte(initsync.Ping))
+			conn.Close()
+		case err == nil && line == initsync.Start:
+			return conn
+		default:
+			fail(conn, "protocol error", fmt.Errorf("unexpected message %q", line))
+		}
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == initsync.ExecArg {
+		var opts initsync.ExecOptions
+		if len(os.Args) < 4 || json.Unmarshal([]byte(os.Args[2]), &opts) != nil {
+			fail(nil, "setup failure", fmt.Errorf("usage: %s %s <options> command...", os.Args[0], initsync.ExecArg))
+		}
+		execWithOptions(opts, os.Args[3:])
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(initsync.DirInContainer, initsync.ArgsName))
+	if err != nil {
+		fail(nil, "setup failure", err)
+	}
+	var args initsync.Args
+	if err := json.Unmarshal(data, &args); err != nil {
+		fail(nil, "setup failure", err)
+	}
+	if len(args.Args) == 0 {
+		fail(nil, "setup failure", fmt.Errorf("no process args"))
+	}
+
+	// the listener is a dup, the inherited fd must not reach the
+	// container process
+	f := os.NewFile(uintptr(args.SocketFd), initsync.SocketName)
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		fail(nil, "setup failure", err)
+	}
+	conn := waitStart(l)
+	l.Close()
+
+	path, err := lookPath(args.Args[0])
+	if err != nil {
+		fail(conn, "missing binary", err)
+	}
+
+	// conn is close-on-exec, so start sees EOF once the exec succeeded.
+	err = unix.Exec(path, args.Args, os.Environ())
+	fail(conn, execError(err), fmt.Errorf("failed to exec '%s': %v", path, err))
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// The daemon serves create, start, kill and state over gRPC on a unix
+// socket. It keeps liblxc loaded and the container handles open, so that
+// on nodes with many short lived containers state doesn't pay for
+// initializing liblxc. The commands run in the daemon itself, one at a
+// time since they keep their state in globals. The CLI forwards these
+// commands to it when --daemon-socket is given, with all the flags it got.
+//
+// The service is registered by hand and its messages are JSON, there are
+// no generated protobuf types. Before the client talks gRPC on a new
+// connection it sends its stdio with SCM_RIGHTS, which create passes to
+// the container like the CLI would its own.
+
+const (
+	daemonSocketName  = "crio-lxc.sock"
+	daemonServiceName = "crio_lxc.Daemon"
+	// daemonHandshakeTimeout is how long the daemon waits for the stdio
+	// of a new connection.
+	daemonHandshakeTimeout = 5 * time.Second
+)
+
+var daemonCmd = cli.Command{
+	Name:   "daemon",
+	Usage:  "serves create, start, kill and state to CLI invocations with --daemon-socket",
+	Action: doDaemon,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "socket",
+			Usage: "unix socket to listen on (default: <root>/" + daemonSocketName + ")",
+		},
+	},
+}
+
+// daemonMode is set in the daemon, whose signals stop the daemon and not
+// the command it runs.
+var daemonMode = false
+
+// DaemonRequest is the request of all daemon methods.
+type DaemonRequest struct {
+	// Args are the command's flags followed by the container ID, with
+	// paths made absolute by the client.
+	Args []string
+}
+
+// DaemonReply carries errors with their kind and exit code, which the
+// client turns back into the error the command would have returned.
+type DaemonReply struct {
+	State     *specs.State
+	Error     string
+	ErrorKind string
+	ExitCode  int
+}
+
+func (r *DaemonReply) setError(err error) {
+	if err == nil {
+		return
+	}
+	r.Error = err.Error()
+	r.ErrorKind, r.ExitCode = errorKind(err)
+}
+
+func (r *DaemonReply) err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return &runtimeError{r.ErrorKind, r.ExitCode, r.Error}
+}
+
+// daemonCodec encodes the messages as JSON.
+type daemonCodec struct{}
+
+func (daemonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (daemonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (daemonCodec) String() string {
+	return "json"
+}
+
+// daemonService is what the service desc's methods are served by.
+type daemonService interface {
+	serve(ctx context.Context, method string, req *DaemonRequest) *DaemonReply
+}
+
+func daemonMethod(method string) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &DaemonRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(daemonService).serve(ctx, method, req), nil
+		},
+	}
+}
+
+var daemonServiceDesc = grpc.ServiceDesc{
+	ServiceName: daemonServiceName,
+	HandlerType: (*daemonService)(nil),
+	Methods: []grpc.MethodDesc{
+		daemonMethod("Create"),
+		daemonMethod("Start"),
+		daemonMethod("Kill"),
+		daemonMethod("State"),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "daemon.go",
+}
+
+// daemonCommands are the commands of the methods the daemon runs.
+var daemonCommands = map[string]string{
+	"Create": "create",
+	"Start":  "start",
+	"Kill":   "kill",
+}
+
+// stdioAddr is the remote address of a connection, with the stdio the
+// client sent.
+type stdioAddr struct {
+	net.Addr
+	stdio []*os.File
+}
+
+type stdioConn struct {
+	*net.UnixConn
+	addr *stdioAddr
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *stdioConn) Close() error {
+	for _, f := range c.addr.stdio {
+		f.Close()
+	}
+	return c.UnixConn.Close()
+}
+
+// stdioListener receives the stdio of each connection before gRPC gets
+// it. Connections that don't send it are dropped, an error would stop
+// the server.
+type stdioListener struct {
+	*net.UnixListener
+}
+
+func (l stdioListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		stdio, err := receiveStdio(conn)
+		if err != nil {
+			log.Warnf("failed to receive the stdio of a client: %v", err)
+			conn.Close()
+			continue
+		}
+		return &stdioConn{UnixConn: conn, addr: &stdioAddr{Addr: conn.RemoteAddr(), stdio: stdio}}, nil
+	}
+}
+
+func receiveStdio(conn *net.UnixConn) ([]*os.File, error) {
+	conn.SetReadDeadline(time.Now().Add(daemonHandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(3*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("no stdio sent")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, fmt.Errorf("no stdio sent")
+	}
+	if len(fds) != 3 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		return nil, fmt.Errorf("%d fds sent instead of stdio", len(fds))
+	}
+	stdio := []*os.File{}
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		unix.CloseOnExec(fds[i])
+		stdio = append(stdio, os.NewFile(uintptr(fds[i]), name))
+	}
+	return stdio, nil
+}
+
+// sendStdio is the client's side of receiveStdio.
+func sendStdio(conn *net.UnixConn) error {
+	oob := unix.UnixRights(int(os.Stdin.Fd()), int(os.Stdout.Fd()), int(os.Stderr.Fd()))
+	_, _, err := conn.WriteMsgUnix([]byte{0}, oob, nil)
+	return err
+}
+
+// Daemon is the gRPC service.
+type Daemon struct {
+	// globalCtx is the context of the daemon's global flags, which the
+	// commands are run with.
+	globalCtx *cli.Context
+
+	// commandMu serializes the commands.
+	commandMu sync.Mutex
+
+	containersMu sync.Mutex
+	containers   map[string]*lxc.Container
+}
+
+func (d *Daemon) serve(ctx context.Context, method string, req *DaemonRequest) *DaemonReply {
+	reply := &DaemonReply{}
+	if len(req.Args) == 0 {
+		reply.setError(fmt.Errorf("missing container ID"))
+		return reply
+	}
+	containerID := req.Args[len(req.Args)-1]
+	if err := validateContainerID(containerID); err != nil {
+		reply.setError(err)
+		return reply
+	}
+
+	if method == "State" {
+		c, err := d.container(containerID)
+		if err == nil {
+			reply.State, err = containerState(c, containerID)
+		}
+		reply.setError(err)
+		return reply
+	}
+
+	name, ok := daemonCommands[method]
+	if !ok {
+		reply.setError(fmt.Errorf("unknown method %s", method))
+		return reply
+	}
+	var callerStdio []*os.File
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(*stdioAddr); ok {
+			callerStdio = addr.stdio
+		}
+	}
+	if callerStdio == nil {
+		reply.setError(fmt.Errorf("the client sent no stdio"))
+		return reply
+	}
+	reply.setError(d.run(name, req.Args, callerStdio))
+	return reply
+}
+
+// run runs a command of the app with the caller's stdio, as the CLI
+// would have run it.
+func (d *Daemon) run(name string, args []string, callerStdio []*os.File) error {
+	d.commandMu.Lock()
+	defer d.commandMu.Unlock()
+
+	command := *d.globalCtx.App.Command(name)
+	// the client sent what its environment set, not the daemon's
+	command.Flags = flagsWithoutEnv(command.Flags)
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range command.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 1 {
+		return fmt.Errorf("expected the container ID after the flags")
+	}
+	ctx := cli.NewContext(d.globalCtx.App, set, d.globalCtx)
+	ctx.Command = command
+
+	// beforeCommand sets the command and its log fields, configureLogging
+	// per container log levels, the daemon's are restored afterwards
+	level := log.InfoLevel
+	if logger, ok := log.Log.(*log.Logger); ok {
+		level = logger.Level
+	}
+	daemonCommand := command
+	stdio = callerStdio
+	defer func() {
+		stdio = []*os.File{os.Stdin, os.Stdout, os.Stderr}
+		log.SetLevel(level)
+		command = daemonCommand
+		logFields["command"] = daemonCommand
+		delete(logFields, "id")
+	}()
+
+	if err := beforeCommand(ctx); err != nil {
+		return err
+	}
+	err := command.Action.(func(*cli.Context) error)(ctx)
+	flushTracing(err)
+	return err
+}
+
+// flagsWithoutEnv returns the flags without their environment variables.
+func flagsWithoutEnv(flags []cli.Flag) []cli.Flag {
+	stripped := []cli.Flag{}
+	for _, f := range flags {
+		switch f := f.(type) {
+		case cli.BoolFlag:
+			f.EnvVar = ""
+			stripped = append(stripped, f)
+		case cli.StringFlag:
+			f.EnvVar = ""
+			stripped = append(stripped, f)
+		case cli.IntFlag:
+			f.EnvVar = ""
+			stripped = append(stripped, f)
+		case cli.StringSliceFlag:
+			f.EnvVar = ""
+			stripped = append(stripped, f)
+		default:
+			stripped = append(stripped, f)
+		}
+	}
+	return stripped
+}
+
+// container returns the cached handle of a container, dropping it once
+// the container is gone.
+func (d *Daemon) container(containerID string) (*lxc.Container, error) {
+	d.containersMu.Lock()
+	defer d.containersMu.Unlock()
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return nil, err
+	}
+	c, cached := d.containers[containerID]
+	if !exists {
+		if cached {
+			c.Release()
+			delete(d.containers, containerID)
+		}
+		return nil, errNotFound(containerID)
+	}
+	if cached {
+		return c, nil
+	}
+	c, err = lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load container")
+	}
+	d.containers[containerID] = c
+	return c, nil
+}
+
+func daemonSocketPath(ctx *cli.Context) string {
+	if socket := ctx.String("socket"); socket != "" {
+		return socket
+	}
+	return filepath.Join(RUNTIME_ROOT, daemonSocketName)
+}
+
+func doDaemon(ctx *cli.Context) error {
+	if ctx.GlobalIsSet("daemon-socket") {
+		return fmt.Errorf("the daemon can't forward to another daemon")
+	}
+	daemonMode = true
+	// the fds of socket activation of the daemon aren't the containers'
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+
+	if err := os.MkdirAll(RUNTIME_ROOT, stateDirMode()); err != nil {
+		return errors.Wrapf(err, "failed to create '%s'", RUNTIME_ROOT)
+	}
+	socketPath := daemonSocketPath(ctx)
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove stale daemon socket")
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on '%s'", socketPath)
+	}
+	defer os.Remove(socketPath)
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return errors.Wrap(err, "failed to chmod daemon socket")
+	}
+
+	globalCtx := ctx.Parent()
+	if globalCtx == nil {
+		globalCtx = ctx
+	}
+	daemon := &Daemon{globalCtx: globalCtx, containers: map[string]*lxc.Container{}}
+	server := grpc.NewServer(grpc.CustomCodec(daemonCodec{}))
+	server.RegisterService(&daemonServiceDesc, daemon)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT)
+	go func() {
+		<-signals
+		// lets the running command finish
+		server.GracefulStop()
+	}()
+
+	log.Infof("daemon listening on %s", socketPath)
+	return server.Serve(stdioListener{listener})
+}
+
+// daemonPathFlags are the flags of the forwarded commands that are paths,
+// the client resolves them against its working directory.
+var daemonPathFlags = map[string]bool{
+	"bundle":         true,
+	"console-socket": true,
+	"pid-file":       true,
+	"cni-conf-dir":   true,
+	"cni-bin-dir":    true,
+}
+
+// daemonArgs returns the flags the command was invoked with, and those
+// its environment set, followed by the container ID. A flag that can't be
+// forwarded fails the command rather than be dropped.
+func daemonArgs(ctx *cli.Context, containerID string) ([]string, error) {
+	args := []string{}
+	for _, f := range ctx.Command.Flags {
+		name := strings.TrimSpace(strings.Split(f.GetName(), ",")[0])
+		// the bundle defaults to the client's working directory
+		if !ctx.IsSet(name) && name != "bundle" {
+			continue
+		}
+		switch f.(type) {
+		case cli.BoolFlag:
+			args = append(args, "--"+name+"="+strconv.FormatBool(ctx.Bool(name)))
+		case cli.IntFlag:
+			args = append(args, "--"+name+"="+strconv.Itoa(ctx.Int(name)))
+		case cli.StringFlag:
+			value := ctx.String(name)
+			if daemonPathFlags[name] && value != "" {
+				abs, err := filepath.Abs(value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to resolve --%s", name)
+				}
+				value = abs
+			}
+			args = append(args, "--"+name+"="+value)
+		case cli.StringSliceFlag:
+			for _, value := range ctx.StringSlice(name) {
+				args = append(args, "--"+name+"="+value)
+			}
+		default:
+			return nil, fmt.Errorf("--%s can't be forwarded to the daemon", name)
+		}
+	}
+	return append(args, containerID), nil
+}
+
+// forwardToDaemon wraps the action of a command that the daemon serves, it
+// is used unless --daemon-socket is given.
+func forwardToDaemon(method string, action func(*cli.Context) error) func(*cli.Context) error {
+	return func(ctx *cli.Context) error {
+		socket := ctx.GlobalString("daemon-socket")
+		// the daemon doesn't read stats or run batches
+		if socket == "" || (method == "State" && ctx.Bool("stats")) || (method == "Kill" && isBatch(ctx)) {
+			return action(ctx)
+		}
+		containerID := ctx.Args().Get(0)
+		if len(containerID) == 0 {
+			fmt.Fprintf(os.Stderr, "missing container ID\n")
+			cli.ShowCommandHelpAndExit(ctx, ctx.Command.Name, 1)
+		}
+		// only stdio is passed to the daemon
+		if method == "Create" && (ctx.Int("preserve-fds") > 0 || os.Getenv("LISTEN_FDS") != "") {
+			return fmt.Errorf("file descriptors besides stdio can't be passed through the daemon")
+		}
+
+		req := &DaemonRequest{Args: []string{containerID}}
+		if method != "State" {
+			args, err := daemonArgs(ctx, containerID)
+			if err != nil {
+				return err
+			}
+			req.Args = args
+		}
+
+		socket, err := filepath.Abs(socket)
+		if err != nil {
+			return errors.Wrap(err, "failed to resolve daemon socket path")
+		}
+		conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithDialer(dialDaemon))
+		if err != nil {
+			return errors.Wrap(err, "failed to connect to daemon")
+		}
+		defer conn.Close()
+
+		reply := &DaemonReply{}
+		err = conn.Invoke(context.Background(), "/"+daemonServiceName+"/"+method, req, reply, grpc.CallCustomCodec(daemonCodec{}))
+		if err != nil {
+			return errors.Wrap(err, "daemon call failed")
+		}
+		if err := reply.err(); err != nil {
+			return err
+		}
+		if reply.State != nil {
+			stateJson, err := json.Marshal(reply.State)
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal json")
+			}
+			os.Stdout.Write(stateJson)
+		}
+		return nil
+	}
+}
+
+// dialDaemon connects to the daemon and sends it our stdio.
+func dialDaemon(socket string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendStdio(conn.(*net.UnixConn)); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to send stdio to the daemon")
+	}
+	return conn, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestDaemonArgs(t *testing.T) {
+	set := flag.NewFlagSet("create", flag.ContinueOnError)
+	for _, f := range createCmd.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--bundle", "bundle", "--attachable", "--cni-network", "net", "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := cli.NewContext(cli.NewApp(), set, nil)
+	ctx.Command = createCmd
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := daemonArgs(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--bundle=" + filepath.Join(cwd, "bundle"), "--attachable=true", "--cni-network=net", "foo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("daemonArgs = %q, want %q", got, want)
+	}
+}
+
+func TestDaemonArgsUnsupportedFlag(t *testing.T) {
+	command := cli.Command{Name: "create", Flags: []cli.Flag{cli.DurationFlag{Name: "wait"}}}
+	set := flag.NewFlagSet("create", flag.ContinueOnError)
+	for _, f := range command.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--wait", "1s", "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := cli.NewContext(cli.NewApp(), set, nil)
+	ctx.Command = command
+	if _, err := daemonArgs(ctx, "foo"); err == nil {
+		t.Errorf("daemonArgs forwarded a flag it has no encoding for")
+	}
+}
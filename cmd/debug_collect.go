@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var debugCollectCmd = cli.Command{
+	Name:   "debug-collect",
+	Usage:  "collects diagnostics about a container into a tarball",
+	Action: doDebugCollect,
+	ArgsUsage: `[containerID]
+
+<containerID> is the ID of the container to collect diagnostics for
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "path of the tarball to write (default <containerID>-debug.tar.gz)",
+		},
+		cli.IntFlag{
+			Name:  "journal-lines",
+			Usage: "number of recent journal entries to include",
+			Value: 500,
+		},
+	},
+}
+
+// debugExcludedFiles are not collected from the container dir. The env
+// file may hold secrets.
+var debugExcludedFiles = map[string]bool{
+	"env": true,
+}
+
+func doDebugCollect(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "debug-collect", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	output := ctx.String("output")
+	if output == "" {
+		output = containerID + "-debug.tar.gz"
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create '%s'", output)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	prefix := containerID + "-debug"
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    filepath.Join(prefix, name),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	// saved lxc config and logs from the lxc dir, metadata and anything
+	// else from the runtime dir
+	dirs := map[string]string{
+		"lxc":     lxcDir(containerID),
+		"runtime": runtimeDir(containerID),
+	}
+	for name, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Warnf("skipping %s: %v", dir, err)
+			continue
+		}
+		for _, fi := range files {
+			if !fi.Mode().IsRegular() || debugExcludedFiles[fi.Name()] {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				log.Warnf("skipping %s: %v", fi.Name(), err)
+				continue
+			}
+			if err := add(filepath.Join(name, fi.Name()), data); err != nil {
+				return errors.Wrapf(err, "failed to add %s", fi.Name())
+			}
+		}
+	}
+
+	if ctx.GlobalIsSet("log-file") {
+		if data, err := ioutil.ReadFile(ctx.GlobalString("log-file")); err == nil {
+			if err := add("lxc.log", data); err != nil {
+				return errors.Wrap(err, "failed to add lxc log")
+			}
+		}
+	}
+
+	if err := add("sync.txt", []byte(describeSync(containerID))); err != nil {
+		return errors.Wrap(err, "failed to add sync state")
+	}
+
+	if err := add("state.json", collectState(containerID)); err != nil {
+		return errors.Wrap(err, "failed to add state")
+	}
+
+	journal, err := exec.Command("journalctl", "--no-pager", "-o", "short-precise",
+		"-n", fmt.Sprintf("%d", ctx.Int("journal-lines")),
+		"CONTAINER_ID="+containerID).CombinedOutput()
+	if err != nil {
+		journal = append(journal, []byte(fmt.Sprintf("\njournalctl failed: %v\n", err))...)
+	}
+	if err := add("journal.txt", journal); err != nil {
+		return errors.Wrap(err, "failed to add journal")
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish tarball")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish tarball")
+	}
+	fmt.Fprintf(os.Stdout, "wrote %s\n", output)
+	return nil
+}
+
+func describeSync(containerID string) string {
+	socketPath := syncSocketPath(containerID)
+	fi, err := os.Lstat(socketPath)
+	if os.IsNotExist(err) {
+		return "sync socket does not exist (container was started, or its init never came up)\n"
+	}
+	if err != nil {
+		return fmt.Sprintf("failed to stat sync socket: %v\n", err)
+	}
+	return fmt.Sprintf("sync socket exists, mode %s\n", fi.Mode())
+}
+
+func collectState(containerID string) []byte {
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return []byte(fmt.Sprintf("failed to load container: %v\n", err))
+	}
+	defer c.Release()
+
+	s, err := containerState(c, containerID)
+	if err != nil {
+		return []byte(fmt.Sprintf("failed to get state: %v\n", err))
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return []byte(fmt.Sprintf("failed to marshal state: %v\n", err))
+	}
+	return append(data, '\n')
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// The generated config is complete by itself: it doesn't include the
+// distribution's default.conf or common configs, whose networks and idmaps
+// would make OCI containers behave differently from host to host.
+// Operators who rely on them can opt in with --lxc-defaults.
+
+// configureDefaults includes liblxc's default config when enabled. It has
+// to come first, so that the generated items override it.
+func configureDefaults(ctx *cli.Context, cfg *lxcConfig) error {
+	if !ctx.GlobalBool("lxc-defaults") {
+		return nil
+	}
+	defaultConfig := lxc.GlobalConfigItem("lxc.default_config")
+	if defaultConfig == "" {
+		return fmt.Errorf("liblxc has no default config")
+	}
+	if err := cfg.Set("lxc.include", defaultConfig); err != nil {
+		return errors.Wrapf(err, "failed to include '%s'", defaultConfig)
+	}
+	return nil
+}
+
+// checkIsolated makes sure nothing else pulled in outside config.
+func checkIsolated(ctx *cli.Context, cfg *lxcConfig) error {
+	if ctx.GlobalBool("lxc-defaults") {
+		return nil
+	}
+	if includes := cfg.Get("lxc.include"); len(includes) > 0 {
+		return fmt.Errorf("config includes %v, but --lxc-defaults is not enabled", includes)
+	}
+	return nil
+}
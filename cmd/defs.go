@@ -1,6 +1,51 @@
 package main
 
 var (
-	CURRENT_OCI_VERSION = "0.2.1"
-	LXC_PATH            = "/var/lib/lxc"
+	LXC_PATH = "/var/lib/lxc"
+	// RUNTIME_ROOT holds crio-lxc's own per container state, LXC_PATH
+	// only what liblxc needs.
+	RUNTIME_ROOT = "/run/crio-lxc"
+)
+
+// Annotations understood by crio-lxc, all prefixed with ANNOTATION_PREFIX.
+const (
+	ANNOTATION_PREFIX         = "org.linuxcontainers.crio-lxc."
+	ANNOTATION_NO_NEW_KEYRING = ANNOTATION_PREFIX + "no-new-keyring"
+	// ANNOTATION_GPUS is "all", "none" or a comma separated list of GPU
+	// indexes or UUIDs, as for NVIDIA_VISIBLE_DEVICES.
+	ANNOTATION_GPUS = ANNOTATION_PREFIX + "gpus"
+	// ANNOTATION_SECCOMP is "runtime/default" or "unconfined", for
+	// containers without a seccomp config in the spec.
+	ANNOTATION_SECCOMP = ANNOTATION_PREFIX + "seccomp"
+	// ANNOTATION_PROC_HARDENING is "off", "hidepid" or "subset", see
+	// procMountOptions.
+	ANNOTATION_PROC_HARDENING = ANNOTATION_PREFIX + "proc-hardening"
+	// ANNOTATION_NUMA_ALIGN overrides --numa-align.
+	ANNOTATION_NUMA_ALIGN = ANNOTATION_PREFIX + "numa-align"
+	// ANNOTATION_CPU_IDLE "true" makes the container a best-effort
+	// scavenger with cpu.idle.
+	ANNOTATION_CPU_IDLE = ANNOTATION_PREFIX + "cpu-idle"
+	// ANNOTATION_OOM_KILLED is reported in the state as "true" once a
+	// process of the container was OOM killed.
+	ANNOTATION_OOM_KILLED = ANNOTATION_PREFIX + "oom-killed"
+	// ANNOTATION_EXIT_CODE and ANNOTATION_FINISHED_AT (RFC 3339) are
+	// reported in the state once the container init exited.
+	ANNOTATION_EXIT_CODE   = ANNOTATION_PREFIX + "exit-code"
+	ANNOTATION_FINISHED_AT = ANNOTATION_PREFIX + "finished-at"
+	// ANNOTATION_SECCOMP_APPLIED (spec, runtime/default, unconfined or
+	// lxc-default) and ANNOTATION_APPARMOR_APPLIED (the profile) are
+	// reported in the state.
+	ANNOTATION_SECCOMP_APPLIED  = ANNOTATION_PREFIX + "seccomp-applied"
+	ANNOTATION_APPARMOR_APPLIED = ANNOTATION_PREFIX + "apparmor-applied"
+	// ANNOTATION_LOG_LEVEL overrides --log-level for the container, it
+	// takes the same levels.
+	ANNOTATION_LOG_LEVEL = ANNOTATION_PREFIX + "log-level"
+	// ANNOTATION_LXC_HOOK_PREFIX followed by an lxc hook type, like
+	// pre-mount, adds the hook of that name in --lxc-hooks-dir.
+	ANNOTATION_LXC_HOOK_PREFIX = ANNOTATION_PREFIX + "hook."
+
+	// LXC_CONFIG_ANNOTATION_PREFIX followed by an lxc config key sets
+	// the key, if --raw-config-allow allows it. The "lxc." of the key
+	// may be left out.
+	LXC_CONFIG_ANNOTATION_PREFIX = "org.linuxcontainers.lxc.config."
 )
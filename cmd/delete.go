@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 
 	lxc "gopkg.in/lxc/go-lxc.v2"
 )
@@ -15,26 +17,58 @@ var deleteCmd = cli.Command{
 	Name:   "delete",
 	Usage:  "deletes a container",
 	Action: doDelete,
-	ArgsUsage: `[containerID]
+	ArgsUsage: `[containerID...]
 
-<containerID> is the ID of the container to delete
+<containerID> is the ID of the container to delete, several are deleted
+concurrently
 `,
-	Flags: []cli.Flag{},
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "kill the container if it is still running",
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "with --force, send SIGTERM first and SIGKILL only after this many seconds",
+		},
+		cli.BoolFlag{
+			Name:   "ignore-missing",
+			Usage:  "succeed if the container doesn't exist, e.g. when delete is retried",
+			EnvVar: "CRIO_LXC_DELETE_IGNORE_MISSING",
+		},
+		cli.BoolFlag{
+			Name:   "keep",
+			Usage:  "keep the lxc config, logs and runtime state of the container for post-mortem debugging",
+			EnvVar: "CRIO_LXC_DELETE_KEEP",
+		},
+	}, batchFlags...),
 }
 
 func doDelete(ctx *cli.Context) error {
+	if isBatch(ctx) {
+		return runBatch(ctx, deleteContainer)
+	}
 	containerID := ctx.Args().Get(0)
 	if len(containerID) == 0 {
 		fmt.Fprintf(os.Stderr, "missing container ID\n")
 		cli.ShowCommandHelpAndExit(ctx, "state", 1)
 	}
+	return deleteContainer(ctx, containerID)
+}
 
+func deleteContainer(ctx *cli.Context, containerID string) error {
 	exists, err := containerExists(containerID)
 	if err != nil {
 		return errors.Wrap(err, "failed to check if container exists")
 	}
 	if !exists {
-		return fmt.Errorf("container '%s' not found", containerID)
+		if err := removeLeftovers(containerID); err != nil {
+			return errors.Wrap(err, "failed to remove leftovers of container")
+		}
+		if ctx.Bool("ignore-missing") {
+			return nil
+		}
+		return errNotFound(containerID)
 	}
 
 	c, err := lxc.NewContainer(containerID, LXC_PATH)
@@ -48,8 +82,41 @@ func doDelete(ctx *cli.Context) error {
 
 	}
 
-	if c.Running() {
-		return fmt.Errorf("container '%s' is running, cannot delete.", containerID)
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if running {
+		if !ctx.Bool("force") {
+			return errRunning(containerID, "cannot delete")
+		}
+		timeout := time.Duration(ctx.Int("timeout")) * time.Second
+		if err := forceStop(c, containerID, timeout); err != nil {
+			return err
+		}
+	}
+
+	// CNI DEL is best effort, a plugin that is gone or a netns that
+	// was removed already must not make the container undeletable
+	sp := startSpan("cni del")
+	err = cniDel(containerID)
+	sp.End(err)
+	if err != nil {
+		log.Warnf("failed to tear down CNI network of %s: %v", containerID, err)
+	}
+
+	// the container's cgroup is gone once it is stopped
+	releaseContainerRealtimeBudget(containerID)
+
+	// Moving the lxc dir away undefines the container as destroying it
+	// would, with the rootfs left alone either way.
+	if ctx.Bool("keep") {
+		dir, err := keepArtifacts(containerID)
+		if err != nil {
+			return err
+		}
+		log.Infof("kept container %s in %s", containerID, dir)
+		return nil
 	}
 
 	// TODO: lxc-destroy deletes the rootfs.
@@ -60,16 +127,76 @@ func doDelete(ctx *cli.Context) error {
 	// that resources associated with the container, but not
 	// created by this container, MUST NOT be deleted.
 
-	if err := c.Destroy(); err != nil {
+	sp = startSpan("lxc destroy")
+	// liblxc refuses to destroy a container with snapshots, Snapshots
+	// returns an error if there are none
+	if snaps, _ := c.Snapshots(); len(snaps) > 0 {
+		err = c.DestroyAllSnapshots()
+	}
+	if err == nil {
+		err = c.Destroy()
+	}
+	sp.End(err)
+	if err != nil {
 		return errors.Wrap(err, "failed to delete container.")
 	}
 
 	// TODO - because we set rootfs.managed=0, Destroy() doesn't
-	// delete the /var/lib/lxc/$containerID/config file:
-	configDir := filepath.Join(LXC_PATH, containerID)
+	// delete the /var/lib/lxc/$containerID/config file.
+	// This also removes the default lxc log and its rotated copies.
+	configDir := lxcDir(containerID)
 	if err := os.RemoveAll(configDir); err != nil {
 		return errors.Wrapf(err, "failed to remove %s", configDir)
 	}
 
+	if err := os.RemoveAll(runtimeDir(containerID)); err != nil {
+		return errors.Wrapf(err, "failed to remove %s", runtimeDir(containerID))
+	}
+
 	return nil
 }
+
+// forceStop stops a running container, gracefully first if timeout isn't 0.
+func forceStop(c *lxc.Container, containerID string, timeout time.Duration) error {
+	pid, err := containerInitPid(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get container init pid")
+	}
+	if pid == 0 {
+		return nil
+	}
+	if timeout > 0 {
+		if err := unix.Kill(pid, unix.SIGTERM); err != nil && err != unix.ESRCH {
+			return errors.Wrap(err, "failed to send SIGTERM")
+		}
+	}
+	return escalateKill(c, containerID, pid, timeout)
+}
+
+// removeLeftovers removes what an interrupted create or delete left behind
+// of a container that doesn't exist, so that a retried delete cleans up.
+// The lock keeps it from removing a container that is being created.
+func removeLeftovers(containerID string) error {
+	exists, err := pathExists(runtimeDir(containerID))
+	if err != nil || !exists {
+		return err
+	}
+	lock, err := lockContainer(containerID)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	// the create may have finished before the lock was taken
+	if exists, err := containerExists(containerID); err != nil || exists {
+		return err
+	}
+	if err := cniDel(containerID); err != nil {
+		log.Warnf("failed to tear down CNI network of %s: %v", containerID, err)
+	}
+	releaseContainerRealtimeBudget(containerID)
+	if err := os.RemoveAll(lxcDir(containerID)); err != nil {
+		return err
+	}
+	return os.RemoveAll(runtimeDir(containerID))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var deviceAddCmd = cli.Command{
+	Name:   "device-add",
+	Usage:  "adds a device node to a running container",
+	Action: doDeviceAdd,
+	ArgsUsage: `<containerID> <device> [path]
+
+<containerID> is the ID of the container to add the device to
+<device> is the host device node, e.g. /dev/fuse
+[path] is where the node is created in the container, defaults to <device>
+`,
+}
+
+// doDeviceAdd creates the device node in the container's mount namespace
+// and allows it in the container's devices cgroup, for device plugins that
+// hotplug devices into running containers.
+func doDeviceAdd(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	device := ctx.Args().Get(1)
+	if containerID == "" || device == "" {
+		fmt.Fprintf(os.Stderr, "missing container ID or device\n")
+		cli.ShowCommandHelpAndExit(ctx, "device-add", 1)
+	}
+	dest := ctx.Args().Get(2)
+	if dest == "" {
+		dest = device
+	}
+
+	if !hostSysAdmin() {
+		return fmt.Errorf("adding devices requires CAP_SYS_ADMIN on the host")
+	}
+
+	fi, err := os.Stat(device)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat device '%s'", device)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("'%s' is not a device node", device)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check container state")
+	}
+	if !running {
+		return errNotRunning(containerID, "cannot add device")
+	}
+
+	sp := startSpan("lxc add device")
+	sp.attrs["device"] = device
+	err = c.AddDeviceNode(device, dest)
+	sp.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to add device '%s'", device)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Exit codes, so that callers can tell common failures apart without
+// parsing messages.
+const (
+	exitInternal   = 1
+	exitNotFound   = 2
+	exitExists     = 3
+	exitNotRunning = 4
+	exitRunning    = 5
+	exitInvalidID  = 6
+)
+
+// runtimeError is an error with a well known kind and exit code.
+type runtimeError struct {
+	kind string
+	code int
+	msg  string
+}
+
+func (e *runtimeError) Error() string {
+	return e.msg
+}
+
+func errNotFound(containerID string) error {
+	return &runtimeError{"not found", exitNotFound, fmt.Sprintf("container '%s' not found", containerID)}
+}
+
+func errExists(containerID string) error {
+	return &runtimeError{"already exists", exitExists, fmt.Sprintf("container '%s' already exists", containerID)}
+}
+
+func errInvalidID(containerID string, reason string) error {
+	return &runtimeError{"invalid id", exitInvalidID, fmt.Sprintf("invalid container ID '%s': %s", containerID, reason)}
+}
+
+func errNotRunning(containerID string, detail string) error {
+	msg := fmt.Sprintf("container '%s' is not running", containerID)
+	if detail != "" {
+		msg += ", " + detail
+	}
+	return &runtimeError{"not running", exitNotRunning, msg}
+}
+
+func errRunning(containerID string, detail string) error {
+	msg := fmt.Sprintf("container '%s' is running", containerID)
+	if detail != "" {
+		msg += ", " + detail
+	}
+	return &runtimeError{"running", exitRunning, msg}
+}
+
+// exitStatusError passes the exit status of a container process through
+// as the runtime's exit code. It is not a runtime failure, so nothing is
+// printed for it.
+type exitStatusError struct {
+	code int
+}
+
+func (e *exitStatusError) Error() string {
+	return fmt.Sprintf("process exited with status %d", e.code)
+}
+
+// errExitStatus converts a wait status the way shells do: the exit code
+// for normal exits and 128+signal for processes killed by a signal.
+func errExitStatus(status unix.WaitStatus) error {
+	code := status.ExitStatus()
+	if status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	if code == 0 {
+		return nil
+	}
+	return &exitStatusError{code}
+}
+
+// errorKind returns the kind and exit code for err, looking through
+// wrapped errors.
+func errorKind(err error) (string, int) {
+	switch e := errors.Cause(err).(type) {
+	case *runtimeError:
+		return e.kind, e.code
+	case *exitStatusError:
+		return "exit status", e.code
+	}
+	return "internal", exitInternal
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var eventsCmd = cli.Command{
+	Name:   "events",
+	Usage:  "prints the events of a container as JSON lines",
+	Action: doEvents,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to print the events of
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-follow",
+			Usage: "only print the events so far instead of waiting for new ones until the container stops",
+		},
+		cli.BoolFlag{
+			Name:  "stats",
+			Usage: "also print the container's resource usage and pressure as stats events",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "interval of stats and pressure threshold checks",
+			Value: 5 * time.Second,
+		},
+		cli.StringSliceFlag{
+			Name:  "pressure-threshold",
+			Usage: "print a pressure event when the 10s average of RESOURCE=PERCENT (cpu, memory or io) is exceeded",
+		},
+	},
+}
+
+// containerEvent is an event recorded by one of the container's helper
+// processes, see appendEvent.
+type containerEvent struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+func eventsPath(containerID string) string {
+	return runtimePath(containerID, eventsFileName)
+}
+
+// appendEvent records an event. Lines are written with a single append,
+// so concurrent writers don't interleave.
+func appendEvent(containerID string, eventType string, data interface{}) error {
+	ev := containerEvent{Type: eventType, ID: containerID, Time: time.Now().UTC(), Data: data}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(eventsPath(containerID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func doEvents(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "events", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	thresholds, err := parsePressureThresholds(ctx.StringSlice("pressure-threshold"))
+	if err != nil {
+		return err
+	}
+	interval := ctx.Duration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	watcher := &statsWatcher{
+		containerID: containerID,
+		stats:       ctx.Bool("stats"),
+		thresholds:  thresholds,
+		exceeded:    map[string]bool{},
+	}
+
+	f, err := os.OpenFile(eventsPath(containerID), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open events")
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	stopped := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			os.Stdout.Write(line)
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "failed to read events")
+		}
+		if ctx.Bool("no-follow") || stopped {
+			if ctx.Bool("no-follow") {
+				watcher.check(c)
+			}
+			return nil
+		}
+
+		// at the end for now, print what comes next until the
+		// container stopped, including what was recorded as it
+		// stopped
+		running, err := containerRunning(c, containerID)
+		if err != nil {
+			return err
+		}
+		stopped = !running
+		if running {
+			if time.Since(watcher.last) >= interval {
+				watcher.check(c)
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+		// a partial line is read again once it is complete
+		if len(line) > 0 {
+			if _, err := f.Seek(-int64(len(line)), io.SeekCurrent); err != nil {
+				return err
+			}
+			reader.Reset(f)
+		}
+	}
+}
+
+// statsWatcher prints stats events and pressure events, which are not
+// recorded but generated by the events command itself.
+type statsWatcher struct {
+	containerID string
+	stats       bool
+	thresholds  map[string]float64
+	// exceeded has the resources above their threshold, so that only
+	// crossing it prints an event.
+	exceeded map[string]bool
+	last     time.Time
+}
+
+func (w *statsWatcher) print(eventType string, data interface{}) {
+	ev := containerEvent{Type: eventType, ID: w.containerID, Time: time.Now().UTC(), Data: data}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	os.Stdout.Write(append(line, '\n'))
+}
+
+func (w *statsWatcher) check(c *lxc.Container) {
+	w.last = time.Now()
+	if !w.stats && len(w.thresholds) == 0 {
+		return
+	}
+	pid, err := containerInitPid(c, w.containerID)
+	if err != nil || pid == 0 {
+		return
+	}
+	stats, err := readContainerStats(pid)
+	if err != nil {
+		log.Debugf("failed to read container stats: %v", err)
+		return
+	}
+	if w.stats {
+		w.print("stats", stats)
+	}
+	for resource, threshold := range w.thresholds {
+		psi, ok := stats.Pressure[resource]
+		if !ok {
+			continue
+		}
+		exceeded := psi.Some.Avg10 > threshold
+		if exceeded && !w.exceeded[resource] {
+			w.print("pressure", map[string]interface{}{
+				"resource":  resource,
+				"threshold": threshold,
+				"pressure":  psi,
+			})
+		}
+		w.exceeded[resource] = exceeded
+	}
+}
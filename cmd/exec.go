@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/lxc/crio-lxc/cmd/internal/initsync"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var execCmd = cli.Command{
+	Name:   "exec",
+	Usage:  "runs a process in a running container",
+	Action: doExec,
+	ArgsUsage: `<containerID> [command [args...]]
+
+<containerID> is the ID of the container to run the process in
+[command [args...]] is the process to run, unless --process is given
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "process, p",
+			Usage: "path to a process.json describing the process to run",
+		},
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "allocate a pty for the process",
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Usage: "path to a unix socket that will receive the pty master",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "run the process as uid[:gid]",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set the environment variable K=V (repeatable)",
+		},
+		cli.StringFlag{
+			Name:  "cwd",
+			Usage: "working directory of the process",
+		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "return once the process is started instead of waiting for it",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Usage: "write the host pid of the process to this file",
+		},
+		cli.IntFlag{
+			Name:  "preserve-fds",
+			Usage: "pass N additional file descriptors to the process (stdio + $LISTEN_FDS + N in total)",
+		},
+		cli.StringFlag{
+			Name:  "exec-id",
+			Usage: "record the process under this ID, for resize-tty --exec-id",
+		},
+	},
+}
+
+func readProcessSpec(path string) (*specs.Process, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	process := &specs.Process{}
+	if err := json.NewDecoder(f).Decode(process); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse '%s'", path)
+	}
+	return process, nil
+}
+
+// execProcess builds the process to exec from --process or the command
+// line arguments, with the other flags overriding its settings.
+func execProcess(ctx *cli.Context) (*specs.Process, error) {
+	var process *specs.Process
+	if ctx.IsSet("process") {
+		var err error
+		process, err = readProcessSpec(ctx.String("process"))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		args := ctx.Args().Tail()
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing command or --process")
+		}
+		process = &specs.Process{
+			Args: args,
+			Cwd:  "/",
+			Env:  []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		}
+	}
+
+	if ctx.Bool("tty") {
+		process.Terminal = true
+	}
+	if ctx.IsSet("cwd") {
+		process.Cwd = ctx.String("cwd")
+	}
+	if ctx.IsSet("user") {
+		user, err := parseUser(ctx.String("user"))
+		if err != nil {
+			return nil, err
+		}
+		process.User = user
+	}
+	for _, env := range ctx.StringSlice("env") {
+		var err error
+		process.Env, err = setEnv(process.Env, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return process, nil
+}
+
+// containerProcess returns the process of the container's spec, nil if
+// the bundle can't be read.
+func containerProcess(containerID string) *specs.Process {
+	md, err := readMetadata(containerID)
+	if err != nil {
+		log.Warnf("failed to read metadata of %s: %v", containerID, err)
+		return nil
+	}
+	spec, err := readBundleSpec(filepath.Join(md.Bundle, "config.json"))
+	if err != nil {
+		log.Warnf("failed to read bundle spec of %s: %v", containerID, err)
+		return nil
+	}
+	return spec.Process
+}
+
+// execOptions returns what crio-lxc-init applies before it execs the
+// process, nil if there is nothing: lxc attach doesn't set the socket
+// activation variables, rlimits, no_new_privs and additional groups. It
+// does apply the container's capabilities and apparmor profile, a process
+// that asks for others is rejected.
+func execOptions(process *specs.Process, container *specs.Process, listenFds int) (*initsync.ExecOptions, error) {
+	if process.Capabilities != nil && (container == nil || !reflect.DeepEqual(process.Capabilities, container.Capabilities)) {
+		return nil, fmt.Errorf("exec with capabilities other than the container's is not supported")
+	}
+	if process.ApparmorProfile != "" && (container == nil || process.ApparmorProfile != container.ApparmorProfile) {
+		return nil, fmt.Errorf("exec with an apparmor profile other than the container's is not supported")
+	}
+
+	opts := &initsync.ExecOptions{ListenFds: listenFds, NoNewPrivileges: process.NoNewPrivileges}
+	for _, rl := range process.Rlimits {
+		resource, ok := rlimitTypes[strings.TrimPrefix(strings.ToLower(rl.Type), "rlimit_")]
+		if !ok {
+			return nil, fmt.Errorf("unknown rlimit type '%s'", rl.Type)
+		}
+		if rl.Soft > rl.Hard {
+			return nil, fmt.Errorf("soft limit of %s is above its hard limit", rl.Type)
+		}
+		opts.Rlimits = append(opts.Rlimits, initsync.Rlimit{Resource: resource, Soft: rl.Soft, Hard: rl.Hard})
+	}
+	if len(process.User.AdditionalGids) > 0 {
+		opts.User = &initsync.User{
+			UID:            process.User.UID,
+			GID:            process.User.GID,
+			AdditionalGids: process.User.AdditionalGids,
+		}
+	}
+	if opts.ListenFds == 0 && !opts.NoNewPrivileges && len(opts.Rlimits) == 0 && opts.User == nil {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+// parseUser parses uid[:gid], the gid defaults to the uid.
+func parseUser(user string) (specs.User, error) {
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("invalid uid in --user '%s'", user)
+	}
+	gid := uid
+	if len(parts) == 2 {
+		gid, err = strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return specs.User{}, fmt.Errorf("invalid gid in --user '%s'", user)
+		}
+	}
+	return specs.User{UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+// setEnv replaces the variable in env, or appends it.
+func setEnv(env []string, envVar string) ([]string, error) {
+	i := strings.Index(envVar, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid environment variable '%s'", envVar)
+	}
+	prefix := envVar[:i+1]
+	for j := range env {
+		if strings.HasPrefix(env[j], prefix) {
+			env[j] = envVar
+			return env, nil
+		}
+	}
+	return append(env, envVar), nil
+}
+
+func doExec(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "exec", 1)
+	}
+
+	process, err := execProcess(ctx)
+	if err != nil {
+		return err
+	}
+	if len(process.Args) == 0 {
+		return fmt.Errorf("process has no args")
+	}
+	execID := ctx.String("exec-id")
+	if execID != "" && !validContainerID.MatchString(execID) {
+		return fmt.Errorf("invalid exec ID '%s'", execID)
+	}
+	affinity := &cpuAffinity{}
+	if ctx.IsSet("process") {
+		affinity, err = readExecCPUAffinity(ctx.String("process"))
+		if err != nil {
+			return err
+		}
+		if affinity == nil {
+			affinity = &cpuAffinity{}
+		}
+	}
+
+	preserveFds, err := preservedFds(ctx)
+	if err != nil {
+		return err
+	}
+	activationFds, err := listenFds()
+	if err != nil {
+		return err
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+	execOpts, err := execOptions(process, containerProcess(containerID), activationFds)
+	if err != nil {
+		return err
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if !running {
+		return errNotRunning(containerID, "cannot exec")
+	}
+
+	opts := lxc.DefaultAttachOptions
+	opts.Cwd = process.Cwd
+	opts.UID = int(process.User.UID)
+	opts.GID = int(process.User.GID)
+	opts.ClearEnv = true
+	opts.Env = process.Env
+	opts.StdinFd = os.Stdin.Fd()
+	opts.StdoutFd = os.Stdout.Fd()
+	opts.StderrFd = os.Stderr.Fd()
+
+	if process.Terminal {
+		if !ctx.IsSet("console-socket") {
+			return fmt.Errorf("--tty requires --console-socket")
+		}
+		master, slave, err := openPty()
+		if err != nil {
+			return errors.Wrap(err, "failed to allocate pty")
+		}
+		defer master.Close()
+		defer slave.Close()
+
+		if err := setConsoleSize(master, process.ConsoleSize); err != nil {
+			return errors.Wrap(err, "failed to set console size")
+		}
+		if err := sendConsole(ctx.String("console-socket"), master); err != nil {
+			return errors.Wrap(err, "failed to send console")
+		}
+		opts.StdinFd = slave.Fd()
+		opts.StdoutFd = slave.Fd()
+		opts.StderrFd = slave.Fd()
+	}
+
+	// The attached process inherits fds 3 .. 3+N-1 from this process.
+	if err := closeInheritedFds(preserveFds); err != nil {
+		return errors.Wrap(err, "failed to close inherited fds")
+	}
+	args := process.Args
+	if execOpts != nil {
+		data, err := json.Marshal(execOpts)
+		if err != nil {
+			return err
+		}
+		args = append([]string{initsync.InitPath, initsync.ExecArg, string(data)}, args...)
+		if execOpts.User != nil {
+			opts.UID = 0
+			opts.GID = 0
+		}
+	}
+
+	sp := startSpan("lxc attach")
+	var pid int
+	err = withInitialAffinity(affinity.Initial, func() error {
+		var err error
+		pid, err = c.RunCommandNoWait(args, opts)
+		return err
+	})
+	sp.End(err)
+	if err != nil {
+		return errors.Wrap(err, "failed to exec process")
+	}
+	if err := setFinalAffinity(pid, affinity.Final); err != nil {
+		// the process must not keep running on the wrong cpus
+		killAndWait(pid)
+		return errors.Wrap(err, "failed to set final cpu affinity")
+	}
+
+	if ctx.IsSet("pid-file") {
+		if err := writePidFile(ctx.String("pid-file"), pid); err != nil {
+			return errors.Wrap(err, "failed to write pid file")
+		}
+	}
+	if execID != "" {
+		if err := recordExec(containerID, execID, pid); err != nil {
+			return errors.Wrap(err, "failed to record exec session")
+		}
+	}
+	if ctx.Bool("detach") {
+		return nil
+	}
+	if execID != "" {
+		defer os.Remove(execPath(containerID, execID))
+	}
+
+	status, err := waitProcess(pid)
+	if err != nil {
+		return errors.Wrap(err, "failed to wait for process")
+	}
+	// probes rely on getting the exact status back
+	return errExitStatus(status)
+}
+
+// waitProcess waits for the child pid to exit.
+func waitProcess(pid int) (unix.WaitStatus, error) {
+	var status unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &status, 0, nil)
+		if err != unix.EINTR {
+			return status, err
+		}
+	}
+}
+
+// killAndWait kills the child pid and reaps it.
+func killAndWait(pid int) {
+	if err := unix.Kill(pid, unix.SIGKILL); err != nil {
+		log.Warnf("failed to kill pid %d: %v", pid, err)
+		return
+	}
+	if _, err := waitProcess(pid); err != nil {
+		log.Warnf("failed to wait for pid %d: %v", pid, err)
+	}
+}
+
+// execSession is what is recorded about an exec with --exec-id.
+type execSession struct {
+	Pid       int    `json:"pid"`
+	StartTime uint64 `json:"startTime"`
+}
+
+func execPath(containerID string, execID string) string {
+	return runtimePath(containerID, execsDirName, execID+".json")
+}
+
+func recordExec(containerID string, execID string, pid int) error {
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&execSession{Pid: pid, StartTime: startTime})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(execPath(containerID, execID)), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(execPath(containerID, execID), data, 0600)
+}
+
+// execPid returns the pid of a recorded exec session, 0 if it exited.
+func execPid(containerID string, execID string) (int, error) {
+	data, err := ioutil.ReadFile(execPath(containerID, execID))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	session := &execSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return 0, errors.Wrap(err, "invalid exec session")
+	}
+	if !pidAlive(session.Pid, session.StartTime) {
+		return 0, nil
+	}
+	return session.Pid, nil
+}
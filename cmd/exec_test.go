@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/crio-lxc/cmd/internal/initsync"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestExecOptions(t *testing.T) {
+	caps := &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN"}, Effective: []string{"CAP_CHOWN"}}
+	container := &specs.Process{Capabilities: caps, ApparmorProfile: "crio-default"}
+
+	tests := []struct {
+		name      string
+		process   specs.Process
+		container *specs.Process
+		listenFds int
+		want      *initsync.ExecOptions
+		wantErr   bool
+	}{
+		{"nothing to set", specs.Process{}, container, 0, nil, false},
+		{"container's capabilities", specs.Process{Capabilities: caps, ApparmorProfile: "crio-default"}, container, 0, nil, false},
+		{"other capabilities", specs.Process{Capabilities: &specs.LinuxCapabilities{Bounding: []string{"CAP_SYS_ADMIN"}}}, container, 0, nil, true},
+		{"capabilities without a bundle", specs.Process{Capabilities: caps}, nil, 0, nil, true},
+		{"other apparmor profile", specs.Process{ApparmorProfile: "unconfined"}, container, 0, nil, true},
+		{"listen fds", specs.Process{}, container, 2, &initsync.ExecOptions{ListenFds: 2}, false},
+		{"no new privileges", specs.Process{NoNewPrivileges: true}, container, 0, &initsync.ExecOptions{NoNewPrivileges: true}, false},
+		{
+			"rlimits",
+			specs.Process{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 4096}}},
+			container, 0,
+			&initsync.ExecOptions{Rlimits: []initsync.Rlimit{{Resource: 7, Soft: 1024, Hard: 4096}}},
+			false,
+		},
+		{"unknown rlimit", specs.Process{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_FOO"}}}, container, 0, nil, true},
+		{"soft above hard", specs.Process{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_NOFILE", Soft: 2, Hard: 1}}}, container, 0, nil, true},
+		{
+			"additional gids",
+			specs.Process{User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{10, 20}}},
+			container, 0,
+			&initsync.ExecOptions{User: &initsync.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{10, 20}}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := execOptions(&tt.process, tt.container, tt.listenFds)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: execOptions = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
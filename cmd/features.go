@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcFeature is a liblxc feature that older releases lack. Config keys
+// with one of its prefixes are only accepted when it is available, so that
+// users get a clear error instead of liblxc failing to parse the config.
+type lxcFeature struct {
+	name string
+	keys []string
+	// major.minor.micro is the first release with the feature. If
+	// extension is set, an older liblxc with the backported API
+	// extension qualifies too.
+	major, minor, micro int
+	extension           string
+}
+
+var lxcFeatures = []lxcFeature{
+	{name: "cgroup2", keys: []string{"lxc.cgroup2."}, major: 4, extension: "cgroup2"},
+	{name: "relative cgroups", keys: []string{"lxc.cgroup.relative"}, major: 3, minor: 1},
+	{name: "sharing namespaces", keys: []string{"lxc.namespace.share."}, major: 3, minor: 1},
+	{name: "session keyring control", keys: []string{"lxc.keyring."}, major: 4},
+	{name: "apparmor nesting", keys: []string{"lxc.apparmor.allow_nesting"}, major: 3},
+	{name: "seccomp notify", keys: []string{"lxc.seccomp.notify."}, major: 3, minor: 2, extension: "seccomp_notify"},
+	{name: "veth routes", keys: []string{"lxc.net.0.veth.ipv4.route", "lxc.net.0.veth.ipv6.route"}, major: 3, minor: 2, extension: "network_veth_routes"},
+}
+
+var (
+	lxcFeaturesOnce      sync.Once
+	lxcFeaturesAvailable map[string]bool
+)
+
+// detectLXCFeatures probes liblxc once per invocation.
+func detectLXCFeatures() map[string]bool {
+	lxcFeaturesOnce.Do(func() {
+		lxcFeaturesAvailable = map[string]bool{}
+		for _, f := range lxcFeatures {
+			available := lxc.VersionAtLeast(f.major, f.minor, f.micro)
+			if !available && f.extension != "" {
+				available = lxc.HasApiExtension(f.extension)
+			}
+			lxcFeaturesAvailable[f.name] = available
+		}
+	})
+	return lxcFeaturesAvailable
+}
+
+// lxcFeatureAvailable is for optional use of a feature.
+func lxcFeatureAvailable(name string) bool {
+	return detectLXCFeatures()[name]
+}
+
+func (f lxcFeature) requirement() string {
+	req := fmt.Sprintf("liblxc >= %d.%d.%d", f.major, f.minor, f.micro)
+	if f.extension != "" {
+		req += fmt.Sprintf(" (or the %s API extension)", f.extension)
+	}
+	return req
+}
+
+// requireConfigKey returns an error if key belongs to a feature the loaded
+// liblxc lacks.
+func requireConfigKey(key string) error {
+	for _, f := range lxcFeatures {
+		for _, prefix := range f.keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if !detectLXCFeatures()[f.name] {
+				return fmt.Errorf("%s requires %s for %s, found liblxc %s", key, f.requirement(), f.name, lxc.Version())
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func checkLXCFeatures() (string, error) {
+	available := detectLXCFeatures()
+	var missing []string
+	for _, f := range lxcFeatures {
+		if !available[f.name] {
+			missing = append(missing, fmt.Sprintf("%s (%s)", f.name, f.requirement()))
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unavailable: %s", strings.Join(missing, ", "))
+	}
+	return "all available", nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// nvidiaHook is liblxc's mount hook, which uses nvidia-container-cli to
+// bind the device nodes and driver libraries into the rootfs.
+var nvidiaHook = "/usr/share/lxc/hooks/nvidia"
+
+// nvidiaProcGPUs has a dir with the driver's information per GPU.
+var nvidiaProcGPUs = "/proc/driver/nvidia/gpus"
+
+// nvidiaControlDevices are needed in addition to the /dev/nvidiaN nodes.
+var nvidiaControlDevices = []string{
+	"/dev/nvidiactl",
+	"/dev/nvidia-uvm",
+	"/dev/nvidia-uvm-tools",
+	"/dev/nvidia-modeset",
+}
+
+// isNvidiaHook matches the prestart hook that nvidia-container-runtime
+// injects into the spec.
+func isNvidiaHook(hook specs.Hook) bool {
+	base := filepath.Base(hook.Path)
+	return base == "nvidia-container-runtime-hook" || base == "nvidia-container-toolkit"
+}
+
+func specEnv(spec *specs.Spec, key string) (string, bool) {
+	for _, env := range spec.Process.Env {
+		if strings.HasPrefix(env, key+"=") {
+			return env[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// requestedGPUs returns the NVIDIA_VISIBLE_DEVICES value for the container,
+// "" if it doesn't use GPUs. GPUs are requested with the gpus annotation or
+// by the nvidia prestart hook together with NVIDIA_VISIBLE_DEVICES.
+func requestedGPUs(spec *specs.Spec) string {
+	if gpus, ok := spec.Annotations[ANNOTATION_GPUS]; ok {
+		if gpus == "none" {
+			return ""
+		}
+		return gpus
+	}
+
+	if spec.Hooks == nil {
+		return ""
+	}
+	for _, hook := range spec.Hooks.Prestart {
+		if !isNvidiaHook(hook) {
+			continue
+		}
+		gpus, ok := specEnv(spec, "NVIDIA_VISIBLE_DEVICES")
+		if !ok || gpus == "void" || gpus == "none" {
+			return ""
+		}
+		return gpus
+	}
+	return ""
+}
+
+// nvidiaMinor returns the device minor, N of /dev/nvidiaN, of the GPU with
+// the given UUID, from the driver's information about each GPU.
+func nvidiaMinor(uuid string) (string, error) {
+	infos, err := filepath.Glob(filepath.Join(nvidiaProcGPUs, "*", "information"))
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(info)
+		if err != nil {
+			return "", err
+		}
+		var gpuUUID, minor string
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch strings.TrimSpace(parts[0]) {
+			case "GPU UUID":
+				gpuUUID = strings.TrimSpace(parts[1])
+			case "Device Minor":
+				minor = strings.TrimSpace(parts[1])
+			}
+		}
+		if gpuUUID == uuid && minor != "" {
+			return minor, nil
+		}
+	}
+	return "", fmt.Errorf("no GPU with UUID %s in %s", uuid, nvidiaProcGPUs)
+}
+
+// nvidiaDevices returns the host device nodes for the visible GPUs.
+func nvidiaDevices(gpus string) ([]string, error) {
+	devices := []string{}
+	if gpus == "all" {
+		nodes, err := filepath.Glob("/dev/nvidia[0-9]*")
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, nodes...)
+	} else {
+		for _, gpu := range strings.Split(gpus, ",") {
+			gpu = strings.TrimSpace(gpu)
+			if strings.HasPrefix(gpu, "GPU-") {
+				minor, err := nvidiaMinor(gpu)
+				if err != nil {
+					return nil, err
+				}
+				gpu = minor
+			} else if _, err := strconv.ParseUint(gpu, 10, 32); err != nil {
+				// e.g. MIG devices, whose nodes are not /dev/nvidiaN
+				return nil, fmt.Errorf("unsupported GPU '%s', only indexes and GPU UUIDs are", gpu)
+			}
+			devices = append(devices, "/dev/nvidia"+gpu)
+		}
+	}
+	for _, dev := range nvidiaControlDevices {
+		if exists, _ := pathExists(dev); exists {
+			devices = append(devices, dev)
+		}
+	}
+	return devices, nil
+}
+
+// configureGPUs sets up the nvidia hook and allows access to the GPU device
+// nodes in the devices cgroup.
+func configureGPUs(cfg *lxcConfig, spec *specs.Spec) error {
+	gpus := requestedGPUs(spec)
+	if gpus == "" {
+		return nil
+	}
+	if exists, _ := pathExists(nvidiaHook); !exists {
+		return fmt.Errorf("GPUs requested but lxc's nvidia hook %s is not installed", nvidiaHook)
+	}
+
+	if err := cfg.Set("lxc.hook.mount", nvidiaHook); err != nil {
+		return errors.Wrap(err, "failed to set nvidia hook")
+	}
+	if err := cfg.Set("lxc.environment", "NVIDIA_VISIBLE_DEVICES="+gpus); err != nil {
+		return errors.Wrap(err, "failed to set visible GPUs")
+	}
+	if _, ok := specEnv(spec, "NVIDIA_DRIVER_CAPABILITIES"); !ok {
+		if err := cfg.Set("lxc.environment", "NVIDIA_DRIVER_CAPABILITIES=compute,utility"); err != nil {
+			return errors.Wrap(err, "failed to set driver capabilities")
+		}
+	}
+
+	devices, err := nvidiaDevices(gpus)
+	if err != nil {
+		return errors.Wrap(err, "failed to list GPU devices")
+	}
+
+	for _, dev := range devices {
+		var st unix.Stat_t
+		if err := unix.Stat(dev, &st); err != nil {
+			return errors.Wrapf(err, "failed to stat GPU device %s", dev)
+		}
+		rdev := uint64(st.Rdev)
+		rule := fmt.Sprintf("c %d:%d rwm", unix.Major(rdev), unix.Minor(rdev))
+		if err := allowDevice(cfg, rule); err != nil {
+			return errors.Wrapf(err, "failed to allow GPU device %s", dev)
+		}
+	}
+	log.Debugf("passing GPUs %s (%d device nodes) through to the container", gpus, len(devices))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNvidiaDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := nvidiaProcGPUs
+	nvidiaProcGPUs = dir
+	defer func() { nvidiaProcGPUs = saved }()
+
+	for busID, info := range map[string]string{
+		"0000:3b:00.0": "Model: \t\t Tesla V100\nGPU UUID: \t GPU-aaaa\nDevice Minor: \t 2\n",
+		"0000:86:00.0": "Model: \t\t Tesla V100\nGPU UUID: \t GPU-bbbb\nDevice Minor: \t 0\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, busID), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, busID, "information"), []byte(info), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		gpus    string
+		want    []string
+		wantErr bool
+	}{
+		{"0,1", []string{"/dev/nvidia0", "/dev/nvidia1"}, false},
+		{"GPU-aaaa", []string{"/dev/nvidia2"}, false},
+		{"1, GPU-bbbb", []string{"/dev/nvidia1", "/dev/nvidia0"}, false},
+		{"GPU-cccc", nil, true},
+		{"MIG-GPU-aaaa/1/0", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := nvidiaDevices(tt.gpus)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("nvidiaDevices(%q) error = %v, want error %v", tt.gpus, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// the control devices depend on the host
+		if len(got) < len(tt.want) || !reflect.DeepEqual(got[:len(tt.want)], tt.want) {
+			t.Errorf("nvidiaDevices(%q) = %v, want %v first", tt.gpus, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// The container init must get nothing of the runtime's but what the spec
+// asks for: the monitor makes all fds but stdio and the preserved ones
+// close-on-exec, see close_inherited in internal.go, rlimits are set from the spec, and
+// the runtime's own environment variables are removed from the
+// monitor's, which liblxc runs hooks with.
+
+const (
+	// inheritFdsEnv is the number of fds after stdio the monitor passes
+	// on to the container.
+	inheritFdsEnv = "CRIO_LXC_INHERIT_FDS"
+
+	// the kernel's RLIMIT_NOFILE defaults, INR_OPEN_CUR and
+	// INR_OPEN_MAX
+	defaultNofileSoft = 1024
+	defaultNofileHard = 4096
+)
+
+// runtimeEnvPrefixes are the environment variables of the runtime itself.
+var runtimeEnvPrefixes = []string{
+	"CRIO_LXC_",
+	"LISTEN_",
+	"NOTIFY_SOCKET=",
+	"OTEL_",
+	"TRACEPARENT=",
+}
+
+// monitorEnv is the environment of a container's monitor process.
+func monitorEnv(ctx *cli.Context, inheritFds int) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		runtimeVar := false
+		for _, prefix := range runtimeEnvPrefixes {
+			if strings.HasPrefix(kv, prefix) {
+				runtimeVar = true
+				break
+			}
+		}
+		if !runtimeVar {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, inheritFdsEnv+"="+strconv.Itoa(inheritFds))
+	if socket := ctx.GlobalString("state-socket"); socket != "" {
+		env = append(env, stateSocketEnv+"="+socket)
+	}
+	return env
+}
+
+// closeInheritedFds marks all fds but stdio and the keep ones after it
+// close-on-exec, as close_inherited does for the monitor. exec runs its
+// process from this process, which has no monitor in between.
+func closeInheritedFds(keep int) error {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		if fd >= 3+keep {
+			unix.CloseOnExec(fd)
+		}
+	}
+	return nil
+}
+
+// setMonitorEnv sets the environment of a container's monitor, which gets
+// the cmd's ExtraFiles.
+func setMonitorEnv(ctx *cli.Context, cmd *exec.Cmd) {
+	cmd.Env = monitorEnv(ctx, len(cmd.ExtraFiles))
+}
+
+func rlimitValue(v uint64) string {
+	if v == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// configureRlimits sets the spec's rlimits. RLIMIT_NOFILE is reset to the
+// kernel's default when the spec has none, the runtime's is often raised
+// far above it.
+func configureRlimits(cfg *lxcConfig, spec *specs.Spec) error {
+	nofile := false
+	for _, rl := range spec.Process.Rlimits {
+		name := strings.TrimPrefix(strings.ToLower(rl.Type), "rlimit_")
+		if _, ok := rlimitTypes[name]; !ok {
+			return fmt.Errorf("unknown rlimit type '%s'", rl.Type)
+		}
+		if rl.Soft > rl.Hard {
+			return fmt.Errorf("soft limit of %s is above its hard limit", rl.Type)
+		}
+		if name == "nofile" {
+			nofile = true
+		}
+		if err := cfg.Set("lxc.prlimit."+name, rlimitValue(rl.Soft)+":"+rlimitValue(rl.Hard)); err != nil {
+			return err
+		}
+	}
+	if !nofile {
+		return cfg.Set("lxc.prlimit.nofile", fmt.Sprintf("%d:%d", defaultNofileSoft, defaultNofileHard))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxContainerIDLength keeps the paths derived from the ID, like the sync
+// socket's, within the limits of unix socket addresses.
+const maxContainerIDLength = 64
+
+// validContainerID is the charset of IDs. They are used as lxc names and
+// file names, so path separators and leading dots are not allowed.
+var validContainerID = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateContainerID checks an ID before it is used in a path.
+func validateContainerID(containerID string) error {
+	if len(containerID) > maxContainerIDLength {
+		return errInvalidID(containerID, fmt.Sprintf("longer than %d characters", maxContainerIDLength))
+	}
+	if !validContainerID.MatchString(containerID) {
+		return errInvalidID(containerID, "only letters, digits, '_', '.' and '-' are allowed, starting with a letter or digit")
+	}
+	return nil
+}
+
+// checkIDCollision rejects an ID that only differs in case from an
+// existing container's, since the state directories would collide on
+// case insensitive filesystems.
+func checkIDCollision(containerID string) error {
+	ids, err := listContainerIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id != containerID && strings.EqualFold(id, containerID) {
+			return errInvalidID(containerID, fmt.Sprintf("collides with container '%s'", id))
+		}
+	}
+	return nil
+}
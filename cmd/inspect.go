@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var inspectCmd = cli.Command{
+	Name:   "inspect",
+	Usage:  "prints the spec, lxc config, state and cgroups of a container",
+	Action: doInspect,
+	ArgsUsage: `[containerID]
+
+<containerID> is the ID of the container to inspect
+`,
+}
+
+type inspectInfo struct {
+	Spec      *specs.Spec       `json:"spec,omitempty"`
+	LXCConfig []lxcConfigItem   `json:"lxcConfig"`
+	State     *specs.State      `json:"state"`
+	Cgroups   map[string]string `json:"cgroups,omitempty"`
+	Timings   containerTimings  `json:"timings,omitempty"`
+}
+
+func doInspect(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "inspect", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	info := inspectInfo{}
+
+	info.State, err = containerState(c, containerID)
+	if err != nil {
+		return err
+	}
+
+	// The bundle may have been removed since the container was created.
+	if spec, err := readBundleSpec(filepath.Join(info.State.Bundle, "config.json")); err == nil {
+		info.Spec = spec
+	}
+
+	info.LXCConfig, err = readLXCConfig(lxcPath(containerID, configFileName))
+	if err != nil {
+		return errors.Wrap(err, "failed to read lxc config")
+	}
+
+	if info.State.Pid != 0 {
+		info.Cgroups, err = procCgroups(info.State.Pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to read cgroups")
+		}
+	}
+
+	info.Timings, err = readTimings(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to read timings")
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal json")
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	return nil
+}
+
+// procCgroups returns the cgroup path of pid for each hierarchy, keyed by
+// controller list ("" for the unified hierarchy).
+func procCgroups(pid int) (map[string]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cgroups := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		cgroups[parts[1]] = parts[2]
+	}
+	return cgroups, scanner.Err()
+}
@@ -12,14 +12,82 @@ package main
 /*
 #define _GNU_SOURCE
 #include <stdio.h>
+#include <stdlib.h>
 #include <unistd.h>
 #include <fcntl.h>
 #include <string.h>
 #include <signal.h>
+#include <dirent.h>
+#include <errno.h>
+#include <poll.h>
+#include <time.h>
+#include <sys/socket.h>
+#include <sys/un.h>
+#include <sys/wait.h>
 
 #include <lxc/lxccontainer.h>
 
-static int spawn_container(char *name, char *lxcpath, char *config)
+// The container environment is passed in a NUL separated file rather than
+// the saved config, since the config file format can't represent values
+// containing newlines or leading/trailing whitespace.
+static int load_environment(struct lxc_container *c, char *env_path)
+{
+	FILE *f;
+	char *line = NULL;
+	size_t len = 0;
+	ssize_t nread;
+	int ret = 0;
+
+	f = fopen(env_path, "r");
+	if (!f) {
+		perror("error: fopen env file");
+		return -1;
+	}
+
+	// getdelim always NUL terminates, so each entry is a C string.
+	while ((nread = getdelim(&line, &len, '\0', f)) > 0) {
+		if (!c->set_config_item(c, "lxc.environment", line)) {
+			fprintf(stderr, "failed to set environment variable %s\n", line);
+			ret = -1;
+			break;
+		}
+	}
+
+	free(line);
+	fclose(f);
+	return ret;
+}
+
+// close_inherited marks all fds but stdio and the CRIO_LXC_INHERIT_FDS
+// ones after it close-on-exec, so that the container init gets nothing
+// else the runtime may have leaked, see hygiene.go.
+static void close_inherited(void)
+{
+	char *inherit;
+	struct dirent *ent;
+	DIR *dir;
+	int fd, keep = 3;
+
+	inherit = getenv("CRIO_LXC_INHERIT_FDS");
+	if (inherit)
+		keep += atoi(inherit);
+
+	dir = opendir("/proc/self/fd");
+	if (!dir) {
+		perror("error: opendir /proc/self/fd");
+		return;
+	}
+	while ((ent = readdir(dir))) {
+		if (ent->d_name[0] == '.')
+			continue;
+		fd = atoi(ent->d_name);
+		if (fd >= keep && fd != dirfd(dir))
+			fcntl(fd, F_SETFD, FD_CLOEXEC);
+	}
+	closedir(dir);
+}
+
+static int spawn_container(char *name, char *lxcpath, char *config, char *env_path)
 {
 	struct lxc_container *c;
 
@@ -35,6 +103,9 @@ static int spawn_container(char *name, char *lxcpath, char *config)
 		return -1;
 	}
 
+	if (load_environment(c, env_path) < 0)
+		return -1;
+
 	c->daemonize = false;
 	if (!c->start(c, 1, NULL)) {
 		fprintf(stderr, "failed to start container %s\n", name);
@@ -44,14 +115,221 @@ static int spawn_container(char *name, char *lxcpath, char *config)
 	return c->error_num;
 }
 
-// main function for the "internal" command. Right now, arguments look like:
-// argv[0] internal <container_name> <lxcpath> <config_path>
+// wait_children reaps the children of this process until there are none
+// left and returns the status of the last one.
+static int wait_children(void)
+{
+	int status = -1, wstatus;
+	pid_t pid;
+
+	for (;;) {
+		pid = waitpid(-1, &wstatus, 0);
+		if (pid > 0) {
+			status = wstatus;
+			continue;
+		}
+		if (errno == EINTR)
+			continue;
+		if (errno != ECHILD)
+			perror("error: waitpid");
+		return status;
+	}
+}
+
+// restore_container restores a checkpoint made by the checkpoint command
+// and waits for the restored container to exit, as spawn_container does.
+// liblxc's restore returns once the container runs, the container is
+// then monitored by a child liblxc forked, which exits after the init.
+// The init itself is that child's child, so its exit status only reaches
+// us as far as the monitor passes it on.
+static int restore_container(char *name, char *lxcpath, char *config, char *image_dir)
+{
+	struct lxc_container *c;
+
+	c = lxc_container_new(name, lxcpath);
+	if (!c) {
+		fprintf(stderr, "failed to create container %s\n", name);
+		return -1;
+	}
+
+	c->clear_config(c);
+	if (!c->load_config(c, config)) {
+		fprintf(stderr, "failed to load container config at %s\n", config);
+		return -1;
+	}
+
+	c->daemonize = false;
+	if (!c->restore(c, image_dir, false)) {
+		fprintf(stderr, "failed to restore container %s from %s\n", name, image_dir);
+		return -1;
+	}
+	if (c->init_pid(c) <= 0) {
+		fprintf(stderr, "restored container %s has no init\n", name);
+		return -1;
+	}
+
+	return wait_children();
+}
+
+// STATE_NOTIFY_TIMEOUT_MS bounds how long the monitor waits for the state
+// socket, as stateNotifyTimeout does in statenotify.go.
+#define STATE_NOTIFY_TIMEOUT_MS 1000
+
+// connect_timeout connects the nonblocking socket fd to addr, giving up
+// after timeout_ms, so that a state socket nobody accepts on can't keep
+// the monitor from exiting. Unix sockets fail with EAGAIN while the
+// listener's backlog is full, the connect is retried then.
+static int connect_timeout(int fd, struct sockaddr_un *addr, int timeout_ms)
+{
+	struct pollfd pfd = { .fd = fd, .events = POLLOUT };
+	socklen_t len = sizeof(int);
+	int err, waited = 0;
+
+	for (;;) {
+		if (connect(fd, (struct sockaddr *)addr, sizeof(*addr)) == 0)
+			return 0;
+		if (errno == EINTR)
+			continue;
+		if (errno == EAGAIN && waited < timeout_ms) {
+			usleep(10 * 1000);
+			waited += 10;
+			continue;
+		}
+		if (errno != EINPROGRESS)
+			return -1;
+		break;
+	}
+
+	err = poll(&pfd, 1, timeout_ms - waited);
+	if (err <= 0) {
+		if (err == 0)
+			errno = ETIMEDOUT;
+		return -1;
+	}
+	if (getsockopt(fd, SOL_SOCKET, SO_ERROR, &err, &len) < 0)
+		return -1;
+	if (err) {
+		errno = err;
+		return -1;
+	}
+	return 0;
+}
+
+// notify_stopped pushes the stopped state to the state socket in
+// CRIO_LXC_STATE_SOCKET, if any, in the format of stateNotification in
+// statenotify.go. Container IDs need no json escaping, see ids.go.
+static void notify_stopped(char *name, int code, struct timespec *now)
+{
+	struct sockaddr_un addr = { .sun_family = AF_UNIX };
+	char msg[1024], ts[64];
+	struct tm tm;
+	char *socket_path;
+	int fd, len;
+
+	socket_path = getenv("CRIO_LXC_STATE_SOCKET");
+	if (!socket_path || !*socket_path)
+		return;
+	if (strlen(socket_path) >= sizeof(addr.sun_path)) {
+		fprintf(stderr, "state socket path %s too long\n", socket_path);
+		return;
+	}
+	strcpy(addr.sun_path, socket_path);
+
+	if (!gmtime_r(&now->tv_sec, &tm) || !strftime(ts, sizeof(ts), "%Y-%m-%dT%H:%M:%S", &tm))
+		return;
+	len = snprintf(msg, sizeof(msg),
+		"{\"id\":\"%s\",\"status\":\"stopped\",\"exitCode\":%d,\"time\":\"%s.%09ldZ\"}\n",
+		name, code, ts, now->tv_nsec);
+	if (len < 0 || len >= sizeof(msg))
+		return;
+
+	fd = socket(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC | SOCK_NONBLOCK, 0);
+	if (fd < 0) {
+		perror("error: state socket");
+		return;
+	}
+	if (connect_timeout(fd, &addr, STATE_NOTIFY_TIMEOUT_MS) < 0 ||
+	    write(fd, msg, len) != len)
+		perror("error: state notification");
+	close(fd);
+}
+
+// sync_parent_dir makes a rename into the directory of path durable.
+static void sync_parent_dir(const char *path)
+{
+	char dir[4096];
+	char *slash;
+	int fd;
+
+	if (snprintf(dir, sizeof(dir), "%s", path) >= sizeof(dir))
+		return;
+	slash = strrchr(dir, '/');
+	if (!slash)
+		return;
+	*slash = '\0';
+	fd = open(dir[0] ? dir : "/", O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+	if (fd < 0)
+		return;
+	if (fsync(fd) < 0)
+		perror("error: fsync exit status dir");
+	close(fd);
+}
+
+// record_exit writes the exit code of the container init and the time it
+// exited to exit_path, for state to report once the container stopped,
+// and notifies the state socket. The file is synced and renamed into
+// place, so readers never see a partial write, not even after a crash.
+static void record_exit(char *name, char *exit_path, int status)
+{
+	char tmp[4096];
+	struct timespec now;
+	FILE *f;
+	int code;
+
+	if (status < 0)
+		return;
+
+	if (WIFEXITED(status))
+		code = WEXITSTATUS(status);
+	else
+		code = 128 + WTERMSIG(status);
+
+	if (clock_gettime(CLOCK_REALTIME, &now) < 0) {
+		perror("error: clock_gettime");
+		return;
+	}
+
+	if (snprintf(tmp, sizeof(tmp), "%s.tmp", exit_path) >= sizeof(tmp)) {
+		fprintf(stderr, "exit status path %s too long\n", exit_path);
+		return;
+	}
+
+	f = fopen(tmp, "w");
+	if (!f) {
+		perror("error: fopen exit status");
+		return;
+	}
+	fprintf(f, "{\"exitCode\": %d, \"finishedAt\": %lld}\n", code,
+		(long long)now.tv_sec * 1000000000LL + now.tv_nsec);
+	if (fflush(f) != 0 || fsync(fileno(f)) < 0 || fclose(f) != 0 || rename(tmp, exit_path) < 0) {
+		perror("error: write exit status");
+	} else {
+		sync_parent_dir(exit_path);
+	}
+
+	notify_stopped(name, code, &now);
+}
+
+// main function for the "internal" and "internal-restore" commands. Right
+// now, arguments look like:
+// argv[0] internal <container_name> <lxcpath> <config_path> <env_path> <exit_path>
+// argv[0] internal-restore <container_name> <lxcpath> <config_path> <image_dir> <exit_path>
 __attribute__((constructor)) void internal(void)
 {
-	int ret, status;
+	int ret, status, restore;
 	char buf[4096];
 	ssize_t size;
-	char *cur, *name, *lxcpath, *config_path;
+	char *cur, *name, *lxcpath, *config_path, *env_path, *exit_path;
 
 	ret = open("/proc/self/cmdline", O_RDONLY);
 	if (ret < 0) {
@@ -82,7 +360,11 @@ __attribute__((constructor)) void internal(void)
 	ADVANCE_ARG;
 
 	// is this really the internal command, if not, continue normal execution
-	if (strcmp(cur, "internal"))
+	if (!strcmp(cur, "internal"))
+		restore = 0;
+	else if (!strcmp(cur, "internal-restore"))
+		restore = 1;
+	else
 		return;
 
 	ADVANCE_ARG;
@@ -91,6 +373,11 @@ __attribute__((constructor)) void internal(void)
 	lxcpath = cur;
 	ADVANCE_ARG;
 	config_path = cur;
+	ADVANCE_ARG;
+	// the image dir for internal-restore
+	env_path = cur;
+	ADVANCE_ARG;
+	exit_path = cur;
 
 	ret = isatty(STDIN_FILENO);
 	if (ret < 0) {
@@ -103,7 +390,14 @@ __attribute__((constructor)) void internal(void)
 	if (!ret)
 		setsid();
 
-	status = spawn_container(name, lxcpath, config_path);
+	close_inherited();
+
+	if (restore)
+		status = restore_container(name, lxcpath, config_path, env_path);
+	else
+		status = spawn_container(name, lxcpath, config_path, env_path);
+
+	record_exit(name, exit_path, status);
 
 	// Try and propagate the container's exit code.
 	if (WIFEXITED(status)) {
@@ -115,3 +409,33 @@ __attribute__((constructor)) void internal(void)
 }
 */
 import "C"
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+// internalCmd is only registered so that "internal" shows up as a known
+// command; the C constructor above execs the container and exits before
+// the cli is ever run.
+var internalCmd = cli.Command{
+	Name:      "internal",
+	Usage:     "internal: run a container's init (used by create)",
+	ArgsUsage: "<containerID> <lxcpath> <config> <env> <exit-status>",
+	Hidden:    true,
+	Action: func(ctx *cli.Context) error {
+		return fmt.Errorf("internal must be handled before the runtime starts")
+	},
+}
+
+// internalRestoreCmd is the counterpart of internalCmd for restore.
+var internalRestoreCmd = cli.Command{
+	Name:      "internal-restore",
+	Usage:     "internal: restore a container's checkpoint (used by restore)",
+	ArgsUsage: "<containerID> <lxcpath> <config> <image-dir> <exit-status>",
+	Hidden:    true,
+	Action: func(ctx *cli.Context) error {
+		return fmt.Errorf("internal-restore must be handled before the runtime starts")
+	},
+}
@@ -0,0 +1,81 @@
+// Package initsync is the protocol between crio-lxc and crio-lxc-init, the
+// container init that waits for start before it execs the container
+// process.
+//
+// create binds the sync socket in the container's runtime dir and passes
+// the listening socket to the init as an inherited fd, so that nothing of
+// the sync dir has to be writable in the container. It is mounted read-only
+// at DirInContainer, under /dev since that is a tmpfs and the rootfs may
+// be read-only. Each connection to the socket sends one message line: Ping
+// is answered with Ping, once the init is up. Start makes the init exec
+// the container process, the connection is closed by the exec; if the exec
+// fails the init sends an InitError instead.
+package initsync
+
+import "fmt"
+
+const (
+	// DirInContainer is where the sync dir is mounted in the container.
+	DirInContainer = "/dev/.crio-lxc"
+	// InitPath is where the init binary is mounted in the container.
+	InitPath = DirInContainer + "/init"
+	// InitName is the name of the init binary and of its mount point in
+	// the sync dir.
+	InitName = "init"
+	// ArgsName is the name of the Args file in the sync dir.
+	ArgsName = "args.json"
+	// SocketName is the name of the sync socket in the sync dir.
+	SocketName = "sync.sock"
+	// ExecArg makes the init apply ExecOptions and exec the command in
+	// its further args, "init exec <options> command...". exec runs the
+	// process with it for what lxc attach can't set up.
+	ExecArg = "exec"
+
+	Ping  = "ping\n"
+	Start = "start\n"
+)
+
+// Args is what the init reads from ArgsName.
+type Args struct {
+	// Args are the args of the container process.
+	Args []string `json:"args"`
+	// SocketFd is the fd of the listening sync socket.
+	SocketFd int `json:"socketFd"`
+}
+
+// InitError is what the init reports when it can't run the container
+// process.
+type InitError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("container init failed (%s): %s", e.Kind, e.Message)
+}
+
+// ExecOptions are what the init applies in exec mode, as JSON.
+type ExecOptions struct {
+	// ListenFds sets LISTEN_FDS, and LISTEN_PID to the pid of the
+	// process, which is only known in the container.
+	ListenFds       int      `json:"listenFds,omitempty"`
+	NoNewPrivileges bool     `json:"noNewPrivileges,omitempty"`
+	Rlimits         []Rlimit `json:"rlimits,omitempty"`
+	// User is switched to by the init, which is attached as root then,
+	// for the additional groups.
+	User *User `json:"user,omitempty"`
+}
+
+// Rlimit is a resource limit by the kernel's resource number.
+type Rlimit struct {
+	Resource int    `json:"resource"`
+	Soft     uint64 `json:"soft"`
+	Hard     uint64 `json:"hard"`
+}
+
+// User is the user the process runs as.
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// linuxIOPriority is the OCI 1.1 process.ioPriority.
+type linuxIOPriority struct {
+	Class    string `json:"class"`
+	Priority int    `json:"priority"`
+}
+
+const (
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+var specIOPrioClasses = map[string]int{
+	"IOPRIO_CLASS_RT":   1,
+	"IOPRIO_CLASS_BE":   2,
+	"IOPRIO_CLASS_IDLE": 3,
+}
+
+// ioprioOf validates the spec's IO priority and encodes it for
+// ioprio_set.
+func ioprioOf(p *linuxIOPriority) (int, error) {
+	class, ok := specIOPrioClasses[p.Class]
+	if !ok {
+		return 0, fmt.Errorf("unknown IO priority class '%s'", p.Class)
+	}
+	if p.Priority < 0 || p.Priority > 7 {
+		return 0, fmt.Errorf("IO priority %d out of range 0..7", p.Priority)
+	}
+	return class<<ioprioClassShift | p.Priority, nil
+}
+
+// setIOPrio is ioprio_set(2) for a process, which x/sys/unix doesn't wrap.
+func setIOPrio(pid int, ioprio int) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(ioprio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
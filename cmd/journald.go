@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+var journaldPriorities = map[log.Level]int{
+	log.DebugLevel: 7,
+	log.InfoLevel:  6,
+	log.WarnLevel:  4,
+	log.ErrorLevel: 3,
+	log.FatalLevel: 2,
+}
+
+// journaldHandler sends entries to journald using its native protocol:
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL
+type journaldHandler struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournaldHandler() (*journaldHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to journald")
+	}
+	return &journaldHandler{conn: conn}, nil
+}
+
+func (h *journaldHandler) HandleLog(e *log.Entry) error {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", e.Message)
+	writeJournalField(&buf, "PRIORITY", fmt.Sprintf("%d", journaldPriorities[e.Level]))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", "crio-lxc")
+	for name, value := range mergeLogFields(e.Fields) {
+		switch name {
+		case "id":
+			name = "CONTAINER_ID"
+		case "command":
+			name = "COMMAND"
+		}
+		writeJournalField(&buf, journalFieldName(name), fmt.Sprintf("%v", value))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+// journalFieldName converts a log field name to a valid journal field
+// name: upper case letters, digits and underscores, not starting with an
+// underscore (those are reserved for trusted fields).
+func journalFieldName(name string) string {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	return strings.TrimLeft(mapped, "_")
+}
+
+// writeJournalField uses the binary-safe encoding for values containing
+// newlines.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// delete --keep moves the lxc dir, with the config and logs, and the
+// runtime dir of a container below keptDir instead of removing them, so
+// that failed containers can be looked into after they were deleted. The
+// dot keeps it apart from container dirs, and it has no config, so lxc
+// doesn't take it for a container either.
+
+func keptDir() string {
+	return filepath.Join(LXC_PATH, ".kept")
+}
+
+// keepArtifacts moves the container's dirs to
+// keptDir/<id>-<timestamp>/{lxc,state} and returns that dir.
+func keepArtifacts(containerID string) (string, error) {
+	dir := filepath.Join(keptDir(), containerID+"-"+time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := moveDir(lxcDir(containerID), filepath.Join(dir, "lxc")); err != nil {
+		return "", errors.Wrap(err, "failed to keep lxc dir")
+	}
+	if err := moveDir(runtimeDir(containerID), filepath.Join(dir, "state")); err != nil {
+		return "", errors.Wrap(err, "failed to keep runtime dir")
+	}
+	return dir, nil
+}
+
+// moveDir renames src to dst, copying it when they are on different
+// filesystems, as the runtime root on tmpfs and the lxc path usually are.
+// Only regular files and dirs are copied, sockets and fifos are of no use
+// once the container is gone.
+func moveDir(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != unix.EXDEV {
+		return err
+	}
+	if err := copyDir(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyDir copies the regular files and dirs below src to dst.
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode().IsRegular():
+			return copyFile(path, target, fi.Mode().Perm())
+		}
+		log.Debugf("not copying %s", path)
+		return nil
+	})
+}
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
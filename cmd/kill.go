@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 
@@ -18,17 +19,26 @@ var killCmd = cli.Command{
 	Name:   "kill",
 	Usage:  "sends a signal to a container",
 	Action: doKill,
-	ArgsUsage: `[containerID]
+	ArgsUsage: `[containerID...]
 
-<containerID> is the ID of the container to send a signal to
+<containerID> is the ID of the container to send a signal to, several
+are signalled concurrently
 `,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "signal",
 			Usage: "the signal to send, as a string",
 			Value: "TERM",
 		},
-	},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "send the signal to all processes of the container (see --all-containers for all containers)",
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "wait this many seconds for the container to exit, then send SIGKILL",
+		},
+	}, batchFlags...),
 }
 var signalMap = map[string]syscall.Signal{
 	"ABRT":   unix.SIGABRT,
@@ -68,18 +78,24 @@ var signalMap = map[string]syscall.Signal{
 }
 
 func doKill(ctx *cli.Context) error {
+	if isBatch(ctx) {
+		return runBatch(ctx, killContainer)
+	}
 	containerID := ctx.Args().Get(0)
 	if len(containerID) == 0 {
 		fmt.Fprintf(os.Stderr, "missing container ID\n")
 		cli.ShowCommandHelpAndExit(ctx, "state", 1)
 	}
+	return killContainer(ctx, containerID)
+}
 
+func killContainer(ctx *cli.Context, containerID string) error {
 	exists, err := containerExists(containerID)
 	if err != nil {
 		return errors.Wrap(err, "failed to check if container exists")
 	}
 	if !exists {
-		return fmt.Errorf("container '%s' not found", containerID)
+		return errNotFound(containerID)
 	}
 
 	c, err := lxc.NewContainer(containerID, LXC_PATH)
@@ -93,14 +109,28 @@ func doKill(ctx *cli.Context) error {
 
 	}
 
-	if !c.Running() {
-		return fmt.Errorf("container '%s' is not running", containerID)
+	pid, err := containerInitPid(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get container init pid")
+	}
+	if pid == 0 {
+		return errNotRunning(containerID, "")
 	}
 
-	pid := c.InitPid()
-
-	if err := unix.Kill(pid, signalMap[ctx.String("signal")]); err != nil {
+	sp := startSpan("signal")
+	sp.attrs["signal"] = ctx.String("signal")
+	if ctx.Bool("all") {
+		err = killAll(c, pid, signalMap[ctx.String("signal")])
+	} else {
+		err = unix.Kill(pid, signalMap[ctx.String("signal")])
+	}
+	sp.End(err)
+	if err != nil {
 		return errors.Wrap(err, "failed to send signal")
 	}
+
+	if ctx.Int("timeout") > 0 {
+		return escalateKill(c, containerID, pid, time.Duration(ctx.Int("timeout"))*time.Second)
+	}
 	return nil
 }
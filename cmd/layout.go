@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The runtime dir of a container, RUNTIME_ROOT/<id>, holds crio-lxc's
+// own state of the container:
+//
+//	lock            held by the commands that change the container
+//	state.json      the container metadata, see containerMetadata
+//	exit.json       the exit status of the init, see exitStatus
+//	env             the environment of the init, see writeEnvFile
+//	events.jsonl    the events of the container
+//	timings.json    how long create, start and the hooks took
+//	cni.json        the result of the CNI plugins
+//	oom-monitor.log the log of the oom monitor, unless --log is given
+//	hooks/oci.json  the OCI hooks the lxc hooks run
+//	execs/          the processes started by exec
+//	attach.sock     the socket of the stdio relay
+//	sync/           the init's sync socket and args, mounted read-only into the container
+//	notify/         the NOTIFY_SOCKET proxy, mounted into the container
+//
+// RUNTIME_ROOT/realtime.lock serializes the changes to the realtime
+// budgets of the cgroups shared by the containers, see realtime.go.
+//
+// The lxc dir of a container, LXC_PATH/<id>, is liblxc's container dir:
+//
+//	config          the translated lxc config
+//	seccomp         the seccomp policy
+//	lxc.log         the liblxc log, unless --log-file is given
+const (
+	lockFileName      = "lock"
+	stateFileName     = "state.json"
+	exitFileName      = "exit.json"
+	envFileName       = "env"
+	eventsFileName    = "events.jsonl"
+	timingsFileName   = "timings.json"
+	cniFileName       = "cni.json"
+	oomLogFileName    = "oom-monitor.log"
+	hooksDirName      = "hooks"
+	ociHooksFileName  = "oci.json"
+	execsDirName      = "execs"
+	attachSocketName  = "attach.sock"
+	syncDirName       = "sync"
+	notifyDirName     = "notify"
+	configFileName    = "config"
+	seccompFileName   = "seccomp"
+	lxcLogFileName    = "lxc.log"
+	realtimeLockName  = "realtime.lock"
+	legacyStateFile   = "crio-lxc.json"
+	legacyOCIHookFile = "oci-hooks.json"
+)
+
+// runtimeDir is the directory for crio-lxc's own state of a container.
+func runtimeDir(containerID string) string {
+	return filepath.Join(RUNTIME_ROOT, containerID)
+}
+
+// runtimePath returns the path of a file in the runtime dir.
+func runtimePath(containerID string, elem ...string) string {
+	return filepath.Join(append([]string{runtimeDir(containerID)}, elem...)...)
+}
+
+// lxcDir is liblxc's directory of a container.
+func lxcDir(containerID string) string {
+	return filepath.Join(LXC_PATH, containerID)
+}
+
+// lxcPath returns the path of a file in the lxc dir.
+func lxcPath(containerID string, elem ...string) string {
+	return filepath.Join(append([]string{lxcDir(containerID)}, elem...)...)
+}
+
+// withLegacyPath returns legacy instead of path if only legacy exists,
+// for the containers created before the layout changed.
+func withLegacyPath(path string, legacy string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+	return path
+}
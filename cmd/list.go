@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var listCmd = cli.Command{
+	Name:   "list",
+	Usage:  "lists containers",
+	Action: doList,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format (table or json)",
+			Value: "table",
+		},
+		cli.StringFlag{
+			Name:  "status",
+			Usage: "only list containers with this status (running or stopped)",
+		},
+		cli.StringSliceFlag{
+			Name:  "annotation",
+			Usage: "only list containers with annotation KEY=VALUE, or just KEY (repeatable)",
+		},
+		cli.StringSliceFlag{
+			Name:  "show-annotation",
+			Usage: "add a table column with the value of annotation KEY, e.g. io.kubernetes.pod.name (repeatable)",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only print container IDs",
+		},
+	},
+}
+
+// listContainerIDs returns the IDs of all containers in the lxc path, in
+// sorted order.
+func listContainerIDs() ([]string, error) {
+	entries, err := ioutil.ReadDir(LXC_PATH)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, fi := range entries {
+		if !fi.IsDir() {
+			continue
+		}
+		exists, err := containerExists(fi.Name())
+		if err != nil || !exists {
+			continue
+		}
+		ids = append(ids, fi.Name())
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func listStates() ([]*specs.State, error) {
+	ids, err := listContainerIDs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list containers")
+	}
+	states := []*specs.State{}
+	for _, id := range ids {
+		c, err := lxc.NewContainer(id, LXC_PATH)
+		if err != nil {
+			log.Warnf("failed to load container %s: %v", id, err)
+			continue
+		}
+		s, err := containerState(c, id)
+		c.Release()
+		if err != nil {
+			// e.g. deleted while we were listing
+			log.Debugf("failed to get state of %s: %v", id, err)
+			continue
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// matchAnnotations checks the KEY=VALUE or KEY filters against the
+// container's annotations. All of them have to match.
+func matchAnnotations(s *specs.State, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		value, ok := s.Annotations[parts[0]]
+		if !ok {
+			return false
+		}
+		if len(parts) == 2 && value != parts[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func doList(ctx *cli.Context) error {
+	format := ctx.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid format '%s'", format)
+	}
+
+	states, err := listStates()
+	if err != nil {
+		return err
+	}
+
+	filtered := []*specs.State{}
+	for _, s := range states {
+		if ctx.IsSet("status") && s.Status != ctx.String("status") {
+			continue
+		}
+		if !matchAnnotations(s, ctx.StringSlice("annotation")) {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+
+	if ctx.Bool("quiet") {
+		for _, s := range filtered {
+			fmt.Fprintln(os.Stdout, s.ID)
+		}
+		return nil
+	}
+
+	if format == "json" {
+		data, err := json.Marshal(filtered)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal json")
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	columns := ctx.StringSlice("show-annotation")
+	header := "ID\tPID\tSTATUS\tBUNDLE"
+	for _, key := range columns {
+		header += "\t" + strings.ToUpper(key)
+	}
+	fmt.Fprintln(w, header)
+	for _, s := range filtered {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s", s.ID, s.Pid, s.Status, s.Bundle)
+		for _, key := range columns {
+			value := s.Annotations[key]
+			if value == "" {
+				value = "-"
+			}
+			fmt.Fprintf(w, "\t%s", value)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// initPidTimeout bounds how long create waits for the container
+	// init to be cloned by the lxc monitor.
+	initPidTimeout = 5 * time.Second
+	// lxcQueryTimeout bounds queries to the lxc monitor, which can block
+	// for as long as the container init is stuck.
+	lxcQueryTimeout = 2 * time.Second
+)
+
+// procStartTime returns the start time of pid in clock ticks since boot,
+// which together with the pid identifies a process across pid reuse.
+func procStartTime(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// comm may contain spaces and parens, the fields we want follow the
+	// last ')'.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	// fields[0] is field 3 (state), starttime is field 22
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// procState returns the state letter of pid (R, S, Z, ...).
+func procState(pid int) (string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 1 {
+		return "", fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return fields[0], nil
+}
+
+// recordInitPid waits for the lxc monitor to start the container init and
+// saves its pid and start time in the container metadata. The pid comes
+// from liblxc: the monitor has other children, e.g. the hooks and
+// newuidmap, so its first child isn't necessarily the init.
+func recordInitPid(c *lxc.Container, containerID string) error {
+	deadline := time.Now().Add(initPidTimeout)
+	for {
+		pid, err := lxcInitPid(c, containerID)
+		if err != nil {
+			return err
+		}
+		if pid != 0 {
+			startTime, err := procStartTime(pid)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get start time of pid %d", pid)
+			}
+			md, err := readMetadata(containerID)
+			if err != nil {
+				return err
+			}
+			md.InitPid = pid
+			md.InitStartTime = startTime
+			return writeMetadata(containerID, md)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for container init")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pidAlive checks that pid still exists and is the process that was
+// recorded, not a new one that reused the pid.
+func pidAlive(pid int, startTime uint64) bool {
+	if err := unix.Kill(pid, 0); err != nil && err != unix.EPERM {
+		return false
+	}
+	st, err := procStartTime(pid)
+	if err != nil {
+		return false
+	}
+	return st == startTime
+}
+
+// containerRunning reports whether the container init is alive. It uses
+// the recorded init pid when there is one and otherwise asks lxc, giving
+// up after lxcQueryTimeout instead of hanging.
+func containerRunning(c *lxc.Container, containerID string) (bool, error) {
+	md, err := readMetadata(containerID)
+	if err == nil && md.InitPid > 0 {
+		return pidAlive(md.InitPid, md.InitStartTime), nil
+	}
+
+	running := make(chan bool, 1)
+	go func() {
+		running <- c.Running()
+	}()
+	select {
+	case r := <-running:
+		return r, nil
+	case <-time.After(lxcQueryTimeout):
+		return false, fmt.Errorf("timed out querying lxc for the state of '%s'", containerID)
+	}
+}
+
+// containerInitPid returns the pid of the container init, or 0 if it isn't
+// running.
+func containerInitPid(c *lxc.Container, containerID string) (int, error) {
+	md, err := readMetadata(containerID)
+	if err == nil && md.InitPid > 0 {
+		if pidAlive(md.InitPid, md.InitStartTime) {
+			return md.InitPid, nil
+		}
+		return 0, nil
+	}
+	return lxcInitPid(c, containerID)
+}
+
+// lxcInitPid asks the lxc monitor for the init pid, 0 if there is no init,
+// giving up after lxcQueryTimeout.
+func lxcInitPid(c *lxc.Container, containerID string) (int, error) {
+	pid := make(chan int, 1)
+	go func() {
+		pid <- c.InitPid()
+	}()
+	select {
+	case p := <-pid:
+		if p < 0 {
+			return 0, nil
+		}
+		return p, nil
+	case <-time.After(lxcQueryTimeout):
+		return 0, fmt.Errorf("timed out querying lxc for the init pid of '%s'", containerID)
+	}
+}
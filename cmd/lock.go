@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// lockContainer creates the runtime dir if needed and takes an exclusive
+// lock on it, so that concurrent invocations for the same container ID
+// don't interleave. The lock is released when the returned file is closed
+// or the process exits.
+func lockContainer(containerID string) (*os.File, error) {
+	if err := os.MkdirAll(RUNTIME_ROOT, stateDirMode()); err != nil {
+		return nil, errors.Wrapf(err, "failed to create '%s'", RUNTIME_ROOT)
+	}
+	dir := runtimeDir(containerID)
+	if err := os.Mkdir(dir, stateDirMode()); err != nil && !os.IsExist(err) {
+		return nil, errors.Wrap(err, "failed to create runtime dir")
+	}
+
+	lockPath := filepath.Join(dir, lockFileName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file '%s'", lockPath)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			return nil, fmt.Errorf("container '%s' is locked by another operation", containerID)
+		}
+		return nil, errors.Wrapf(err, "failed to lock '%s'", lockPath)
+	}
+	return f, nil
+}
+
+// waitLockContainer takes the lock of an existing container, waiting for
+// the operation that holds it. It is for the helpers that run next to the
+// container, which must not create the runtime dir again after delete.
+func waitLockContainer(containerID string) (*os.File, error) {
+	lockPath := runtimePath(containerID, lockFileName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file '%s'", lockPath)
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock '%s'", lockPath)
+	}
+	return f, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// logFields are added to every log entry, they are set up once the
+// command being run is known.
+var logFields = log.Fields{}
+
+// textHandler writes entries as a single line of text.
+type textHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (h *textHandler) HandleLog(e *log.Entry) error {
+	fields := mergeLogFields(e.Fields)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", e.Timestamp.Format(time.RFC3339), e.Level, e.Message)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%v", name, fields[name])
+	}
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// jsonHandler writes entries as logrus style json objects, which is what
+// cri-o expects to find in runtime logs.
+type jsonHandler struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONHandler(w io.Writer) *jsonHandler {
+	return &jsonHandler{enc: json.NewEncoder(w)}
+}
+
+func (h *jsonHandler) HandleLog(e *log.Entry) error {
+	entry := map[string]interface{}{}
+	for name, value := range mergeLogFields(e.Fields) {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[name] = value
+	}
+	entry["level"] = e.Level.String()
+	entry["msg"] = e.Message
+	entry["time"] = e.Timestamp.Format(time.RFC3339Nano)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.enc.Encode(entry)
+}
+
+func mergeLogFields(fields log.Fields) log.Fields {
+	merged := log.Fields{}
+	for name, value := range logFields {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	return merged
+}
+
+func newLogHandler(format string, w io.Writer) (log.Handler, error) {
+	switch format {
+	case "", "text":
+		return &textHandler{w: w}, nil
+	case "json":
+		return newJSONHandler(w), nil
+	case "journald":
+		return newJournaldHandler()
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', must be text, json or journald", format)
+	}
+}
+
+// runtimeLogLevel maps the lxc log levels accepted by --log-level to the
+// runtime's own log levels.
+func runtimeLogLevel(level string) (log.Level, error) {
+	switch level {
+	case "trace", "debug":
+		return log.DebugLevel, nil
+	case "info":
+		return log.InfoLevel, nil
+	case "warn":
+		return log.WarnLevel, nil
+	case "", "error":
+		return log.ErrorLevel, nil
+	default:
+		return log.InfoLevel, fmt.Errorf("log level can only be trace, debug, info, warn or error")
+	}
+}
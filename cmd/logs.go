@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const logsFollowInterval = 250 * time.Millisecond
+
+var logsCmd = cli.Command{
+	Name:   "logs",
+	Usage:  "prints the lxc log of a container",
+	Action: doLogs,
+	ArgsUsage: `[containerID]
+
+<containerID> is the ID of the container whose log to print
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "keep printing the log as it grows",
+		},
+	},
+}
+
+func doLogs(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "logs", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	logFile := lxcLogFile(ctx, containerID)
+	f, err := os.Open(logFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open log '%s'", logFile)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return errors.Wrap(err, "failed to print log")
+	}
+	if !ctx.Bool("follow") {
+		return nil
+	}
+
+	for {
+		time.Sleep(logsFollowInterval)
+
+		// Start over if the log was rotated or truncated.
+		reopen, err := logReplaced(f, logFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to check log")
+		}
+		if reopen {
+			newFile, err := os.Open(logFile)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to reopen log '%s'", logFile)
+			}
+			f.Close()
+			f = newFile
+		}
+
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return errors.Wrap(err, "failed to print log")
+		}
+	}
+}
+
+// logReplaced returns true when path is no longer the open file f, or when
+// f was truncated below the current read offset.
+func logReplaced(f *os.File, path string) (bool, error) {
+	cur, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(cur, fi) {
+		return true, nil
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() < offset {
+		_, err := f.Seek(0, io.SeekStart)
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type lxcConfigItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// lxcConfig is an lxc config built up in go and written out in one go,
+// rather than with a cgo call per item. Items keep the order they were set
+// in, which matters for keys like lxc.mount.entry. Files are written
+// along with the config, for items that refer to a file like
+// lxc.seccomp.profile.
+type lxcConfig struct {
+	items []lxcConfigItem
+	files []lxcConfigFile
+}
+
+type lxcConfigFile struct {
+	path string
+	data []byte
+}
+
+// Set appends an item. The config file format is line based, so values
+// can't contain newlines, and keys end at whitespace or the '='. Keys the
+// loaded liblxc doesn't support are rejected, see requireConfigKey.
+func (cfg *lxcConfig) Set(key string, value string) error {
+	if key == "" || strings.ContainsAny(key, " \t\r\n\v\f=") {
+		return fmt.Errorf("invalid config key %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("value for %s contains a newline: %q", key, value)
+	}
+	if err := requireConfigKey(key); err != nil {
+		return err
+	}
+	cfg.items = append(cfg.items, lxcConfigItem{Key: key, Value: value})
+	return nil
+}
+
+// Get returns all values set for key.
+func (cfg *lxcConfig) Get(key string) []string {
+	var values []string
+	for _, item := range cfg.items {
+		if item.Key == key {
+			values = append(values, item.Value)
+		}
+	}
+	return values
+}
+
+// AddFile adds a file that is written out with the config.
+func (cfg *lxcConfig) AddFile(path string, data []byte) {
+	cfg.files = append(cfg.files, lxcConfigFile{path, data})
+}
+
+func (cfg *lxcConfig) Bytes() []byte {
+	var b bytes.Buffer
+	for _, item := range cfg.items {
+		fmt.Fprintf(&b, "%s = %s\n", item.Key, item.Value)
+	}
+	return b.Bytes()
+}
+
+// Write atomically writes the config file to path, after the files it
+// refers to.
+func (cfg *lxcConfig) Write(path string) error {
+	for _, f := range cfg.files {
+		if err := writeFileAtomic(f.path, f.data, 0640); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, cfg.Bytes(), 0640)
+}
+
+// readLXCConfig parses a saved lxc config file into its items, in order.
+func readLXCConfig(path string) ([]lxcConfigItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := []lxcConfigItem{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		items = append(items, lxcConfigItem{
+			Key:   strings.TrimSpace(parts[0]),
+			Value: strings.TrimSpace(parts[1]),
+		})
+	}
+	return items, scanner.Err()
+}
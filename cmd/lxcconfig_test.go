@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestLXCConfigSet(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{"lxc.uts.name", "c1", false},
+		{"lxc.mount.entry", "proc proc proc nodev 0 0", false},
+		{"lxc.environment", "", false},
+		{"lxc.uts.name", "c1\nlxc.rootfs.path = /", true},
+		{"lxc.uts.name", "c1\r", true},
+		{"", "c1", true},
+		{"lxc.uts.name = c2\nlxc.uts.name", "c1", true},
+		{"lxc.cgroup2.memory.max=1", "2", true},
+		{"lxc.cgroup2.memory max", "1", true},
+		{"lxc.cgroup2.memory.max\t", "1", true},
+	}
+	for _, tt := range tests {
+		cfg := &lxcConfig{}
+		err := cfg.Set(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q, %q) error = %v, want error %v", tt.key, tt.value, err, tt.wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(cfg.Get(tt.key), []string{tt.value}) {
+			t.Errorf("Get(%q) = %q after Set", tt.key, cfg.Get(tt.key))
+		}
+	}
+}
+
+func TestLXCConfigGetKeepsOrder(t *testing.T) {
+	cfg := &lxcConfig{}
+	for _, entry := range []string{"a", "b", "c"} {
+		if err := cfg.Set("lxc.mount.entry", entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Set("lxc.uts.name", "x"+entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cfg.Get("lxc.mount.entry"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Get = %q, want a, b, c", got)
+	}
+	if got := cfg.Get("lxc.rootfs.path"); got != nil {
+		t.Errorf("Get of an unset key = %q", got)
+	}
+}
+
+func TestLXCConfigBytes(t *testing.T) {
+	cfg := &lxcConfig{}
+	cfg.Set("lxc.uts.name", "c1")
+	cfg.Set("lxc.mount.entry", "/src dst none bind 0 0")
+	want := "lxc.uts.name = c1\nlxc.mount.entry = /src dst none bind 0 0\n"
+	if got := string(cfg.Bytes()); got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+}
+
+func TestLXCConfigWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxcconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &lxcConfig{}
+	cfg.Set("lxc.uts.name", "c1")
+	cfg.Set("lxc.seccomp.profile", filepath.Join(dir, "seccomp"))
+	cfg.Set("lxc.environment", "A=b=c")
+	cfg.AddFile(filepath.Join(dir, "seccomp"), []byte("2\nallowlist\n"))
+	path := filepath.Join(dir, "config")
+	if err := cfg.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := readLXCConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, cfg.items) {
+		t.Errorf("readLXCConfig = %v, want %v", items, cfg.items)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "seccomp"))
+	if err != nil || string(data) != "2\nallowlist\n" {
+		t.Errorf("config file = %q, %v", data, err)
+	}
+}
+
+func TestReadLXCConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []lxcConfigItem
+	}{
+		{"empty", "", []lxcConfigItem{}},
+		{"comments and blank lines", "# comment\n\n  # indented\nlxc.uts.name = c1\n", []lxcConfigItem{{"lxc.uts.name", "c1"}}},
+		{"whitespace", "  lxc.uts.name=c1  \n", []lxcConfigItem{{"lxc.uts.name", "c1"}}},
+		{"value with =", "lxc.environment = A=b\n", []lxcConfigItem{{"lxc.environment", "A=b"}}},
+		{"empty value", "lxc.apparmor.profile =\n", []lxcConfigItem{{"lxc.apparmor.profile", ""}}},
+		{"no =", "garbage\nlxc.uts.name = c1\n", []lxcConfigItem{{"lxc.uts.name", "c1"}}},
+	}
+	dir, err := ioutil.TempDir("", "lxcconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		path := filepath.Join(dir, "config")
+		if err := ioutil.WriteFile(path, []byte(tt.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readLXCConfig(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readLXCConfig = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetNamespace(t *testing.T) {
+	spec := &specs.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.NetworkNamespace, Path: "/proc/1/ns/net"},
+	}}}
+	tests := []struct {
+		spec   *specs.Spec
+		nsType specs.LinuxNamespaceType
+		want   *specs.LinuxNamespace
+	}{
+		{spec, specs.PIDNamespace, &specs.LinuxNamespace{Type: specs.PIDNamespace}},
+		{spec, specs.NetworkNamespace, &specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: "/proc/1/ns/net"}},
+		{spec, specs.UTSNamespace, nil},
+		{&specs.Spec{}, specs.PIDNamespace, nil},
+	}
+	for _, tt := range tests {
+		if got := getNamespace(tt.spec, tt.nsType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getNamespace(%s) = %v, want %v", tt.nsType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+// lxcHookTypes are the lxc lifecycle hooks that can be added with
+// --lxc-hook and ANNOTATION_LXC_HOOK_PREFIX annotations.
+var lxcHookTypes = []string{
+	"pre-start",
+	"pre-mount",
+	"mount",
+	"autodev",
+	"start-host",
+	"stop",
+	"post-stop",
+}
+
+func isLXCHookType(hookType string) bool {
+	for _, t := range lxcHookTypes {
+		if t == hookType {
+			return true
+		}
+	}
+	return false
+}
+
+// lxcHook is a hook to add to a container's config.
+type lxcHook struct {
+	Type string
+	Path string
+}
+
+// parseLXCHook parses a --lxc-hook TYPE=PATH value.
+func parseLXCHook(value string) (lxcHook, error) {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || !isLXCHookType(kv[0]) || !filepath.IsAbs(kv[1]) {
+		return lxcHook{}, fmt.Errorf("bad lxc hook %q, expected TYPE=PATH with an absolute path and TYPE one of %s",
+			value, strings.Join(lxcHookTypes, ", "))
+	}
+	return lxcHook{Type: kv[0], Path: kv[1]}, nil
+}
+
+// annotationLXCHook resolves the hook of an annotation. Annotations come
+// from the pod's users, so they can only name a hook installed in the
+// hooks dir, not run arbitrary programs on the host.
+func annotationLXCHook(hooksDir string, annotation string, name string) (lxcHook, error) {
+	hookType := strings.TrimPrefix(annotation, ANNOTATION_LXC_HOOK_PREFIX)
+	if !isLXCHookType(hookType) {
+		return lxcHook{}, fmt.Errorf("unknown lxc hook type in annotation %s", annotation)
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return lxcHook{}, fmt.Errorf("annotation %s must name a hook in %s", annotation, hooksDir)
+	}
+	path := filepath.Join(hooksDir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return lxcHook{}, fmt.Errorf("hook %s of annotation %s: %v", name, annotation, err)
+	}
+	if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+		return lxcHook{}, fmt.Errorf("hook %s of annotation %s is not an executable file", path, annotation)
+	}
+	return lxcHook{Type: hookType, Path: path}, nil
+}
+
+// configureLXCHooks adds the hooks configured for all containers with
+// --lxc-hook, then the ones of the container's annotations. liblxc runs
+// them with LXC_NAME set to the container ID and, since lxc.hook.version
+// is 1, the hook type in LXC_HOOK_TYPE, so one hook can serve many
+// containers. The hooks run through hook-timer, which records their
+// durations.
+func configureLXCHooks(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	hooks := []lxcHook{}
+	for _, value := range ctx.GlobalStringSlice("lxc-hook") {
+		hook, err := parseLXCHook(value)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	annotations := []string{}
+	for annotation := range spec.Annotations {
+		if strings.HasPrefix(annotation, ANNOTATION_LXC_HOOK_PREFIX) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	sort.Strings(annotations)
+	for _, annotation := range annotations {
+		hook, err := annotationLXCHook(ctx.GlobalString("lxc-hooks-dir"), annotation, spec.Annotations[annotation])
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	for _, hook := range hooks {
+		value, err := timedHook(hook.Path)
+		if err != nil {
+			return err
+		}
+		if err := cfg.Set("lxc.hook."+hook.Type, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// When liblxc fails to start a container, the error create or start
+// returns says little more than that. The cause is in the lxc log, so the
+// ERROR lines liblxc wrote while the command ran are logged and added to
+// the error, where cri-o shows it in the container's events.
+
+// lxcLogErrorLines is the number of error lines added to an error.
+const lxcLogErrorLines = 3
+
+// lxcLogLevels are the liblxc levels that count as errors.
+var lxcLogLevels = []string{" ERROR ", " CRIT ", " ALERT ", " FATAL "}
+
+// lxcLogOffset is where the log ends now, the start of what is logged
+// next.
+func lxcLogOffset(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// lxcLogErrors returns the last error lines of the log after offset,
+// without their prefix of timestamp and level.
+func lxcLogErrors(path string, offset int64) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	// rotated or truncated since
+	if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, level := range lxcLogLevels {
+			// e.g. "lxc 20190405123456.789 ERROR    start - start.c:lxc_spawn:1811 - ..."
+			if i := strings.Index(line, level); i >= 0 {
+				lines = append(lines, strings.TrimSpace(line[i+len(level):]))
+				break
+			}
+		}
+	}
+	if len(lines) > lxcLogErrorLines {
+		lines = lines[len(lines)-lxcLogErrorLines:]
+	}
+	return lines
+}
+
+// withLXCErrors logs the errors liblxc logged since offset and adds them
+// to err.
+func withLXCErrors(err error, path string, offset int64) error {
+	lines := lxcLogErrors(path, offset)
+	if len(lines) == 0 {
+		return err
+	}
+	for _, line := range lines {
+		log.Errorf("lxc: %s", line)
+	}
+	return errors.Wrapf(err, "lxc: %s", strings.Join(lines, "; "))
+}
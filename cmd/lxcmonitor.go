@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// The monitor command follows the state changes liblxc reports for all
+// containers in the lxc path, and records them: init pid changes in the
+// metadata, the exit status of containers whose monitor process didn't
+// record it, and both as events. This keeps state accurate when a
+// container's monitor was killed or never started. With --log-max-size it
+// also rotates the lxc logs of running containers.
+//
+// liblxc's container monitors send their messages to a fifo per lxc path,
+// which lxc-monitord reads and serves on an abstract socket. If
+// lxc-monitord is running we connect to it, otherwise we read the fifo
+// ourselves.
+
+// logRotateInterval is how often the monitor rotates the lxc logs, when
+// --log-max-size is set.
+const logRotateInterval = time.Minute
+
+// lxcMsg is liblxc's struct lxc_msg.
+type lxcMsg struct {
+	Type  int32
+	Name  [256]byte
+	Value int32
+}
+
+const (
+	lxcMsgState    = 0
+	lxcMsgExitCode = 2
+)
+
+// lxcStates are liblxc's lxc_state_t, by value.
+var lxcStates = []string{"STOPPED", "STARTING", "RUNNING", "STOPPING", "ABORTING", "FREEZING", "FROZEN", "THAWED"}
+
+var lxcMonitorCmd = cli.Command{
+	Name:   "monitor",
+	Usage:  "records the state changes liblxc reports for all containers, e.g. as a service",
+	Action: doLXCMonitor,
+}
+
+// lxcMonitorSocket is lxc-monitord's abstract socket for the lxc path,
+// see lxc_monitor_sock_name in liblxc.
+func lxcMonitorSocket(lxcpath string) string {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("lxc/%s/monitor-sock", lxcpath)))
+	name := fmt.Sprintf("lxc/%016x/%s", h.Sum64(), lxcpath)
+	// sun_path is 108 bytes, with the leading and a trailing NUL
+	if len(name) > 106 {
+		name = name[:106]
+	}
+	return "@" + name
+}
+
+// lxcMonitorFifo is the fifo liblxc's container monitors write to, see
+// lxc_monitor_fifo_name in liblxc.
+func lxcMonitorFifo(lxcpath string) string {
+	rundir := "/run"
+	if os.Geteuid() != 0 {
+		rundir = os.Getenv("XDG_RUNTIME_DIR")
+		if rundir == "" {
+			rundir = filepath.Join(os.Getenv("HOME"), ".cache/lxc/run")
+		}
+	}
+	return filepath.Join(rundir, "lxc", lxcpath, "monitor-fifo")
+}
+
+// openLXCMonitor connects to lxc-monitord or opens the fifo.
+func openLXCMonitor(lxcpath string) (io.ReadCloser, error) {
+	if conn, err := net.Dial("unix", lxcMonitorSocket(lxcpath)); err == nil {
+		log.Infof("reading lxc-monitord of %s", lxcpath)
+		return conn, nil
+	}
+	fifo := lxcMonitorFifo(lxcpath)
+	if err := os.MkdirAll(filepath.Dir(fifo), 0755); err != nil {
+		return nil, err
+	}
+	if err := unix.Mkfifo(fifo, 0600); err != nil && err != unix.EEXIST {
+		return nil, errors.Wrapf(err, "failed to create %s", fifo)
+	}
+	// opened for writing as well, so that reads don't see EOF when no
+	// container writes to it
+	f, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("reading %s", fifo)
+	return f, nil
+}
+
+func doLXCMonitor(ctx *cli.Context) error {
+	monitor, err := openLXCMonitor(LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to open lxc monitor")
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT)
+	go func() {
+		<-signals
+		monitor.Close()
+	}()
+	if ctx.GlobalInt64("log-max-size") > 0 {
+		go func() {
+			for range time.Tick(logRotateInterval) {
+				rotateContainerLogs(ctx)
+			}
+		}()
+	}
+
+	for {
+		var msg lxcMsg
+		if err := binary.Read(monitor, binary.LittleEndian, &msg); err != nil {
+			// closed by a signal
+			return nil
+		}
+		name := string(bytes.TrimRight(msg.Name[:], "\x00"))
+		// the monitor of lxc-monitord covers all containers in the
+		// lxc path, not only ours
+		if exists, err := containerExists(name); err != nil || !exists {
+			continue
+		}
+		if err := handleLXCMsg(name, &msg); err != nil {
+			log.Warnf("failed to record state change of %s: %v", name, err)
+		}
+	}
+}
+
+func handleLXCMsg(containerID string, msg *lxcMsg) error {
+	switch msg.Type {
+	case lxcMsgState:
+		if msg.Value < 0 || int(msg.Value) >= len(lxcStates) {
+			return nil
+		}
+		state := lxcStates[msg.Value]
+		if err := appendEvent(containerID, "state", map[string]string{"state": state}); err != nil {
+			return err
+		}
+		if state == "RUNNING" {
+			return recordMonitoredInitPid(containerID)
+		}
+	case lxcMsgExitCode:
+		return recordMonitoredExit(containerID, unix.WaitStatus(msg.Value))
+	}
+	return nil
+}
+
+// recordMonitoredInitPid updates the init pid of the metadata, if it
+// changed, e.g. after a restore.
+func recordMonitoredInitPid(containerID string) error {
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+	pid := c.InitPid()
+	if pid <= 0 {
+		return nil
+	}
+	lock, err := waitLockContainer(containerID)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return err
+	}
+	if md.InitPid == pid {
+		return nil
+	}
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get start time of pid %d", pid)
+	}
+	log.Infof("init pid of %s changed from %d to %d", containerID, md.InitPid, pid)
+	md.InitPid = pid
+	md.InitStartTime = startTime
+	return writeMetadata(containerID, md)
+}
+
+// recordMonitoredExit records the exit status, unless the container's
+// monitor process did.
+func recordMonitoredExit(containerID string, status unix.WaitStatus) error {
+	if st, err := readExitStatus(containerID); err != nil || st != nil {
+		return err
+	}
+	code := status.ExitStatus()
+	if status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	data, err := json.Marshal(&exitStatus{ExitCode: code, FinishedAt: time.Now().UnixNano()})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(exitStatusPath(containerID), data, 0600); err != nil {
+		return err
+	}
+	return appendEvent(containerID, "exit", map[string]int{"exitCode": code})
+}
@@ -2,16 +2,28 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 	//	"gopkg.in/yaml.v2"
 )
 
 var (
-	version = ""
-	debug   = false
+	version    = ""
+	commit     = ""
+	debug      = false
+	logFormat  = "text"
+	logToFile  = false
+	logFile    = ""
+	command    = ""
+	metricsDir = ""
+	auditLog   = ""
 )
 
 func main() {
@@ -19,45 +31,328 @@ func main() {
 	app.Name = "crio-lxc"
 	app.Usage = "crio-lxc is a CRI compliant runtime wrapper for lxc"
 	app.Version = version
+
+	setRootlessDefaults()
 	app.Commands = []cli.Command{
 		stateCmd,
+		listCmd,
 		createCmd,
+		runCmd,
 		startCmd,
 		killCmd,
+		stopCmd,
+		execCmd,
 		deleteCmd,
+		translateCmd,
+		checkCmd,
+		versionCmd,
+		debugCollectCmd,
+		inspectCmd,
+		logsCmd,
+		eventsCmd,
+		consoleCmd,
+		attachCmd,
+		resizeTtyCmd,
+		daemonCmd,
+		lxcMonitorCmd,
+		deviceAddCmd,
+		updateCmd,
+		checkpointCmd,
+		restoreCmd,
+		snapshotCmd,
+		cloneCmd,
+		unpackCmd,
+		selftestCmd,
+		notifyProxyCmd,
+		oomMonitorCmd,
+		stdioRelayCmd,
+		hookTimerCmd,
+		ociHookCmd,
+		internalCmd,
+		internalRestoreCmd,
 	}
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "root",
+			Usage:  "directory for the runtime's container state",
+			Value:  RUNTIME_ROOT,
+			EnvVar: "CRIO_LXC_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "lxc-path",
+			Usage:  "lxc path for container configs and logs",
+			Value:  LXC_PATH,
+			EnvVar: "CRIO_LXC_PATH",
+		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "enable debug mode",
 		},
 		cli.StringFlag{
 			Name:  "log-level",
-			Usage: "set log level for LXC",
+			Usage: "set log level for LXC and the runtime",
 		},
 		cli.StringFlag{
 			Name:  "log-file",
 			Usage: "log file for LXC",
 		},
+		cli.Int64Flag{
+			Name:  "log-max-size",
+			Usage: "rotate the LXC log file once it is bigger than this many bytes (0 disables rotation)",
+		},
+		cli.IntFlag{
+			Name:  "log-max-files",
+			Usage: "number of rotated LXC log files to keep",
+			Value: 1,
+		},
+		cli.StringFlag{
+			Name:  "log",
+			Usage: "write runtime logs to this file instead of stderr",
+		},
+		cli.StringFlag{
+			Name:  "metrics-dir",
+			Usage: "write prometheus textfile metrics to this directory",
+		},
+		cli.StringFlag{
+			Name:   "state-socket",
+			Usage:  "push state transitions of containers as json lines to this unix socket",
+			EnvVar: "CRIO_LXC_STATE_SOCKET",
+		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Usage:  "append a record of each create, start, kill, stop, delete and exec to this file",
+			EnvVar: "CRIO_LXC_AUDIT_LOG",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "set the runtime log format (text, json or journald)",
+			Value: "text",
+		},
+		cli.StringFlag{
+			Name:   "capability-strategy",
+			Usage:  "how to write capabilities to the lxc config (auto, keep or drop)",
+			Value:  capStrategy,
+			EnvVar: "CRIO_LXC_CAPABILITY_STRATEGY",
+		},
+		cli.BoolFlag{
+			Name:   "lxc-defaults",
+			Usage:  "include liblxc's default config (lxc.default_config) in container configs",
+			EnvVar: "CRIO_LXC_DEFAULTS",
+		},
+		cli.BoolFlag{
+			Name:   "default-seccomp",
+			Usage:  "apply the built in seccomp profile to containers without a seccomp config",
+			EnvVar: "CRIO_LXC_DEFAULT_SECCOMP",
+		},
+		cli.BoolFlag{
+			Name:   "default-apparmor",
+			Usage:  "apply the runtime's default apparmor profile to containers without an apparmor profile",
+			EnvVar: "CRIO_LXC_DEFAULT_APPARMOR",
+		},
+		cli.StringFlag{
+			Name:   "proc-hardening",
+			Usage:  "mount /proc with hidepid (hidepid) or hidepid and subset=pid (subset), or not (off)",
+			Value:  procHardeningOff,
+			EnvVar: "CRIO_LXC_PROC_HARDENING",
+		},
+		cli.BoolFlag{
+			Name:   "numa-align",
+			Usage:  "restrict the memory nodes of containers to the NUMA nodes of their cpus",
+			EnvVar: "CRIO_LXC_NUMA_ALIGN",
+		},
+		cli.StringSliceFlag{
+			Name:  "raw-config-allow",
+			Usage: "lxc config keys, or prefixes ending in '.', allowed in org.linuxcontainers.lxc.config. annotations (none by default, only resource, environment, signal, tty and proc keys can be allowed)",
+		},
+		cli.StringSliceFlag{
+			Name:  "lxc-hook",
+			Usage: "add the lxc hook TYPE=PATH (e.g. pre-mount=/usr/local/bin/hook) to all containers",
+		},
+		cli.StringSliceFlag{
+			Name:  "hooks-dir",
+			Usage: "directories of oci hook configs in the hooks.d format (default: " + strings.Join(defaultOCIHookDirs, ", ") + ")",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "runtime config file with the mounts, devices and environment added to all containers",
+			Value:  defaultRuntimeConfig,
+			EnvVar: "CRIO_LXC_CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "lxc-hooks-dir",
+			Usage: "directory of the lxc hooks that annotations can name",
+			Value: "/usr/share/crio-lxc/hooks",
+		},
 	}
-
+	app.Flags = append(app.Flags, profilingFlags...)
+	// the global flags of runc that cri-o and containerd pass
+	app.Flags = append(app.Flags,
+		cli.BoolFlag{
+			Name:  "systemd-cgroup",
+			Usage: "cgroupsPath is slice:prefix:name, as with runc's systemd cgroup driver",
+		},
+		cli.StringFlag{
+			Name:  "rootless",
+			Usage: "run as a rootless runtime (true, false or auto)",
+			Value: "auto",
+		},
+		cli.StringFlag{
+			Name:   "relative-cgroups",
+			Usage:  "create container cgroups below the runtime's own cgroup (true, false or auto)",
+			Value:  "auto",
+			EnvVar: "CRIO_LXC_RELATIVE_CGROUPS",
+		},
+		cli.StringFlag{
+			Name:  "criu",
+			Usage: "path to the criu binary used by checkpoint and restore",
+			Value: "criu",
+		},
+	)
+	app.Flags = append(app.Flags, cli.StringFlag{
+		Name:  "daemon-socket",
+		Usage: "forward create, start, kill and state to the daemon listening on this socket",
+	}, cli.BoolFlag{
+		Name:   "config-cache",
+		Usage:  "cache translated lxc configs of identical specs",
+		EnvVar: "CRIO_LXC_CONFIG_CACHE",
+	}, cli.BoolFlag{
+		Name:   "tmpfs-state",
+		Usage:  "keep the runtime root and lxc path on tmpfs, mounting one if needed (the lxc path defaults to <root>/lxc)",
+		EnvVar: "CRIO_LXC_TMPFS_STATE",
+	}, cli.StringFlag{
+		Name:  "tmpfs-size",
+		Usage: "size option of the tmpfs mounted by --tmpfs-state",
+	})
 	app.Before = func(ctx *cli.Context) error {
-		//var err error
-
 		debug = ctx.Bool("debug")
-		return nil
+		RUNTIME_ROOT = ctx.String("root")
+		LXC_PATH = ctx.String("lxc-path")
+		metricsDir = ctx.String("metrics-dir")
+		auditLog = ctx.String("audit-log")
+		logFormat = ctx.String("log-format")
+		capStrategy = ctx.String("capability-strategy")
+		systemdCgroup = ctx.Bool("systemd-cgroup")
+		switch rootless := ctx.String("rootless"); rootless {
+		case "auto", "true", "false":
+			rootlessMode = rootless
+		default:
+			return fmt.Errorf("--rootless must be true, false or auto")
+		}
+		switch relative := ctx.String("relative-cgroups"); relative {
+		case "auto", "true", "false":
+			relativeCgroupsMode = relative
+		default:
+			return fmt.Errorf("--relative-cgroups must be true, false or auto")
+		}
+		if err := useCriu(ctx.String("criu")); err != nil {
+			return err
+		}
+		if rootlessTmpDir != "" && !ctx.IsSet("root") {
+			if err := checkRootlessTmpDir(); err != nil {
+				return err
+			}
+		}
+		if ctx.Bool("tmpfs-state") {
+			if err := useTmpfsState(ctx); err != nil {
+				return err
+			}
+		}
+
+		logWriter := io.Writer(os.Stderr)
+		if ctx.IsSet("log") {
+			path, err := filepath.Abs(ctx.String("log"))
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+			if err != nil {
+				return errors.Wrapf(err, "failed to open log file '%s'", path)
+			}
+			logFile = path
+			logWriter = f
+			logToFile = true
+		}
+
+		handler, err := newLogHandler(logFormat, logWriter)
+		if err != nil {
+			return err
+		}
+		log.SetHandler(handler)
+
+		if ctx.IsSet("log-level") {
+			level, err := runtimeLogLevel(ctx.String("log-level"))
+			if err != nil {
+				return err
+			}
+			log.SetLevel(level)
+		}
+		return startProfiling(ctx)
+	}
+
+	daemonActions := map[string]func(*cli.Context) error{
+		"create": forwardToDaemon("Create", doCreate),
+		"start":  forwardToDaemon("Start", doStart),
+		"kill":   forwardToDaemon("Kill", doKill),
+		"state":  forwardToDaemon("State", doState),
+	}
+	for i := range app.Commands {
+		app.Commands[i].Before = beforeCommand
+		if action, ok := daemonActions[app.Commands[i].Name]; ok {
+			app.Commands[i].Action = action
+		}
 	}
 
 	log.SetLevel(log.InfoLevel)
 
-	if err := app.Run(os.Args); err != nil {
+	started := time.Now()
+	err := app.Run(os.Args)
+	stopProfiling()
+	flushTracing(err)
+	if metricsDir != "" && command != "" {
+		if err := recordMetrics(metricsDir, command, time.Since(started), err); err != nil {
+			log.Debugf("failed to record metrics: %v", err)
+		}
+	}
+	if auditLog != "" && command != "" {
+		containerID, _ := logFields["id"].(string)
+		if err := recordAudit(auditLog, command, containerID, err); err != nil {
+			log.Errorf("failed to write audit log: %v", err)
+		}
+	}
+	if err != nil {
 		format := "error: %v\n"
 		if debug {
 			format = "error: %+v\n"
 		}
 
-		fmt.Fprintf(os.Stderr, format, err)
-		os.Exit(1)
+		kind, code := errorKind(err)
+		if kind == "exit status" {
+			os.Exit(code)
+		}
+		entry := log.WithField("kind", kind)
+		switch {
+		case logFormat == "json" && !logToFile:
+			entry.Errorf(strings.TrimSuffix(format, "\n"), err)
+		case logFormat == "journald" || logToFile:
+			entry.Errorf(strings.TrimSuffix(format, "\n"), err)
+			fmt.Fprintf(os.Stderr, format, err)
+		default:
+			fmt.Fprintf(os.Stderr, format, err)
+		}
+		os.Exit(code)
+	}
+}
+
+// beforeCommand adds the command and container ID to all log entries and
+// starts tracing the command.
+func beforeCommand(ctx *cli.Context) error {
+	containerID := ctx.Args().First()
+	command = ctx.Command.Name
+	logFields["command"] = ctx.Command.Name
+	if containerID != "" {
+		logFields["id"] = containerID
 	}
+	initTracing(ctx.Command.Name, containerID)
+	return nil
 }
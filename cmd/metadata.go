@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// containerMetadata is what crio-lxc needs to remember about a container
+// beyond what is in its lxc config.
+type containerMetadata struct {
+	// Bundle is the absolute path of the bundle the container was
+	// created from.
+	Bundle string `json:"bundle"`
+	// OCIVersion is the ociVersion of the spec, reported in the state.
+	OCIVersion string `json:"ociVersion,omitempty"`
+	// InitPid and InitStartTime identify the container init, so that
+	// liveness can be checked without asking the lxc monitor.
+	InitPid       int    `json:"initPid,omitempty"`
+	InitStartTime uint64 `json:"initStartTime,omitempty"`
+	// Annotations are the spec's annotations, reported in the state
+	// even when the bundle is gone.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Seccomp is where the seccomp profile came from, see seccompSource,
+	// and AppArmor the apparmor profile that was applied.
+	Seccomp  string `json:"seccomp,omitempty"`
+	AppArmor string `json:"apparmor,omitempty"`
+	// OOMKilled is set by the oom monitor when a process of the
+	// container was killed by the OOM killer.
+	OOMKilled bool `json:"oomKilled,omitempty"`
+	// RealtimeBudget is what create added to the realtime budgets of
+	// the parent cgroups, see prepareRealtimeBudget.
+	RealtimeBudget *realtimeBudget `json:"realtimeBudget,omitempty"`
+}
+
+// exitStatus is recorded by the container's monitor when the init exits,
+// see record_exit in internal.go.
+type exitStatus struct {
+	ExitCode int `json:"exitCode"`
+	// FinishedAt is in nanoseconds since the epoch.
+	FinishedAt int64 `json:"finishedAt"`
+}
+
+func exitStatusPath(containerID string) string {
+	return runtimePath(containerID, exitFileName)
+}
+
+// readExitStatus returns nil if the init hasn't exited.
+func readExitStatus(containerID string) (*exitStatus, error) {
+	data, err := ioutil.ReadFile(exitStatusPath(containerID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &exitStatus{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, errors.Wrap(err, "invalid exit status")
+	}
+	return st, nil
+}
+
+func metadataPath(containerID string) string {
+	return runtimePath(containerID, stateFileName)
+}
+
+func writeMetadata(containerID string, md *containerMetadata) error {
+	data, err := json.Marshal(md)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal metadata")
+	}
+	if err := writeFileAtomic(metadataPath(containerID), data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write metadata")
+	}
+	return nil
+}
+
+func readMetadata(containerID string) (*containerMetadata, error) {
+	path := withLegacyPath(metadataPath(containerID), runtimePath(containerID, legacyStateFile))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read metadata")
+	}
+	md := &containerMetadata{}
+	if err := json.Unmarshal(data, md); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal metadata")
+	}
+	return md, nil
+}
+
+// stateVersion is the ociVersion of the state, that of the spec. The
+// containers created before it was recorded report the vendored one.
+func (md *containerMetadata) stateVersion() string {
+	if md.OCIVersion != "" {
+		return md.OCIVersion
+	}
+	return specs.Version
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Metrics are written for the node exporter's textfile collector. Every
+// invocation is a separate process, so the counters are kept in state
+// files next to the textfile, one per command, each updated under a lock
+// of its own. The textfile is made from the state files and the container
+// snapshot without taking any lock: the state files are replaced
+// atomically, and containers that go away while they are read are left
+// out.
+
+const (
+	metricsStatePrefix = "crio-lxc-metrics-"
+	metricsTextFile    = "crio-lxc.prom"
+)
+
+var metricsBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type operationMetrics struct {
+	Success int64   `json:"success"`
+	Failure int64   `json:"failure"`
+	Buckets []int64 `json:"buckets"`
+	Sum     float64 `json:"sum"`
+	Count   int64   `json:"count"`
+}
+
+type metricsState struct {
+	Operations map[string]*operationMetrics `json:"operations"`
+}
+
+func (m *operationMetrics) observe(d time.Duration, err error) {
+	if err == nil {
+		m.Success++
+	} else {
+		m.Failure++
+	}
+	if len(m.Buckets) != len(metricsBuckets) {
+		m.Buckets = make([]int64, len(metricsBuckets))
+	}
+	seconds := d.Seconds()
+	for i, le := range metricsBuckets {
+		if seconds <= le {
+			m.Buckets[i]++
+		}
+	}
+	m.Sum += seconds
+	m.Count++
+}
+
+func metricsStatePath(dir string, command string) string {
+	return filepath.Join(dir, metricsStatePrefix+command+".json")
+}
+
+// recordMetrics adds the outcome of an operation to the metrics in dir and
+// rewrites the textfile.
+func recordMetrics(dir string, command string, d time.Duration, opErr error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create metrics dir")
+	}
+	if err := recordOperation(dir, command, d, opErr); err != nil {
+		return err
+	}
+
+	state := readMetricsState(dir)
+	text := formatMetrics(state)
+	return writeFileAtomic(filepath.Join(dir, metricsTextFile), text, 0644)
+}
+
+// recordOperation updates the counters of the command, holding the lock
+// of the command only while it does.
+func recordOperation(dir string, command string, d time.Duration, opErr error) error {
+	statePath := metricsStatePath(dir, command)
+	lockFile, err := os.OpenFile(statePath+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open metrics lock")
+	}
+	defer lockFile.Close()
+	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Wrap(err, "failed to lock metrics")
+	}
+
+	m := &operationMetrics{}
+	data, err := ioutil.ReadFile(statePath)
+	if err == nil {
+		if err := json.Unmarshal(data, m); err != nil {
+			return errors.Wrap(err, "failed to parse metrics state")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read metrics state")
+	}
+	m.observe(d, opErr)
+
+	data, err = json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal metrics state")
+	}
+	return errors.Wrap(writeFileAtomic(statePath, data, 0644), "failed to write metrics state")
+}
+
+// readMetricsState reads the counters of all commands, skipping the state
+// files that can't be read.
+func readMetricsState(dir string) *metricsState {
+	state := &metricsState{Operations: map[string]*operationMetrics{}}
+	paths, _ := filepath.Glob(filepath.Join(dir, metricsStatePrefix+"*.json"))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		m := &operationMetrics{}
+		if err := json.Unmarshal(data, m); err != nil || len(m.Buckets) != len(metricsBuckets) {
+			log.Debugf("skipping metrics state '%s'", path)
+			continue
+		}
+		command := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), metricsStatePrefix), ".json")
+		state.Operations[command] = m
+	}
+	return state
+}
+
+func formatMetrics(state *metricsState) []byte {
+	var commands []string
+	for command := range state.Operations {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var b bytes.Buffer
+	b.WriteString("# HELP crio_lxc_operations_total Runtime operations by command and result.\n")
+	b.WriteString("# TYPE crio_lxc_operations_total counter\n")
+	for _, command := range commands {
+		m := state.Operations[command]
+		fmt.Fprintf(&b, "crio_lxc_operations_total{command=%q,result=\"success\"} %d\n", command, m.Success)
+		fmt.Fprintf(&b, "crio_lxc_operations_total{command=%q,result=\"failure\"} %d\n", command, m.Failure)
+	}
+
+	b.WriteString("# HELP crio_lxc_operation_duration_seconds Runtime operation latency.\n")
+	b.WriteString("# TYPE crio_lxc_operation_duration_seconds histogram\n")
+	for _, command := range commands {
+		m := state.Operations[command]
+		for i, le := range metricsBuckets {
+			fmt.Fprintf(&b, "crio_lxc_operation_duration_seconds_bucket{command=%q,le=\"%g\"} %d\n", command, le, m.Buckets[i])
+		}
+		fmt.Fprintf(&b, "crio_lxc_operation_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", command, m.Count)
+		fmt.Fprintf(&b, "crio_lxc_operation_duration_seconds_sum{command=%q} %g\n", command, m.Sum)
+		fmt.Fprintf(&b, "crio_lxc_operation_duration_seconds_count{command=%q} %d\n", command, m.Count)
+	}
+
+	writeContainerMetrics(&b)
+	return b.Bytes()
+}
+
+// writeContainerMetrics adds a snapshot of the resource usage of all
+// running containers. It reads the metadata and cgroups of the containers
+// without locking them or asking their lxc monitors.
+func writeContainerMetrics(b *bytes.Buffer) {
+	b.WriteString("# HELP crio_lxc_container_memory_usage_bytes Memory usage of running containers.\n")
+	b.WriteString("# TYPE crio_lxc_container_memory_usage_bytes gauge\n")
+	b.WriteString("# HELP crio_lxc_container_cpu_seconds CPU time used by running containers.\n")
+	b.WriteString("# TYPE crio_lxc_container_cpu_seconds gauge\n")
+	ids, err := listContainerIDs()
+	if err != nil {
+		log.Debugf("failed to list containers for metrics: %v", err)
+		return
+	}
+	for _, id := range ids {
+		md, err := readMetadata(id)
+		if err != nil || md.InitPid <= 0 || !pidAlive(md.InitPid, md.InitStartTime) {
+			continue
+		}
+		stats, err := readContainerStats(md.InitPid)
+		if err != nil {
+			continue
+		}
+		if stats.MemoryBytes > 0 {
+			fmt.Fprintf(b, "crio_lxc_container_memory_usage_bytes{id=%q} %d\n", id, stats.MemoryBytes)
+		}
+		if stats.CPUUsageNsec > 0 {
+			fmt.Fprintf(b, "crio_lxc_container_cpu_seconds{id=%q} %g\n", id, float64(stats.CPUUsageNsec)/1e9)
+		}
+	}
+}
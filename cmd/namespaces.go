@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// getNamespace returns the spec's entry for the namespace type, or nil if
+// the container should share the runtime's namespace of that type.
+func getNamespace(spec *specs.Spec, nsType specs.LinuxNamespaceType) *specs.LinuxNamespace {
+	if spec.Linux == nil {
+		return nil
+	}
+	for i := range spec.Linux.Namespaces {
+		if spec.Linux.Namespaces[i].Type == nsType {
+			return &spec.Linux.Namespaces[i]
+		}
+	}
+	return nil
+}
+
+// readBundleDomainname reads the domainname from the bundle config.
+// The field is newer than the vendored runtime-spec, so specs.Spec
+// doesn't carry it.
+func readBundleDomainname(bundle string) (string, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var spec struct {
+		Domainname string `json:"domainname,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return "", err
+	}
+	return spec.Domainname, nil
+}
+
+// configureUTS sets hostname and domainname only when the container gets a
+// new UTS namespace. When the namespace is shared, setting them would fail
+// or change the names of the sandbox or host.
+func configureUTS(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	domainname, err := readBundleDomainname(ctx.String("bundle"))
+	if err != nil {
+		return errors.Wrap(err, "failed to read domainname")
+	}
+
+	ns := getNamespace(spec, specs.UTSNamespace)
+	if ns == nil {
+		if err := cfg.Set("lxc.namespace.keep", "uts"); err != nil {
+			return errors.Wrap(err, "failed to keep host UTS namespace")
+		}
+		log.Debugf("sharing host UTS namespace, ignoring hostname %q and domainname %q", spec.Hostname, domainname)
+		return nil
+	}
+	if ns.Path != "" {
+		if err := cfg.Set("lxc.namespace.share.uts", ns.Path); err != nil {
+			return errors.Wrapf(err, "failed to share UTS namespace '%s'", ns.Path)
+		}
+		log.Debugf("joining UTS namespace %s, ignoring hostname %q and domainname %q", ns.Path, spec.Hostname, domainname)
+		return nil
+	}
+
+	if spec.Hostname != "" {
+		if err := cfg.Set("lxc.uts.name", spec.Hostname); err != nil {
+			return errors.Wrap(err, "failed to set hostname")
+		}
+	}
+	// lxc has no domainname key, but the sysctl is per UTS namespace.
+	if domainname != "" {
+		if err := cfg.Set("lxc.sysctl.kernel.domainname", domainname); err != nil {
+			return errors.Wrap(err, "failed to set domainname")
+		}
+	}
+	return nil
+}
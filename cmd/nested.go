@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"golang.org/x/sys/unix"
+)
+
+// capSysAdmin is the bit of CAP_SYS_ADMIN in the capability sets.
+const capSysAdmin = 21
+
+// inUserNamespace reports whether the runtime is running inside a user
+// namespace other than the initial one, e.g. in an unprivileged LXD
+// container or a CI sandbox.
+func inUserNamespace() bool {
+	f, err := os.Open("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return false
+	}
+	// the initial user namespace maps the full uid range onto itself
+	fields := strings.Fields(scanner.Text())
+	return len(fields) != 3 || fields[0] != "0" || fields[1] != "0" || fields[2] != "4294967295"
+}
+
+// ownCgroup returns the unified (or first) cgroup path of the runtime.
+func ownCgroup() string {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "/"
+	}
+	defer f.Close()
+
+	cgroup := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" || cgroup == "" {
+			cgroup = parts[2]
+		}
+	}
+	if cgroup == "" {
+		return "/"
+	}
+	return cgroup
+}
+
+// cgroupDelegated reports whether the runtime may create cgroups below the
+// cgroup tree root. Inside a container that is usually only true for the
+// subtree delegated to it.
+func cgroupDelegated() bool {
+	return unix.Access("/sys/fs/cgroup", unix.W_OK) == nil
+}
+
+// effectiveCapability reports whether the runtime has the capability in
+// its effective set.
+func effectiveCapability(bit uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "CapEff:"); value != scanner.Text() {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return err == nil && caps&(1<<bit) != 0
+		}
+	}
+	return false
+}
+
+// hostSysAdmin reports whether the runtime has CAP_SYS_ADMIN in the
+// initial user namespace. Nested runtimes don't, and skip what needs it:
+// programming the devices cgroup, the realtime IO class, adding devices to
+// running containers, checkpoint and restore.
+func hostSysAdmin() bool {
+	return !inUserNamespace() && effectiveCapability(capSysAdmin)
+}
+
+// allowDevice allows a device in the container's devices cgroup. Without
+// CAP_SYS_ADMIN on the host the devices cgroup can't be changed, the
+// rules of the outer container apply to the container.
+func allowDevice(cfg *lxcConfig, rule string) error {
+	if !hostSysAdmin() {
+		log.Debugf("not allowing device '%s' without CAP_SYS_ADMIN on the host", rule)
+		return nil
+	}
+	return cfg.Set(cgroupKey("devices.allow"), rule)
+}
+
+// isNested reports whether the runtime is itself running in an
+// unprivileged container.
+func isNested() bool {
+	return inUserNamespace()
+}
+
+// nestedLimitations lists the features that don't work, or work differently,
+// when running nested.
+func nestedLimitations() []string {
+	limits := []string{
+		"apparmor profiles cannot be loaded, containers keep the runtime's profile",
+		"devices cgroup rules are not set, device access is limited to what the outer container allows",
+		"the realtime IO priority class is not set",
+		"checkpoint, restore and device-add are unavailable",
+	}
+	if relativeCgroups() {
+		limits = append([]string{"container cgroups are created relative to " + ownCgroup()}, limits...)
+	}
+	if !cgroupDelegated() {
+		limits = append(limits, "no writable cgroup tree, resource limits are not applied")
+	}
+	return limits
+}
+
+func checkNested() (string, error) {
+	if !isNested() {
+		return "not nested", nil
+	}
+	return "", fmt.Errorf("running in a user namespace, degraded: %s", strings.Join(nestedLimitations(), "; "))
+}
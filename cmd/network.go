@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// wantsOwnNetwork reports whether the container gets a new network
+// namespace that nobody else configures.
+func wantsOwnNetwork(spec *specs.Spec) bool {
+	ns := getNamespace(spec, specs.NetworkNamespace)
+	return ns != nil && ns.Path == ""
+}
+
+// configureNetwork shares or keeps the network namespace like
+// configureUTS. A new namespace without a path is what cri-o never asks
+// for, but standalone users do: it only gets a loopback device, unless
+// --network-bridge is given, in which case a veth pair is attached to the
+// bridge.
+func configureNetwork(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	ns := getNamespace(spec, specs.NetworkNamespace)
+	if ns == nil {
+		return cfg.Set("lxc.namespace.keep", "net")
+	}
+	if ns.Path != "" {
+		if err := cfg.Set("lxc.namespace.share.net", ns.Path); err != nil {
+			return errors.Wrapf(err, "failed to share network namespace '%s'", ns.Path)
+		}
+		return nil
+	}
+
+	bridge := ctx.String("network-bridge")
+	if ctx.String("cni-network") != "" {
+		if bridge != "" {
+			return fmt.Errorf("--network-bridge and --cni-network are mutually exclusive")
+		}
+		// the CNI plugins add the interfaces after the container started
+		return cfg.Set("lxc.net.0.type", "empty")
+	}
+	if bridge == "" {
+		return cfg.Set("lxc.net.0.type", "empty")
+	}
+	if _, err := net.InterfaceByName(bridge); err != nil {
+		return errors.Wrapf(err, "failed to find bridge '%s'", bridge)
+	}
+
+	items := []lxcConfigItem{
+		{"lxc.net.0.type", "veth"},
+		{"lxc.net.0.link", bridge},
+		{"lxc.net.0.name", "eth0"},
+		{"lxc.net.0.flags", "up"},
+	}
+
+	// Without an address the container is expected to use DHCP, e.g.
+	// from the dnsmasq on lxcbr0.
+	if address := ctx.String("network-address"); address != "" {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return errors.Wrapf(err, "invalid network address '%s'", address)
+		}
+		family := "ipv4"
+		if ip.To4() == nil {
+			family = "ipv6"
+		}
+		items = append(items, lxcConfigItem{"lxc.net.0." + family + ".address", address})
+
+		if gateway := ctx.String("network-gateway"); gateway != "" {
+			gw := net.ParseIP(gateway)
+			if gw == nil {
+				return fmt.Errorf("invalid network gateway '%s'", gateway)
+			}
+			if (gw.To4() == nil) != (ip.To4() == nil) {
+				return fmt.Errorf("network gateway '%s' and address '%s' are of different families", gateway, address)
+			}
+			items = append(items, lxcConfigItem{"lxc.net.0." + family + ".gateway", gateway})
+		}
+	}
+
+	for _, item := range items {
+		if err := cfg.Set(item.Key, item.Value); err != nil {
+			return errors.Wrapf(err, "failed to set %s", item.Key)
+		}
+	}
+	log.Debugf("attaching container to bridge %s", bridge)
+	return nil
+}
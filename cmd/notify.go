@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	notifyDirInContainer  = "/run/notify"
+	notifySocketName      = "notify.sock"
+	notifyProxyBufferSize = 4096
+)
+
+// notifyProxyCmd is spawned by create when NOTIFY_SOCKET is set. It
+// forwards sd_notify datagrams sent to the socket inside the container
+// to the host's NOTIFY_SOCKET.
+var notifyProxyCmd = cli.Command{
+	Name:      "notify-proxy",
+	Usage:     "internal: forward sd_notify messages from a container",
+	ArgsUsage: "<host notify socket>",
+	Hidden:    true,
+	Action:    doNotifyProxy,
+}
+
+func notifyDir(containerID string) string {
+	return runtimePath(containerID, notifyDirName)
+}
+
+// configureNotifySocket bind mounts the directory holding the proxy socket
+// into the container and points NOTIFY_SOCKET at it.
+func configureNotifySocket(cfg *lxcConfig, containerID string) error {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil
+	}
+	mnt := fmt.Sprintf("%s %s none bind,create=dir 0 0", notifyDir(containerID), notifyDirInContainer[1:])
+	if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
+		return errors.Wrap(err, "failed to set notify socket mount config entry")
+	}
+	envVar := "NOTIFY_SOCKET=" + filepath.Join(notifyDirInContainer, notifySocketName)
+	if err := cfg.Set("lxc.environment", envVar); err != nil {
+		return errors.Wrap(err, "failed to set NOTIFY_SOCKET in container environment")
+	}
+	return nil
+}
+
+// startNotifyProxy binds the proxy socket and hands it to a detached
+// notify-proxy process, returning its pid (0 if NOTIFY_SOCKET isn't set).
+// The socket is bound here so that it exists before the container init
+// runs.
+func startNotifyProxy(containerID string) (int, error) {
+	hostSocket := os.Getenv("NOTIFY_SOCKET")
+	if hostSocket == "" {
+		return 0, nil
+	}
+
+	dir := notifyDir(containerID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.Wrap(err, "failed to create notify socket dir")
+	}
+	socketPath := filepath.Join(dir, notifySocketName)
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrap(err, "failed to remove stale notify socket")
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to bind notify socket '%s'", socketPath)
+	}
+	defer conn.Close()
+	// processes in the container may run as any user
+	if err := os.Chmod(socketPath, 0777); err != nil {
+		return 0, errors.Wrap(err, "failed to chmod notify socket")
+	}
+
+	f, err := conn.File()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get notify socket fd")
+	}
+	defer f.Close()
+
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(binary, "notify-proxy", hostSocket)
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "failed to start notify proxy")
+	}
+	log.Debugf("started notify proxy pid %d for %s", cmd.Process.Pid, hostSocket)
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+func doNotifyProxy(ctx *cli.Context) error {
+	hostSocket := ctx.Args().Get(0)
+	if len(hostSocket) == 0 {
+		return fmt.Errorf("missing host notify socket")
+	}
+
+	f := os.NewFile(3, "notify-socket")
+	c, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to use inherited notify socket")
+	}
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("inherited notify socket is not a unix socket")
+	}
+	defer conn.Close()
+
+	dst := &net.UnixAddr{Name: hostSocket, Net: "unixgram"}
+	out, err := net.DialUnix("unixgram", nil, dst)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to '%s'", hostSocket)
+	}
+	defer out.Close()
+
+	buf := make([]byte, notifyProxyBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return errors.Wrap(err, "failed to read from notify socket")
+		}
+		if _, err := out.Write(buf[:n]); err != nil {
+			return errors.Wrap(err, "failed to forward notify message")
+		}
+	}
+}
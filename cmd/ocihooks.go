@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// OCI hooks come from the spec and from hook dirs in the hooks.d format of
+// cri-o and podman. create stores the container's hooks in its runtime dir
+// and adds lxc hooks running the oci-hook command for the stages:
+//
+//   prestart, createRuntime  lxc start-host hook, in the host's namespaces
+//   createContainer          lxc mount hook, before the rootfs is pivoted to
+//   poststart                run by start, after the init was released
+//   poststop                 lxc post-stop hook
+//
+// startContainer hooks would have to run in the container, where the
+// runtime isn't, and are rejected.
+
+// defaultOCIHookDirs are used without --hooks-dir, later dirs override
+// hooks of the same file name in earlier ones.
+var defaultOCIHookDirs = []string{
+	"/usr/share/containers/oci/hooks.d",
+	"/etc/containers/oci/hooks.d",
+}
+
+// ociHookStages are in the order they run in.
+var ociHookStages = []string{
+	"prestart",
+	"createRuntime",
+	"createContainer",
+	"startContainer",
+	"poststart",
+	"poststop",
+}
+
+// ociHookLXCTypes are the lxc hooks that run the stages.
+var ociHookLXCTypes = map[string][]string{
+	"start-host": {"prestart", "createRuntime"},
+	"mount":      {"createContainer"},
+	"post-stop":  {"poststop"},
+}
+
+// ociHooks are keyed by stage.
+type ociHooks map[string][]specs.Hook
+
+// hookConfig is a hooks.d file, version 1.0.0.
+type hookConfig struct {
+	Version string     `json:"version"`
+	Hook    specs.Hook `json:"hook"`
+	When    hookWhen   `json:"when"`
+	Stages  []string   `json:"stages"`
+}
+
+// hookWhen matches a container if any of its conditions does.
+type hookWhen struct {
+	Always        bool              `json:"always,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Commands      []string          `json:"commands,omitempty"`
+	HasBindMounts bool              `json:"hasBindMounts,omitempty"`
+}
+
+func isOCIHookStage(stage string) bool {
+	for _, s := range ociHookStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hookConfig) validate() error {
+	if h.Version != "1.0.0" {
+		return fmt.Errorf("unsupported version %q", h.Version)
+	}
+	if !filepath.IsAbs(h.Hook.Path) {
+		return fmt.Errorf("hook path %q is not absolute", h.Hook.Path)
+	}
+	if len(h.Stages) == 0 {
+		return fmt.Errorf("no stages")
+	}
+	for _, stage := range h.Stages {
+		if !isOCIHookStage(stage) {
+			return fmt.Errorf("unknown stage %q", stage)
+		}
+	}
+	return nil
+}
+
+// compileHookPattern compiles a pattern of a hookWhen, which matches the
+// whole string as in cri-o and podman.
+func compileHookPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+func (w *hookWhen) matches(spec *specs.Spec) (bool, error) {
+	if w.Always {
+		return true, nil
+	}
+	if w.HasBindMounts {
+		for _, m := range spec.Mounts {
+			if isBindMount(m) {
+				return true, nil
+			}
+		}
+	}
+	for keyPattern, valuePattern := range w.Annotations {
+		keyRe, err := compileHookPattern(keyPattern)
+		if err != nil {
+			return false, err
+		}
+		valueRe, err := compileHookPattern(valuePattern)
+		if err != nil {
+			return false, err
+		}
+		for key, value := range spec.Annotations {
+			if keyRe.MatchString(key) && valueRe.MatchString(value) {
+				return true, nil
+			}
+		}
+	}
+	if spec.Process != nil && len(spec.Process.Args) > 0 {
+		for _, pattern := range w.Commands {
+			re, err := compileHookPattern(pattern)
+			if err != nil {
+				return false, err
+			}
+			if re.MatchString(spec.Process.Args[0]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// readHookDirs returns the hooks.d files of dirs by file name, missing
+// dirs are skipped.
+func readHookDirs(dirs []string) (map[string]*hookConfig, error) {
+	configs := map[string]*hookConfig{}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range entries {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			hc := &hookConfig{}
+			if err := json.Unmarshal(data, hc); err != nil {
+				return nil, errors.Wrapf(err, "invalid hook %s", path)
+			}
+			if err := hc.validate(); err != nil {
+				return nil, errors.Wrapf(err, "invalid hook %s", path)
+			}
+			configs[fi.Name()] = hc
+		}
+	}
+	return configs, nil
+}
+
+// readBundleHooks reads the hooks of the spec, including the stages added
+// after the runtime-spec version we build with.
+func readBundleHooks(bundle string) (ociHooks, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var spec struct {
+		Hooks ociHooks `json:"hooks,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, err
+	}
+	if spec.Hooks == nil {
+		return ociHooks{}, nil
+	}
+	return spec.Hooks, nil
+}
+
+// containerOCIHooks returns the hooks of the spec followed by the matching
+// ones of the hook dirs, in file name order.
+func containerOCIHooks(ctx *cli.Context, bundle string, spec *specs.Spec) (ociHooks, error) {
+	hooks, err := readBundleHooks(bundle)
+	if err != nil {
+		return nil, err
+	}
+	dirs := defaultOCIHookDirs
+	if ctx.GlobalIsSet("hooks-dir") {
+		dirs = ctx.GlobalStringSlice("hooks-dir")
+	}
+	configs, err := readHookDirs(dirs)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hc := configs[name]
+		match, err := hc.When.matches(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid hook %s", name)
+		}
+		if !match {
+			continue
+		}
+		log.Debugf("adding hook %s for stages %s", name, strings.Join(hc.Stages, ", "))
+		for _, stage := range hc.Stages {
+			hooks[stage] = append(hooks[stage], hc.Hook)
+		}
+	}
+
+	// lxc's nvidia mount hook sets up the GPUs the nvidia prestart hook
+	// requested, see configureGPUs, running it as well would do it twice
+	if requestedGPUs(spec) != "" {
+		for stage, stageHooks := range hooks {
+			kept := []specs.Hook{}
+			for _, hook := range stageHooks {
+				if isNvidiaHook(hook) {
+					log.Debugf("dropping %s hook %s, lxc sets up the GPUs", stage, hook.Path)
+					continue
+				}
+				kept = append(kept, hook)
+			}
+			hooks[stage] = kept
+		}
+	}
+
+	for stage, stageHooks := range hooks {
+		if !isOCIHookStage(stage) {
+			return nil, fmt.Errorf("unknown hook stage %q", stage)
+		}
+		if stage == "startContainer" && len(stageHooks) > 0 {
+			return nil, fmt.Errorf("startContainer hooks are not supported")
+		}
+	}
+	return hooks, nil
+}
+
+func ociHooksPath(containerID string) string {
+	return runtimePath(containerID, hooksDirName, ociHooksFileName)
+}
+
+func readOCIHooks(containerID string) (ociHooks, error) {
+	path := withLegacyPath(ociHooksPath(containerID), runtimePath(containerID, legacyOCIHookFile))
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ociHooks{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hooks := ociHooks{}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, errors.Wrap(err, "invalid oci hooks")
+	}
+	return hooks, nil
+}
+
+// configureOCIHooks stores the hooks and adds the lxc hooks running them.
+// It is not part of configureContainer, the hook dirs are read for every
+// container.
+func configureOCIHooks(cfg *lxcConfig, containerID string, hooks ociHooks) error {
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ociHooksPath(containerID)), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ociHooksPath(containerID), data, 0600); err != nil {
+		return err
+	}
+
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	lxcTypes := []string{}
+	for lxcType := range ociHookLXCTypes {
+		lxcTypes = append(lxcTypes, lxcType)
+	}
+	sort.Strings(lxcTypes)
+	for _, lxcType := range lxcTypes {
+		stages := ociHookLXCTypes[lxcType]
+		count := 0
+		for _, stage := range stages {
+			count += len(hooks[stage])
+		}
+		if count == 0 {
+			continue
+		}
+		value := fmt.Sprintf("%s --root %s --lxc-path %s oci-hook %s", binary, RUNTIME_ROOT, LXC_PATH, strings.Join(stages, " "))
+		if err := cfg.Set("lxc.hook."+lxcType, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ociHookState is the state passed to the hooks of a stage.
+func ociHookState(containerID string, stage string) (*specs.State, error) {
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return nil, err
+	}
+	state := &specs.State{
+		Version:     md.stateVersion(),
+		ID:          containerID,
+		Bundle:      md.Bundle,
+		Annotations: md.Annotations,
+		Pid:         md.InitPid,
+	}
+	switch stage {
+	case "poststart":
+		state.Status = "running"
+	case "poststop":
+		state.Status = "stopped"
+	default:
+		state.Status = "creating"
+	}
+	// liblxc passes the init pid to hooks in the host's namespaces
+	if pid, err := strconv.Atoi(os.Getenv("LXC_PID")); err == nil && pid > 0 {
+		state.Pid = pid
+	}
+	return state, nil
+}
+
+// runOCIHook runs a hook with the state on stdin, killing it after its
+// timeout.
+func runOCIHook(hook specs.Hook, state []byte) error {
+	cmd := exec.Command(hook.Path)
+	if len(hook.Args) > 0 {
+		cmd.Args = hook.Args
+	}
+	// never nil, a hook without env must not get the runtime's
+	cmd.Env = append([]string{}, hook.Env...)
+	cmd.Stdin = bytes.NewReader(state)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if hook.Timeout != nil && *hook.Timeout > 0 {
+		timer := time.AfterFunc(time.Duration(*hook.Timeout)*time.Second, func() {
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+	return cmd.Wait()
+}
+
+// runOCIHooks runs the hooks of the stages in order, stopping at the
+// first failure.
+func runOCIHooks(containerID string, stages ...string) error {
+	hooks, err := readOCIHooks(containerID)
+	if err != nil {
+		return err
+	}
+	for _, stage := range stages {
+		if len(hooks[stage]) == 0 {
+			continue
+		}
+		state, err := ociHookState(containerID, stage)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks[stage] {
+			if err := runOCIHook(hook, data); err != nil {
+				return errors.Wrapf(err, "%s hook %s failed", stage, hook.Path)
+			}
+		}
+	}
+	return nil
+}
+
+var ociHookCmd = cli.Command{
+	Name:      "oci-hook",
+	Usage:     "internal: run the oci hooks of a container's stages, as an lxc hook",
+	ArgsUsage: "<stage>...",
+	Hidden:    true,
+	Action:    doOCIHook,
+}
+
+func doOCIHook(ctx *cli.Context) error {
+	// liblxc sets LXC_NAME to the container ID
+	containerID := os.Getenv("LXC_NAME")
+	if containerID == "" {
+		return fmt.Errorf("LXC_NAME is not set, oci-hook must run as an lxc hook")
+	}
+	err := runOCIHooks(containerID, ctx.Args()...)
+	// Failing poststop hooks are only logged, the container is gone
+	// anyway.
+	if err != nil && ctx.Args().First() == "poststop" {
+		log.Warnf("%v", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestHookWhenMatches(t *testing.T) {
+	spec := &specs.Spec{
+		Process:     &specs.Process{Args: []string{"/usr/bin/nginx"}},
+		Annotations: map[string]string{"io.example.gpu": "enabled"},
+	}
+	tests := []struct {
+		when hookWhen
+		want bool
+	}{
+		{hookWhen{Commands: []string{"/usr/bin/nginx"}}, true},
+		{hookWhen{Commands: []string{".*/nginx"}}, true},
+		{hookWhen{Commands: []string{"nginx"}}, false},
+		{hookWhen{Commands: []string{"/usr/bin/ngin"}}, false},
+		{hookWhen{Commands: []string{"a|/usr/bin/nginx"}}, true},
+		{hookWhen{Annotations: map[string]string{"io.example.gpu": "enabled"}}, true},
+		{hookWhen{Annotations: map[string]string{"gpu": "enabled"}}, false},
+		{hookWhen{Annotations: map[string]string{"io.example.gpu": "enable"}}, false},
+	}
+	for _, tt := range tests {
+		got, err := tt.when.matches(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%+v matches = %v, want %v", tt.when, got, tt.want)
+		}
+	}
+}
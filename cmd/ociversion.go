@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Bundles are written for the runtime spec version in their ociVersion.
+// crio-lxc translates 1.0.x and 1.1.x bundles: 1.1 adds fields, which are
+// read separately where crio-lxc supports them (see specProcessExt) or
+// rejected where ignoring them would change the container (see
+// checkSeccompErrnoRet), and relaxes what 1.0 required, which
+// normalizeSpec undoes so that the translation sees a 1.0 spec. The 1.0 release candidates and any other
+// major or minor version aren't compatible. The state reports the
+// ociVersion of the bundle, not that of crio-lxc.
+
+type ociVersion struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseOCIVersion parses a semantic version, e.g. 1.0.2 or 1.1.0-rc.1.
+func parseOCIVersion(version string) (ociVersion, error) {
+	v := ociVersion{}
+	core := version
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		core = version[:i]
+		if version[i] == '-' {
+			v.prerelease = strings.SplitN(version[i+1:], "+", 2)[0]
+		}
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid ociVersion '%s'", version)
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid ociVersion '%s'", version)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// checkOCIVersion returns the version of the spec, if it is one crio-lxc
+// translates.
+func checkOCIVersion(version string) (ociVersion, error) {
+	if version == "" {
+		return ociVersion{}, fmt.Errorf("ociVersion is required")
+	}
+	v, err := parseOCIVersion(version)
+	if err != nil {
+		return v, err
+	}
+	if v.major != 1 || v.minor > 1 {
+		return v, fmt.Errorf("unsupported ociVersion '%s', expected 1.0.x or 1.1.x", version)
+	}
+	// the release candidates of 1.0.0 differ from it, those of later
+	// versions only add to it
+	if v.minor == 0 && v.patch == 0 && v.prerelease != "" {
+		return v, fmt.Errorf("unsupported ociVersion '%s', the 1.0.0 release candidates are incompatible with 1.0.0", version)
+	}
+	return v, nil
+}
+
+// normalizeSpec checks the ociVersion of the spec and changes what the
+// version relaxed to what 1.0 requires.
+func normalizeSpec(spec *specs.Spec) error {
+	v, err := checkOCIVersion(spec.Version)
+	if err != nil {
+		return err
+	}
+	if v.minor == 0 {
+		return nil
+	}
+
+	// 1.1 allows relative mount destinations, relative to the root
+	for i, ms := range spec.Mounts {
+		if !filepath.IsAbs(ms.Destination) {
+			log.Debugf("mount destination '%s' is relative to /", ms.Destination)
+			spec.Mounts[i].Destination = filepath.Join("/", ms.Destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// The OOM monitor is a helper process started by create, which watches
+// the container's memory cgroup until the container exits. OOM kills are
+// recorded as oom events and in the container metadata.
+
+var oomMonitorCmd = cli.Command{
+	Name:      "oom-monitor",
+	Usage:     "internal: record OOM kills of a container",
+	ArgsUsage: "<containerID>",
+	Hidden:    true,
+	Action:    doOOMMonitor,
+}
+
+// startOOMMonitor starts the monitor for the container, whose init must
+// be running. The monitor logs to the runtime log, or to its own log in
+// the runtime dir, since nobody reads its stderr.
+func startOOMMonitor(containerID string) (int, error) {
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return 0, err
+	}
+	logPath := logFile
+	if logPath == "" {
+		logPath = runtimePath(containerID, oomLogFileName)
+	}
+	cmd := exec.Command(binary, "--root", RUNTIME_ROOT, "--lxc-path", LXC_PATH, "--log", logPath, "oom-monitor", containerID)
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "failed to start oom monitor")
+	}
+	log.Debugf("started oom monitor pid %d", cmd.Process.Pid)
+	return cmd.Process.Pid, nil
+}
+
+// waitOOMv2 returns an fd that becomes readable when memory.events
+// changes.
+func waitOOMv2(dir string) (int, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, filepath.Join(dir, "memory.events"), unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// waitOOMv1 returns an eventfd that is signalled on OOM, registered with
+// cgroup.event_control.
+func waitOOMv1(dir string) (int, error) {
+	efd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		return -1, err
+	}
+	oomControl, err := os.Open(filepath.Join(dir, "memory.oom_control"))
+	if err != nil {
+		unix.Close(efd)
+		return -1, err
+	}
+	defer oomControl.Close()
+	registration := fmt.Sprintf("%d %d", efd, oomControl.Fd())
+	if err := writeCgroupFile(filepath.Join(dir, "cgroup.event_control"), registration); err != nil {
+		unix.Close(efd)
+		return -1, err
+	}
+	return efd, nil
+}
+
+func recordOOM(containerID string, kills uint64) {
+	log.Infof("container %s was OOM killed", containerID)
+	if err := appendEvent(containerID, "oom", map[string]uint64{"oomKills": kills}); err != nil {
+		log.Errorf("failed to record oom event: %v", err)
+	}
+	lock, err := waitLockContainer(containerID)
+	if err != nil {
+		log.Errorf("failed to record oom kill: %v", err)
+		return
+	}
+	defer lock.Close()
+	md, err := readMetadata(containerID)
+	if err != nil {
+		log.Errorf("failed to record oom kill: %v", err)
+		return
+	}
+	md.OOMKilled = true
+	if err := writeMetadata(containerID, md); err != nil {
+		log.Errorf("failed to record oom kill: %v", err)
+	}
+}
+
+func doOOMMonitor(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return err
+	}
+	if md.InitPid == 0 {
+		return fmt.Errorf("container init pid unknown")
+	}
+	cgroups, err := procCgroups(md.InitPid)
+	if err != nil {
+		return err
+	}
+	layout, err := cgroupLayout()
+	if err != nil {
+		return err
+	}
+
+	var fd int
+	var eventsFile string
+	if layout == "unified" {
+		dir := filepath.Join("/sys/fs/cgroup", cgroups[""])
+		eventsFile = filepath.Join(dir, "memory.events")
+		fd, err = waitOOMv2(dir)
+	} else {
+		dir := v1CgroupDir(cgroups, "memory")
+		if dir == "" {
+			return fmt.Errorf("no memory cgroup")
+		}
+		eventsFile = filepath.Join(dir, "memory.oom_control")
+		fd, err = waitOOMv1(dir)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to watch memory cgroup")
+	}
+	defer unix.Close(fd)
+
+	var seen uint64
+	if n, err := readCgroupStat(eventsFile, "oom_kill"); err == nil {
+		seen = n
+	}
+	buf := make([]byte, 4096)
+	for pidAlive(md.InitPid, md.InitStartTime) {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 1000)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := unix.Read(fd, buf); err != nil {
+			return err
+		}
+
+		// v1 eventfds are only signalled on OOM, the oom_kill count
+		// needs 4.13, older kernels report every OOM as a kill
+		kills, err := readCgroupStat(eventsFile, "oom_kill")
+		if err != nil {
+			if layout != "unified" {
+				recordOOM(containerID, seen+binary.LittleEndian.Uint64(buf[:8]))
+			}
+			continue
+		}
+		if kills > seen {
+			seen = kills
+			recordOOM(containerID, kills)
+		}
+	}
+	return nil
+}
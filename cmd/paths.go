@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// resolveBundle returns the absolute bundle path with symlinks resolved,
+// so that paths in the bundle are resolved against the directory that
+// is actually used.
+func resolveBundle(bundle string) (string, error) {
+	abs, err := filepath.Abs(bundle)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// resolveBundlePaths makes the paths of the spec that are relative to
+// the bundle absolute. They are resolved in the scope of the bundle, so
+// that a symlink in a malicious bundle can't point them elsewhere on
+// the host. Absolute paths are taken as they are, the spec allows them
+// to be anywhere.
+func resolveBundlePaths(bundle string, spec *specs.Spec) error {
+	if err := resolveRootfs(bundle, spec); err != nil {
+		return errors.Wrap(err, "invalid rootfs")
+	}
+	for i, ms := range spec.Mounts {
+		spec.Mounts[i].Destination = filepath.Clean(ms.Destination)
+		if !isBindMount(ms) || filepath.IsAbs(ms.Source) {
+			continue
+		}
+		source, err := securejoin.SecureJoin(bundle, ms.Source)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve mount source '%s'", ms.Source)
+		}
+		spec.Mounts[i].Source = source
+	}
+	return nil
+}
+
+func isBindMount(ms specs.Mount) bool {
+	if ms.Type == "bind" {
+		return true
+	}
+	for _, opt := range ms.Options {
+		if opt == "bind" || opt == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// bindCreatePlaceholder stands for the create option of a bind mount in
+// the translated config, until resolveBindCreate looks at the source. The
+// translation may run on a spec whose paths aren't the container's, see
+// translateContainer.
+const bindCreatePlaceholder = "{{crio-lxc-create}}"
+
+// bindCreateOption returns the placeholder of the lxc option that creates
+// the mount point of a bind mount, which lxc otherwise expects to exist in
+// the rootfs, or "" if the options have one already.
+func bindCreateOption(ms specs.Mount, options []string) string {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "create=") {
+			return ""
+		}
+	}
+	return bindCreatePlaceholder
+}
+
+// resolveBindCreate replaces the create option placeholders of the mount
+// entries: create=file for files like resolv.conf, hosts or service
+// account tokens, and create=dir for dirs. Mounts of missing sources get
+// none, they fail anyway.
+func resolveBindCreate(cfg *lxcConfig) {
+	for i, item := range cfg.items {
+		if item.Key != "lxc.mount.entry" || !strings.Contains(item.Value, bindCreatePlaceholder) {
+			continue
+		}
+		// source target type options
+		fields := strings.SplitN(item.Value, " ", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		create := ""
+		if fi, err := os.Stat(fields[0]); err == nil {
+			create = "create=file"
+			if fi.IsDir() {
+				create = "create=dir"
+			}
+		}
+		options := []string{}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if opt == bindCreatePlaceholder {
+				opt = create
+			}
+			if opt != "" {
+				options = append(options, opt)
+			}
+		}
+		fields[3] = strings.Join(options, ",")
+		cfg.items[i].Value = strings.Join(fields, " ")
+	}
+}
+
+// resolveRootfs makes spec.Root.Path absolute, since the spec allows it to
+// be relative to the bundle directory.
+func resolveRootfs(bundle string, spec *specs.Spec) error {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return fmt.Errorf("spec has no root path")
+	}
+	rootfs := spec.Root.Path
+	if !filepath.IsAbs(rootfs) {
+		var err error
+		rootfs, err = securejoin.SecureJoin(bundle, rootfs)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve rootfs '%s'", spec.Root.Path)
+		}
+	}
+	fi, err := os.Stat(rootfs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat rootfs '%s'", rootfs)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("rootfs '%s' is not a directory", rootfs)
+	}
+	spec.Root.Path = rootfs
+	return nil
+}
+
+// checkInside makes sure that path, once symlinks are resolved, is below
+// root, before the runtime writes configs, hooks or state there.
+func checkInside(root string, path string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve '%s'", root)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve '%s'", path)
+	}
+	if !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return fmt.Errorf("'%s' resolves to '%s', outside of '%s'", path, resolved, root)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/urfave/cli"
+)
+
+// liblxc has no switch to skip pivot_root. It switches the root with
+// chroot by itself when the host's root is the initramfs, which is where
+// pivot_root fails, so --no-pivot is only checked against that.
+
+// rootIsInitramfs reports whether / is the initramfs, the way liblxc
+// detects it.
+func rootIsInitramfs() (bool, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options... - fstype source superoptions
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[3] != "/" || fields[4] != "/" {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) {
+				return fields[i+1] == "rootfs", nil
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// checkNoPivot tells users of create --no-pivot whether it has an effect.
+func checkNoPivot(ctx *cli.Context) {
+	if !ctx.Bool("no-pivot") {
+		return
+	}
+	initramfs, err := rootIsInitramfs()
+	if err != nil {
+		log.Warnf("failed to check for an initramfs root: %v", err)
+		return
+	}
+	if initramfs {
+		log.Debugf("root is the initramfs, liblxc uses chroot instead of pivot_root")
+		return
+	}
+	log.Warnf("--no-pivot has no effect, liblxc only skips pivot_root when / is the initramfs")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Privileged containers, e.g. the system pods of CNI and storage plugins,
+// get all capabilities, no seccomp or apparmor profile and all host
+// devices. Applying the runtime's own restrictions (the default seccomp
+// profile, liblxc's apparmor profile) to them breaks them, so they are
+// configured without any: no capabilities are dropped, the devices cgroup
+// allows everything and the spec's devices are bound from the host.
+
+// isPrivileged is whether the spec is of a privileged container, which
+// cri-o doesn't mark other than by what it puts into the spec.
+func isPrivileged(spec *specs.Spec) bool {
+	if spec.Process.Capabilities == nil || spec.Linux == nil || spec.Linux.Seccomp != nil {
+		return false
+	}
+	if profile := spec.Process.ApparmorProfile; profile != "" && profile != "unconfined" {
+		return false
+	}
+
+	bounding := map[string]bool{}
+	for _, c := range spec.Process.Capabilities.Bounding {
+		name := strings.ToUpper(c)
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		bounding[name] = true
+	}
+	for _, c := range knownCapabilities {
+		if !bounding[c] {
+			return false
+		}
+	}
+
+	if spec.Linux.Resources == nil {
+		return false
+	}
+	for _, rule := range spec.Linux.Resources.Devices {
+		allType := rule.Type == "" || rule.Type == "a"
+		allAccess := strings.Contains(rule.Access, "r") && strings.Contains(rule.Access, "w") && strings.Contains(rule.Access, "m")
+		if rule.Allow && allType && rule.Major == nil && rule.Minor == nil && allAccess {
+			return true
+		}
+	}
+	return false
+}
+
+func configurePrivileged(cfg *lxcConfig, spec *specs.Spec) error {
+	if appArmorEnabled() {
+		// a confined runtime can't start unconfined containers
+		profile := "unchanged"
+		if currentAppArmorProfile() == "unconfined" {
+			profile = "unconfined"
+		}
+		if err := cfg.Set("lxc.apparmor.profile", profile); err != nil {
+			return errors.Wrap(err, "failed to set apparmor profile")
+		}
+	}
+
+	if err := allowDevice(cfg, "a"); err != nil {
+		return errors.Wrap(err, "failed to allow all devices")
+	}
+
+	// after the spec's mounts, which include /dev
+	for _, dev := range spec.Linux.Devices {
+		var st unix.Stat_t
+		if err := unix.Stat(dev.Path, &st); err != nil {
+			log.Debugf("not binding device %s: %v", dev.Path, err)
+			continue
+		}
+		if !hostDeviceMatches(dev, &st) {
+			log.Debugf("not binding device %s, the host's is a different device", dev.Path)
+			continue
+		}
+		mnt := fmt.Sprintf("%s %s none bind,create=file 0 0", dev.Path, strings.TrimPrefix(dev.Path, "/"))
+		if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
+			return errors.Wrapf(err, "failed to bind device %s", dev.Path)
+		}
+	}
+	return nil
+}
+
+// hostDeviceMatches is whether the host's node is the device of the spec.
+func hostDeviceMatches(dev specs.LinuxDevice, st *unix.Stat_t) bool {
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFIFO:
+		return dev.Type == "p"
+	case unix.S_IFCHR:
+		if dev.Type != "c" && dev.Type != "u" {
+			return false
+		}
+	case unix.S_IFBLK:
+		if dev.Type != "b" {
+			return false
+		}
+	default:
+		return false
+	}
+	rdev := uint64(st.Rdev)
+	return int64(unix.Major(rdev)) == dev.Major && int64(unix.Minor(rdev)) == dev.Minor
+}
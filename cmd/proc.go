@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// Proc hardening modes, for --proc-hardening and ANNOTATION_PROC_HARDENING.
+const (
+	procHardeningOff = "off"
+	// hidepid hides the processes of other users in the container
+	procHardeningHidepid = "hidepid"
+	// subset additionally hides everything but the pid directories,
+	// where the kernel supports it (5.8+), and falls back to hidepid
+	procHardeningSubset = "subset"
+)
+
+// kernelAtLeast compares the running kernel's release with major.minor.
+func kernelAtLeast(major, minor int) bool {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	var kmajor, kminor int
+	release := string(uts.Release[:])
+	if _, err := fmt.Sscanf(release, "%d.%d", &kmajor, &kminor); err != nil {
+		return false
+	}
+	return kmajor > major || (kmajor == major && kminor >= minor)
+}
+
+// procMountOptions returns the options of the container's /proc mount
+// with the hardening options added. Without a pid namespace of its own
+// the container shares procfs with the host, where (before 5.8) hidepid
+// applies to every mount of it, so nothing is added then.
+func procMountOptions(ctx *cli.Context, spec *specs.Spec, options []string) ([]string, error) {
+	mode := ctx.GlobalString("proc-hardening")
+	if value, ok := spec.Annotations[ANNOTATION_PROC_HARDENING]; ok {
+		mode = value
+	}
+
+	var hardening []string
+	switch mode {
+	case "", procHardeningOff:
+	case procHardeningHidepid:
+		hardening = []string{"hidepid=2"}
+	case procHardeningSubset:
+		if kernelAtLeast(5, 8) {
+			hardening = []string{"hidepid=invisible", "subset=pid"}
+		} else {
+			hardening = []string{"hidepid=2"}
+		}
+	default:
+		return nil, fmt.Errorf("invalid proc hardening mode '%s'", mode)
+	}
+	if len(hardening) > 0 && getNamespace(spec, specs.PIDNamespace) == nil {
+		log.Warnf("not applying proc hardening '%s', the container has no pid namespace", mode)
+		hardening = nil
+	}
+
+	// never append to the caller's slice, it may be the spec's
+	result := make([]string, 0, len(options)+len(hardening))
+	result = append(result, options...)
+	return append(result, hardening...), nil
+}
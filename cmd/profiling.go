@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var profilingFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "cpu-profile",
+		Usage:  "write a CPU profile of the invocation to this file",
+		Hidden: true,
+	},
+	cli.StringFlag{
+		Name:   "mem-profile",
+		Usage:  "write a heap profile to this file when the invocation ends",
+		Hidden: true,
+	},
+	cli.StringFlag{
+		Name:   "pprof-address",
+		Usage:  "serve net/http/pprof on this address, for long running commands like events and attach",
+		Hidden: true,
+	},
+}
+
+var (
+	cpuProfile *os.File
+	memProfile = ""
+)
+
+// startProfiling starts what the profiling flags ask for, it is called
+// with the app's context. Profiles are written by stopProfiling.
+func startProfiling(ctx *cli.Context) error {
+	if path := ctx.String("cpu-profile"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to create CPU profile")
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return errors.Wrap(err, "failed to start CPU profile")
+		}
+		cpuProfile = f
+	}
+	memProfile = ctx.String("mem-profile")
+
+	if addr := ctx.String("pprof-address"); addr != "" {
+		go func() {
+			// only the pprof handlers are registered on the default mux
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				log.Errorf("pprof listener failed: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func stopProfiling() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+	}
+	if memProfile != "" {
+		f, err := os.Create(memProfile)
+		if err != nil {
+			log.Errorf("failed to create heap profile: %v", err)
+			return
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Errorf("failed to write heap profile: %v", err)
+		}
+	}
+}
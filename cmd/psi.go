@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// psiResources are the resources with pressure stall information in a
+// cgroup v2 directory.
+var psiResources = []string{"cpu", "memory", "io"}
+
+// psiLine is the "some" or "full" line of a pressure file. Averages are
+// the share of wall time stalled in percent, total is in microseconds.
+type psiLine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+type psiStats struct {
+	Some psiLine  `json:"some"`
+	Full *psiLine `json:"full,omitempty"`
+}
+
+// readPressure parses a <resource>.pressure file. cpu.pressure has no
+// "full" line before linux 5.13.
+func readPressure(path string) (*psiStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := &psiStats{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		line := psiLine{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("bad pressure field %q in %s", field, path)
+			}
+			switch kv[0] {
+			case "avg10":
+				line.Avg10, err = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				line.Avg60, err = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				line.Avg300, err = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				line.Total, err = strconv.ParseUint(kv[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bad pressure field %q in %s", field, path)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			stats.Some = line
+		case "full":
+			stats.Full = &line
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// readCgroupPressure reads the pressure of all resources in a cgroup v2
+// directory, leaving out the ones that aren't available (no PSI support
+// in the kernel, or psi=0).
+func readCgroupPressure(dir string) map[string]*psiStats {
+	pressure := map[string]*psiStats{}
+	for _, resource := range psiResources {
+		stats, err := readPressure(filepath.Join(dir, resource+".pressure"))
+		if err == nil {
+			pressure[resource] = stats
+		}
+	}
+	if len(pressure) == 0 {
+		return nil
+	}
+	return pressure
+}
+
+// parsePressureThresholds parses RESOURCE=PERCENT thresholds for the
+// "some" avg10 of the resource.
+func parsePressureThresholds(values []string) (map[string]float64, error) {
+	thresholds := map[string]float64{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad pressure threshold %q, expected RESOURCE=PERCENT", value)
+		}
+		known := false
+		for _, resource := range psiResources {
+			known = known || resource == kv[0]
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown pressure resource %q", kv[0])
+		}
+		percent, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("bad pressure threshold %q, expected a percentage", value)
+		}
+		thresholds[kv[0]] = percent
+	}
+	return thresholds, nil
+}
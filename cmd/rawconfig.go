@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+// rawConfigSafe are the lxc config keys, or prefixes ending in a dot, that
+// --raw-config-allow can open to annotations at all. They only change the
+// container itself, any other key is refused, also those lxc adds later.
+var rawConfigSafe = []string{
+	"lxc.prlimit.",
+	"lxc.environment",
+	"lxc.cgroup.",
+	"lxc.cgroup2.",
+	"lxc.proc.",
+	"lxc.signal.",
+	"lxc.tty.max",
+	"lxc.pty.max",
+	"lxc.console.size",
+	"lxc.console.buffer.size",
+	"lxc.keyring.session",
+	"lxc.init.cwd",
+}
+
+// rawConfigDenied are the lxc config keys, or prefixes ending in a dot,
+// that annotations can't set whatever --raw-config-allow says: mounts and
+// device access reach the host, sysctls set in the container's namespaces
+// are only a step from the host's, hooks and includes run or read host
+// files, the rootfs, idmap and namespaces decide what the container is
+// isolated from, the security profiles and capabilities are set from the
+// spec, and the log files are written on the host. Keys below a safe
+// prefix, e.g. devices below lxc.cgroup., are caught here.
+var rawConfigDenied = []string{
+	"lxc.mount.",
+	"lxc.cgroup.devices.",
+	"lxc.cgroup2.devices.",
+	"lxc.sysctl.",
+	"lxc.hook.",
+	"lxc.include",
+	"lxc.rootfs.",
+	"lxc.apparmor.",
+	"lxc.seccomp.",
+	"lxc.cap.",
+	"lxc.idmap",
+	"lxc.namespace.",
+	"lxc.log.file",
+	"lxc.console.logfile",
+}
+
+// matchConfigKey reports whether key is one of keys, where keys ending in
+// a dot match all keys below them.
+func matchConfigKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawConfigAllowed reports whether an annotation may set key. Nothing is
+// allowed unless the operator opts keys in with --raw-config-allow, and
+// only safe keys can be opted in.
+func rawConfigAllowed(key string, allow []string) bool {
+	return matchConfigKey(key, allow) && matchConfigKey(key, rawConfigSafe) && !matchConfigKey(key, rawConfigDenied)
+}
+
+// configureRawConfig appends the config items of the spec's
+// LXC_CONFIG_ANNOTATION_PREFIX annotations, in key order. This runs after
+// everything else, so that they take precedence over the translated
+// config.
+func configureRawConfig(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	allow := ctx.GlobalStringSlice("raw-config-allow")
+
+	keys := []string{}
+	for annotation := range spec.Annotations {
+		if strings.HasPrefix(annotation, LXC_CONFIG_ANNOTATION_PREFIX) {
+			keys = append(keys, annotation)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, annotation := range keys {
+		key := strings.TrimPrefix(annotation, LXC_CONFIG_ANNOTATION_PREFIX)
+		if !strings.HasPrefix(key, "lxc.") {
+			key = "lxc." + key
+		}
+		if !rawConfigAllowed(key, allow) {
+			return fmt.Errorf("lxc config key %s of annotation %s is not allowed", key, annotation)
+		}
+		if err := cfg.Set(key, spec.Annotations[annotation]); err != nil {
+			return fmt.Errorf("invalid annotation %s: %v", annotation, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestRawConfigAllowed(t *testing.T) {
+	tests := []struct {
+		key   string
+		allow []string
+		want  bool
+	}{
+		{"lxc.prlimit.nofile", nil, false},
+		{"lxc.prlimit.nofile", []string{"lxc.prlimit.nofile"}, true},
+		{"lxc.prlimit.nofile", []string{"lxc.prlimit."}, true},
+		{"lxc.prlimit.nofile", []string{"lxc.prlimit"}, false},
+		{"lxc.environment", []string{"lxc."}, true},
+		{"lxc.mount.entry", []string{"lxc."}, false},
+		{"lxc.mount.auto", []string{"lxc.mount.auto"}, false},
+		{"lxc.mount.fstab", []string{"lxc.mount."}, false},
+		{"lxc.cgroup.devices.allow", []string{"lxc.cgroup."}, false},
+		{"lxc.cgroup2.devices.allow", []string{"lxc.cgroup2.devices.allow"}, false},
+		{"lxc.cgroup2.memory.max", []string{"lxc.cgroup2."}, true},
+		{"lxc.sysctl.net.ipv4.ip_forward", []string{"lxc.sysctl."}, false},
+		{"lxc.hook.pre-start", []string{"lxc."}, false},
+		{"lxc.include", []string{"lxc."}, false},
+		{"lxc.rootfs.path", []string{"lxc."}, false},
+		{"lxc.apparmor.profile", []string{"lxc."}, false},
+		{"lxc.seccomp.profile", []string{"lxc."}, false},
+		{"lxc.cap.drop", []string{"lxc."}, false},
+		{"lxc.idmap", []string{"lxc."}, false},
+		{"lxc.namespace.share.net", []string{"lxc."}, false},
+		{"lxc.log.file", []string{"lxc."}, false},
+		{"lxc.console.logfile", []string{"lxc."}, false},
+		{"lxc.console.size", []string{"lxc."}, true},
+		{"lxc.net.0.link", []string{"lxc."}, false},
+		{"lxc.unknown.key", []string{"lxc.unknown.key"}, false},
+	}
+	for _, tt := range tests {
+		if got := rawConfigAllowed(tt.key, tt.allow); got != tt.want {
+			t.Errorf("rawConfigAllowed(%q, %q) = %v, want %v", tt.key, tt.allow, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// The realtime budget of a cgroup can't exceed its parent's, and new
+// cgroups start with none, so the cgroups lxc creates the container's
+// cgroup in need a budget before the container can get one. Each
+// container adds its runtime to them and takes it back on delete, the
+// budgets it added are recorded in its metadata.
+
+// realtimeResources returns the spec's realtime runtime and period, 0 if
+// unset.
+func realtimeResources(spec *specs.Spec) (int64, uint64) {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.CPU == nil {
+		return 0, 0
+	}
+	cpu := spec.Linux.Resources.CPU
+	var runtime int64
+	var period uint64
+	if cpu.RealtimeRuntime != nil {
+		runtime = *cpu.RealtimeRuntime
+	}
+	if cpu.RealtimePeriod != nil {
+		period = *cpu.RealtimePeriod
+	}
+	return runtime, period
+}
+
+func configureRealtime(cfg *lxcConfig, spec *specs.Spec) error {
+	runtime, period := realtimeResources(spec)
+	if runtime == 0 && period == 0 {
+		return nil
+	}
+	if layout, err := cgroupLayout(); err == nil && layout == "unified" {
+		return fmt.Errorf("realtime cpu scheduling needs the cgroup v1 cpu controller")
+	}
+	// the period has to be set first, the runtime must fit into it
+	if period != 0 {
+		if err := cfg.Set(cgroupKey("cpu.rt_period_us"), strconv.FormatUint(period, 10)); err != nil {
+			return errors.Wrap(err, "failed to set cpu.rt_period_us")
+		}
+	}
+	if runtime != 0 {
+		if err := cfg.Set(cgroupKey("cpu.rt_runtime_us"), strconv.FormatInt(runtime, 10)); err != nil {
+			return errors.Wrap(err, "failed to set cpu.rt_runtime_us")
+		}
+	}
+	return nil
+}
+
+// realtimeParentDirs returns the cpu cgroup directories above the
+// container's cgroup that lxc will create it in, top down. cgroupDir is
+// the container's cgroup from the spec, if any.
+func realtimeParentDirs(cgroupDir string) ([]string, error) {
+	cgroups, err := procCgroups(os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	cpuDir := v1CgroupDir(cgroups, "cpu")
+	if cpuDir == "" {
+		return nil, fmt.Errorf("no cgroup v1 cpu controller")
+	}
+	// the hierarchy root, e.g. /sys/fs/cgroup/cpu,cpuacct
+	var base string
+	for controllers, path := range cgroups {
+		if v1CgroupDir(map[string]string{controllers: path}, "cpu") != "" {
+			base = filepath.Join("/sys/fs/cgroup", controllers)
+		}
+	}
+	if relativeCgroups() {
+		base = cpuDir
+	}
+
+	pattern := lxc.GlobalConfigItem("lxc.cgroup.pattern")
+	if pattern == "" {
+		pattern = "lxc/%n"
+	}
+	if cgroupDir != "" && !relativeCgroups() {
+		pattern = cgroupDir
+	}
+	dirs := []string{}
+	dir := base
+	for _, elem := range strings.Split(filepath.Dir(pattern), "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		dir = filepath.Join(dir, elem)
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// realtimeBudget is the realtime runtime a create added to the budgets of
+// the parent cgroups, given back on delete.
+type realtimeBudget struct {
+	Runtime int64    `json:"runtime"`
+	Dirs    []string `json:"dirs"`
+}
+
+// lockRealtimeBudgets serializes the changes to the budgets, which other
+// containers in the same parent cgroups change as well.
+func lockRealtimeBudgets() (*os.File, error) {
+	if err := os.MkdirAll(RUNTIME_ROOT, stateDirMode()); err != nil {
+		return nil, errors.Wrapf(err, "failed to create '%s'", RUNTIME_ROOT)
+	}
+	lockPath := filepath.Join(RUNTIME_ROOT, realtimeLockName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file '%s'", lockPath)
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock '%s'", lockPath)
+	}
+	return f, nil
+}
+
+// addRealtimeRuntime adds delta to the budget of a cgroup. A new cgroup
+// has no budget file yet until the controller populated it, which counts
+// as a budget of 0.
+func addRealtimeRuntime(dir string, delta int64) error {
+	file := filepath.Join(dir, "cpu.rt_runtime_us")
+	current, err := readCgroupUint(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	value := int64(current) + delta
+	if value < 0 {
+		value = 0
+	}
+	return writeCgroupFile(file, strconv.FormatInt(value, 10))
+}
+
+// prepareRealtimeBudget adds the container's realtime runtime to the
+// budgets of the parent cgroups, on top of what they already gave to
+// other containers. The parents are raised top down, so that every
+// parent has room for its child's budget.
+func prepareRealtimeBudget(spec *specs.Spec) (*realtimeBudget, error) {
+	runtime, _ := realtimeResources(spec)
+	if runtime <= 0 {
+		return nil, nil
+	}
+	cgroupDir, err := specCgroupDir(spec)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := realtimeParentDirs(cgroupDir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockRealtimeBudgets()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	budget := &realtimeBudget{Runtime: runtime}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			releaseBudget(budget)
+			return nil, errors.Wrapf(err, "failed to create cgroup %s", dir)
+		}
+		if err := addRealtimeRuntime(dir, runtime); err != nil {
+			releaseBudget(budget)
+			return nil, errors.Wrapf(err, "failed to raise realtime budget of %s", dir)
+		}
+		budget.Dirs = append(budget.Dirs, dir)
+	}
+	return budget, nil
+}
+
+// releaseRealtimeBudget takes the container's runtime back from the
+// parent cgroups, once the container's cgroup is gone.
+func releaseRealtimeBudget(budget *realtimeBudget) {
+	if budget == nil || len(budget.Dirs) == 0 {
+		return
+	}
+	lock, err := lockRealtimeBudgets()
+	if err != nil {
+		log.Warnf("failed to release realtime budget: %v", err)
+		return
+	}
+	defer lock.Close()
+	releaseBudget(budget)
+}
+
+// releaseContainerRealtimeBudget releases the budget recorded in the
+// metadata of a container and forgets it, so that a retried delete
+// doesn't release it twice.
+func releaseContainerRealtimeBudget(containerID string) {
+	md, err := readMetadata(containerID)
+	if err != nil || md.RealtimeBudget == nil {
+		return
+	}
+	releaseRealtimeBudget(md.RealtimeBudget)
+	md.RealtimeBudget = nil
+	if err := writeMetadata(containerID, md); err != nil {
+		log.Warnf("failed to save container metadata: %v", err)
+	}
+}
+
+// releaseBudget lowers the budgets bottom up, a parent can't go below
+// what its children have. The lock must be held.
+func releaseBudget(budget *realtimeBudget) {
+	for i := len(budget.Dirs) - 1; i >= 0; i-- {
+		dir := budget.Dirs[i]
+		if err := addRealtimeRuntime(dir, -budget.Runtime); err != nil && !os.IsNotExist(err) {
+			log.Warnf("failed to lower realtime budget of %s: %v", dir, err)
+		}
+	}
+}
+
+func writeCgroupFile(path string, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(value)
+	return err
+}
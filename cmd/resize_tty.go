@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var resizeTtyCmd = cli.Command{
+	Name:   "resize-tty",
+	Usage:  "changes the window size of a container's or exec session's terminal",
+	Action: doResizeTty,
+	ArgsUsage: `<containerID> <rows> <cols>
+
+<containerID> is the ID of the container
+<rows> <cols> is the new window size
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "exec-id",
+			Usage: "resize the terminal of the exec session started with this --exec-id",
+		},
+	},
+}
+
+// doResizeTty sets the size on the pty slave the process has as stdin,
+// which works no matter who holds the master: the master is sent to the
+// console socket and create doesn't keep a copy. The kernel sends SIGWINCH
+// to the terminal's foreground process group.
+func doResizeTty(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 || len(ctx.Args()) != 3 {
+		fmt.Fprintf(os.Stderr, "missing container ID or size\n")
+		cli.ShowCommandHelpAndExit(ctx, "resize-tty", 1)
+	}
+	rows, err := strconv.ParseUint(ctx.Args().Get(1), 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid rows '%s'", ctx.Args().Get(1))
+	}
+	cols, err := strconv.ParseUint(ctx.Args().Get(2), 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid cols '%s'", ctx.Args().Get(2))
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	var pid int
+	if execID := ctx.String("exec-id"); execID != "" {
+		if !validContainerID.MatchString(execID) {
+			return fmt.Errorf("invalid exec ID '%s'", execID)
+		}
+		pid, err = execPid(containerID, execID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to look up exec session '%s'", execID)
+		}
+		if pid == 0 {
+			return fmt.Errorf("exec session '%s' of container '%s' is not running", execID, containerID)
+		}
+	} else {
+		c, err := lxc.NewContainer(containerID, LXC_PATH)
+		if err != nil {
+			return errors.Wrap(err, "failed to load container")
+		}
+		defer c.Release()
+		pid, err = containerInitPid(c, containerID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get container init pid")
+		}
+		if pid == 0 {
+			return errNotRunning(containerID, "cannot resize terminal")
+		}
+	}
+
+	tty, err := openPtySlave(fmt.Sprintf("/proc/%d/fd/0", pid))
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	ws := &unix.Winsize{Row: uint16(rows), Col: uint16(cols)}
+	if err := unix.IoctlSetWinsize(int(tty.Fd()), unix.TIOCSWINSZ, ws); err != nil {
+		return errors.Wrap(err, "failed to set window size")
+	}
+	return nil
+}
+
+// openPtySlave opens the file at path if it is a pty slave. The file is
+// whatever the process has as stdin, so it is checked before it is
+// opened, opening some devices has side effects, and again after, in case
+// it was replaced in between.
+func openPtySlave(path string) (*os.File, error) {
+	var before unix.Stat_t
+	if err := unix.Stat(path, &before); err != nil {
+		return nil, errors.Wrap(err, "failed to stat the process' stdin")
+	}
+	if !isPtySlave(&before) {
+		return nil, fmt.Errorf("the process has no terminal")
+	}
+	tty, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the process' terminal")
+	}
+	var after unix.Stat_t
+	if err := unix.Fstat(int(tty.Fd()), &after); err != nil {
+		tty.Close()
+		return nil, errors.Wrap(err, "failed to stat the process' terminal")
+	}
+	if after.Rdev != before.Rdev || after.Ino != before.Ino || after.Dev != before.Dev {
+		tty.Close()
+		return nil, fmt.Errorf("the process' stdin changed while it was opened")
+	}
+	return tty, nil
+}
+
+// isPtySlave checks for a unix98 pty slave, majors 136 to 143.
+func isPtySlave(st *unix.Stat_t) bool {
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return false
+	}
+	major := unix.Major(uint64(st.Rdev))
+	return major >= 136 && major <= 143
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestOpenPtySlave(t *testing.T) {
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	tty, err := openPtySlave(fmt.Sprintf("/proc/self/fd/%d", slave.Fd()))
+	if err != nil {
+		t.Fatalf("pty slave: %v", err)
+	}
+	tty.Close()
+
+	for _, path := range []string{"/dev/null", os.Args[0], fmt.Sprintf("/proc/self/fd/%d", master.Fd())} {
+		if tty, err := openPtySlave(path); err == nil {
+			tty.Close()
+			t.Errorf("openPtySlave(%s) succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// cgroupKey returns the lxc config key for a cgroup file, e.g.
+// lxc.cgroup2.cpuset.cpus on unified hosts and lxc.cgroup.cpuset.cpus
+// otherwise.
+func cgroupKey(file string) string {
+	if layout, err := cgroupLayout(); err == nil && layout == "unified" {
+		return "lxc.cgroup2." + file
+	}
+	return "lxc.cgroup." + file
+}
+
+// parseCPUList parses the kernel's list format, e.g. "0-3,8".
+func parseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list '%s'", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list '%s'", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// numaNodesOf returns the NUMA nodes the cpus belong to, in the list
+// format.
+func numaNodesOf(cpus []int) (string, error) {
+	nodeDirs, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return "", err
+	}
+	want := map[int]bool{}
+	for _, cpu := range cpus {
+		want[cpu] = true
+	}
+
+	nodes := []int{}
+	for _, dir := range nodeDirs {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return "", err
+		}
+		nodeCPUs, err := parseCPUList(string(data))
+		if err != nil {
+			return "", err
+		}
+		for _, cpu := range nodeCPUs {
+			if want[cpu] {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("no NUMA node found for the container's cpus")
+	}
+	sort.Ints(nodes)
+	list := make([]string, len(nodes))
+	for i, node := range nodes {
+		list[i] = strconv.Itoa(node)
+	}
+	return strings.Join(list, ","), nil
+}
+
+// configureCPUSet pins the container to the spec's cpus and memory nodes.
+// With NUMA alignment enabled and no memory nodes in the spec, memory is
+// allocated from the nodes of the assigned cpus only.
+func configureCPUSet(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.CPU == nil {
+		return nil
+	}
+	cpu := spec.Linux.Resources.CPU
+
+	if cpu.Cpus != "" {
+		if err := cfg.Set(cgroupKey("cpuset.cpus"), cpu.Cpus); err != nil {
+			return errors.Wrap(err, "failed to set cpuset.cpus")
+		}
+	}
+
+	mems := cpu.Mems
+	numaAlign, err := annotationBool(spec, ANNOTATION_NUMA_ALIGN, ctx.GlobalBool("numa-align"))
+	if err != nil {
+		return err
+	}
+	if mems == "" && numaAlign && cpu.Cpus != "" {
+		cpus, err := parseCPUList(cpu.Cpus)
+		if err != nil {
+			return err
+		}
+		mems, err = numaNodesOf(cpus)
+		if err != nil {
+			return errors.Wrap(err, "failed to align memory nodes")
+		}
+	}
+	if mems != "" {
+		if err := cfg.Set(cgroupKey("cpuset.mems"), mems); err != nil {
+			return errors.Wrap(err, "failed to set cpuset.mems")
+		}
+	}
+	return nil
+}
+
+// configureNetClassifier sets the net_cls class ID and net_prio interface
+// priorities, for traffic shaping by cgroup. The controllers only exist
+// in cgroup v1.
+func configureNetClassifier(cfg *lxcConfig, spec *specs.Spec) error {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.Network == nil {
+		return nil
+	}
+	network := spec.Linux.Resources.Network
+	if network.ClassID == nil && len(network.Priorities) == 0 {
+		return nil
+	}
+	if layout, err := cgroupLayout(); err == nil && layout == "unified" {
+		log.Warnf("ignoring network resources, net_cls and net_prio need cgroup v1")
+		return nil
+	}
+	if network.ClassID != nil {
+		if err := cfg.Set("lxc.cgroup.net_cls.classid", strconv.FormatUint(uint64(*network.ClassID), 10)); err != nil {
+			return errors.Wrap(err, "failed to set net_cls.classid")
+		}
+	}
+	// the kernel takes one interface per write
+	for _, prio := range network.Priorities {
+		if prio.Name == "" || strings.ContainsAny(prio.Name, " \t") {
+			return fmt.Errorf("invalid interface name %q in network priorities", prio.Name)
+		}
+		if err := cfg.Set("lxc.cgroup.net_prio.ifpriomap", fmt.Sprintf("%s %d", prio.Name, prio.Priority)); err != nil {
+			return errors.Wrap(err, "failed to set net_prio.ifpriomap")
+		}
+	}
+	return nil
+}
+
+// configureMemoryReservation sets the memory reservation as a soft limit,
+// memory.soft_limit_in_bytes with cgroup v1 and memory.high with v2, which
+// reclaim memory above it under pressure, or throttle the container,
+// without killing it.
+func configureMemoryReservation(cfg *lxcConfig, spec *specs.Spec) error {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.Memory == nil {
+		return nil
+	}
+	memory := spec.Linux.Resources.Memory
+	if memory.Reservation == nil || *memory.Reservation == 0 {
+		return nil
+	}
+	reservation := *memory.Reservation
+	if reservation < -1 {
+		return fmt.Errorf("invalid memory reservation %d", reservation)
+	}
+	if reservation > 0 && memory.Limit != nil && *memory.Limit > 0 && reservation > *memory.Limit {
+		return fmt.Errorf("memory reservation %d is above the memory limit %d", reservation, *memory.Limit)
+	}
+
+	if layout, err := cgroupLayout(); err == nil && layout == "unified" {
+		value := "max"
+		if reservation > 0 {
+			value = strconv.FormatInt(reservation, 10)
+		}
+		return cfg.Set(cgroupKey("memory.high"), value)
+	}
+	return cfg.Set(cgroupKey("memory.soft_limit_in_bytes"), strconv.FormatInt(reservation, 10))
+}
+
+// configureResources translates the spec's resource limits to cgroup
+// settings.
+func configureResources(ctx *cli.Context, cfg *lxcConfig, spec *specs.Spec) error {
+	if err := configureCPUSet(ctx, cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure cpuset")
+	}
+	if err := configureRealtime(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure realtime scheduling")
+	}
+	if err := configureCPUIdle(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure cpu.idle")
+	}
+	if err := configureMemoryReservation(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure memory reservation")
+	}
+	if err := configureNetClassifier(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure network classification")
+	}
+	return nil
+}
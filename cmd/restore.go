@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var restoreCmd = cli.Command{
+	Name:   "restore",
+	Usage:  "restores a container from a CRIU checkpoint",
+	Action: doRestore,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the checkpointed container to restore
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "image-path",
+			Usage: "directory with the checkpoint images",
+		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "return once the container is restored instead of waiting for it to exit",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Usage: "write the pid of the restored container init to this file",
+		},
+	},
+}
+
+// restoreTimeout bounds how long restore waits for the restored container
+// to show up as running.
+const restoreTimeout = 30 * time.Second
+
+// waitRestored waits for lxc to report the restored container running and
+// records its new init pid. exited is closed when the monitor exits.
+func waitRestored(c *lxc.Container, containerID string, exited <-chan struct{}) (int, error) {
+	deadline := time.Now().Add(restoreTimeout)
+	for {
+		select {
+		case <-exited:
+			return 0, fmt.Errorf("restore of '%s' failed, see the lxc log", containerID)
+		default:
+		}
+		if c.Running() {
+			pid := c.InitPid()
+			if pid > 0 {
+				startTime, err := procStartTime(pid)
+				if err != nil {
+					return 0, errors.Wrapf(err, "failed to get start time of pid %d", pid)
+				}
+				md, err := readMetadata(containerID)
+				if err != nil {
+					return 0, err
+				}
+				md.InitPid = pid
+				md.InitStartTime = startTime
+				return pid, writeMetadata(containerID, md)
+			}
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("timed out waiting for '%s' to be restored", containerID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func doRestore(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "restore", 1)
+	}
+	imagePath, err := filepath.Abs(ctx.String("image-path"))
+	if err != nil || ctx.String("image-path") == "" {
+		return fmt.Errorf("missing or invalid --image-path")
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return errors.Wrap(err, "failed to access checkpoint images")
+	}
+	if !hostSysAdmin() {
+		return fmt.Errorf("restore requires CAP_SYS_ADMIN on the host")
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if running {
+		return errRunning(containerID, "cannot restore")
+	}
+
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return err
+	}
+	// The terminal setting comes from the bundle, the process itself is
+	// restored from the images. A restored process keeps the console it
+	// was checkpointed with, a new pty would not be connected to it.
+	process := &specs.Process{}
+	if spec, err := readBundleSpec(filepath.Join(md.Bundle, "config.json")); err == nil {
+		process = spec.Process
+	} else {
+		log.Warnf("failed to read bundle spec, assuming no terminal: %v", err)
+	}
+	if process.Terminal {
+		return fmt.Errorf("'%s' has a terminal, restoring containers with a terminal is not supported", containerID)
+	}
+
+	// the old init is gone, fall back to lxc until the new one is known
+	md.InitPid = 0
+	md.InitStartTime = 0
+	if err := writeMetadata(containerID, md); err != nil {
+		return err
+	}
+	if err := os.Remove(exitStatusPath(containerID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove previous exit status")
+	}
+
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	// "internal-restore" is handled by the C constructor in internal.go,
+	// the process becomes the monitor of the restored container.
+	cmd := exec.Command(
+		binary,
+		"internal-restore",
+		containerID,
+		LXC_PATH,
+		lxcPath(containerID, configFileName),
+		imagePath,
+		exitStatusPath(containerID),
+	)
+	setMonitorEnv(ctx, cmd)
+	closeStdio, err := attachStdio(ctx, cmd, process)
+	if err != nil {
+		return err
+	}
+	defer closeStdio()
+
+	sp := startSpan("lxc restore")
+	err = cmd.Start()
+	var pid int
+	var waitErr error
+	exited := make(chan struct{})
+	if err == nil {
+		go func() {
+			waitErr = cmd.Wait()
+			close(exited)
+		}()
+		pid, err = waitRestored(c, containerID, exited)
+	}
+	sp.End(err)
+	if err != nil {
+		return errors.Wrap(err, "failed to restore container")
+	}
+	notifyState(ctx, containerID, "running")
+
+	if ctx.IsSet("pid-file") {
+		if err := writePidFile(ctx.String("pid-file"), pid); err != nil {
+			return errors.Wrap(err, "failed to write pid file")
+		}
+	}
+
+	if ctx.Bool("detach") {
+		return nil
+	}
+	// the monitor exits with the container
+	<-exited
+	if exitErr, ok := waitErr.(*exec.ExitError); ok {
+		return errExitStatus(unix.WaitStatus(exitErr.Sys().(syscall.WaitStatus)))
+	}
+	return waitErr
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/sys/unix"
+)
+
+// monitorExitTimeout is how long rollback gives the lxc monitor to clean up
+// (e.g. cgroups) after the container init was killed.
+const monitorExitTimeout = 2 * time.Second
+
+// createRollback undoes a partially completed create. It is run on errors
+// and from the signal handler, so it is safe to call more than once and
+// from another goroutine.
+type createRollback struct {
+	mu          sync.Mutex
+	containerID string
+	ownsDir     bool
+	monitorPid  int
+	processes   []int
+	done        bool
+}
+
+// setOwnsDir marks the container dir as created by this create, so that
+// rollback may remove it.
+func (rb *createRollback) setOwnsDir() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.ownsDir = true
+}
+
+// setMonitor records the lxc monitor process started by create.
+func (rb *createRollback) setMonitor(pid int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.monitorPid = pid
+}
+
+// addProcess records a helper process started by create.
+func (rb *createRollback) addProcess(pid int) {
+	if pid <= 0 {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.processes = append(rb.processes, pid)
+}
+
+func (rb *createRollback) run() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.done || !rb.ownsDir {
+		return
+	}
+	rb.done = true
+
+	// Killing the init first lets the monitor tear down the container
+	// (cgroups, mounts) before it is killed as well.
+	md, err := readMetadata(rb.containerID)
+	if err == nil && md.InitPid > 0 && pidAlive(md.InitPid, md.InitStartTime) {
+		unix.Kill(md.InitPid, unix.SIGKILL)
+	}
+	if rb.monitorPid > 0 {
+		waitExited(rb.monitorPid, monitorExitTimeout)
+		unix.Kill(rb.monitorPid, unix.SIGKILL)
+	}
+	if md != nil {
+		releaseRealtimeBudget(md.RealtimeBudget)
+	}
+	for _, pid := range rb.processes {
+		unix.Kill(pid, unix.SIGKILL)
+	}
+
+	for _, dir := range []string{lxcDir(rb.containerID), runtimeDir(rb.containerID)} {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warnf("failed to remove '%s': %v", dir, err)
+		}
+	}
+}
+
+// waitExited polls until pid has exited or become a zombie, or the timeout
+// has passed.
+func waitExited(pid int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		state, err := procState(pid)
+		if err != nil || state == "Z" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
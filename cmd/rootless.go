@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// rootlessTmpDir is the directory in the temp dir the rootless runtime
+// root defaults to without XDG_RUNTIME_DIR, "" if the default isn't
+// there.
+var rootlessTmpDir = ""
+
+// rootlessMode is --rootless: "auto" decides by the effective uid,
+// "true" and "false" override it, e.g. for root in a user namespace.
+var rootlessMode = "auto"
+
+func isRootless() bool {
+	switch rootlessMode {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return os.Geteuid() != 0
+}
+
+// useCriu makes liblxc, which runs "criu" from $PATH, run the criu binary
+// given with --criu.
+func useCriu(path string) error {
+	if path == "" || path == "criu" {
+		return nil
+	}
+	if filepath.Base(path) != "criu" {
+		return fmt.Errorf("--criu must name a binary called criu, liblxc runs criu from $PATH")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return os.Setenv("PATH", filepath.Dir(abs)+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// setRootlessDefaults points the runtime root and lxc path at per user
+// directories when running unprivileged, so that users don't share (or fail
+// to write to) the system wide directories.
+func setRootlessDefaults() {
+	if !isRootless() {
+		return
+	}
+
+	baseDir := os.Getenv("XDG_RUNTIME_DIR")
+	if baseDir == "" {
+		baseDir = filepath.Join(os.TempDir(), fmt.Sprintf("crio-lxc-%d", os.Geteuid()))
+		rootlessTmpDir = baseDir
+	}
+	RUNTIME_ROOT = filepath.Join(baseDir, "crio-lxc")
+
+	// the same default liblxc uses for unprivileged users
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		dataDir = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	LXC_PATH = filepath.Join(dataDir, "lxc")
+}
+
+// checkRootlessTmpDir creates rootlessTmpDir, or checks that the one that
+// exists is private to the user: any user can create it in the temp dir,
+// or a symlink by its name, to get at the state of the containers.
+func checkRootlessTmpDir() error {
+	if err := os.Mkdir(rootlessTmpDir, 0700); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "failed to create '%s'", rootlessTmpDir)
+	}
+	fi, err := os.Lstat(rootlessTmpDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("'%s' is not a directory, set XDG_RUNTIME_DIR or --root", rootlessTmpDir)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Geteuid() {
+		return fmt.Errorf("'%s' is owned by uid %d, set XDG_RUNTIME_DIR or --root", rootlessTmpDir, uid)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("'%s' has mode %#o instead of 0700, set XDG_RUNTIME_DIR or --root", rootlessTmpDir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// stateDirMode is the mode for directories created below the runtime root
+// and lxc path. Rootless state is private to the user.
+func stateDirMode() os.FileMode {
+	if isRootless() {
+		return 0700
+	}
+	return 0711
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// run is create, start and, once the container exited, delete in one
+// command, for running containers by hand. With --keep the container is
+// deleted as delete --keep does, so that a failed run can be looked into.
+
+var runCmd = cli.Command{
+	Name:   "run",
+	Usage:  "creates and starts a container, waits for it to exit and deletes it",
+	Action: doRun,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to run
+`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:   "keep",
+			Usage:  "keep the lxc config, logs and runtime state of the container for post-mortem debugging",
+			EnvVar: "CRIO_LXC_RUN_KEEP",
+		},
+	}, createCmd.Flags...),
+}
+
+// runSignals are forwarded to the container init while run waits.
+var runSignals = []os.Signal{unix.SIGTERM, unix.SIGINT, unix.SIGHUP, unix.SIGQUIT, unix.SIGUSR1, unix.SIGUSR2}
+
+func doRun(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "run", 1)
+	}
+
+	if err := doCreate(ctx); err != nil {
+		return err
+	}
+	// the container is deleted whatever happens from here on
+	err := runContainer(ctx, containerID)
+	if delErr := deleteContainer(ctx, containerID); delErr != nil {
+		log.Errorf("failed to delete container %s: %v", containerID, delErr)
+		if err == nil {
+			err = delErr
+		}
+	}
+	return err
+}
+
+// runContainer starts the created container and waits for it to exit,
+// returning its exit status.
+func runContainer(ctx *cli.Context, containerID string) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, runSignals...)
+	defer signal.Stop(signals)
+
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return err
+	}
+	if err := doStart(ctx); err != nil {
+		// the init is still waiting for the start, it has to be gone
+		// before the container can be deleted
+		if err := unix.Kill(md.InitPid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			log.Warnf("failed to kill container %s: %v", containerID, err)
+		}
+		for pidAlive(md.InitPid, md.InitStartTime) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return err
+	}
+
+	for pidAlive(md.InitPid, md.InitStartTime) {
+		select {
+		case sig := <-signals:
+			if err := unix.Kill(md.InitPid, sig.(unix.Signal)); err != nil && err != unix.ESRCH {
+				log.Warnf("failed to forward %s: %v", sig, err)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	waitExitStatus(containerID, forceStopTimeout)
+	st, err := readExitStatus(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to read exit status")
+	}
+	if st == nil || st.ExitCode == 0 {
+		return nil
+	}
+	return &exitStatusError{st.ExitCode}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// The runtime config file holds what the admin adds to every container,
+// e.g. the time zone data or a licensing socket. Its entries come after
+// the spec's, and the spec wins where both have one: a mount or device
+// isn't added where the spec mounts something at the same path, and an
+// environment variable isn't added if the spec sets it.
+
+const defaultRuntimeConfig = "/etc/crio-lxc/config.json"
+
+type runtimeConfig struct {
+	// Mounts are added to the spec's mounts, in the format of the spec.
+	Mounts []specs.Mount `json:"mounts"`
+	// Devices are host device nodes bind mounted into the container and
+	// allowed in its devices cgroup.
+	Devices []runtimeConfigDevice `json:"devices"`
+	// Env are KEY=VALUE pairs.
+	Env []string `json:"env"`
+}
+
+type runtimeConfigDevice struct {
+	Path string `json:"path"`
+	// ContainerPath defaults to Path.
+	ContainerPath string `json:"containerPath"`
+	// Permissions of the cgroup rule, a combination of r, w and m,
+	// default rwm.
+	Permissions string `json:"permissions"`
+}
+
+// loadRuntimeConfig reads the runtime config file. The default one is
+// optional, a --config that doesn't exist is an error.
+func loadRuntimeConfig(ctx *cli.Context) (*runtimeConfig, error) {
+	path := ctx.GlobalString("config")
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !ctx.GlobalIsSet("config") {
+		return &runtimeConfig{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open runtime config")
+	}
+	defer f.Close()
+
+	rc := &runtimeConfig{}
+	if err := json.NewDecoder(f).Decode(rc); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse runtime config '%s'", path)
+	}
+	if err := rc.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid runtime config '%s'", path)
+	}
+	return rc, nil
+}
+
+func (rc *runtimeConfig) validate() error {
+	for _, ms := range rc.Mounts {
+		if !filepath.IsAbs(ms.Destination) {
+			return fmt.Errorf("mount destination '%s' is not absolute", ms.Destination)
+		}
+		// there is no bundle for relative sources
+		if isBindMount(ms) && !filepath.IsAbs(ms.Source) {
+			return fmt.Errorf("bind mount source '%s' is not absolute", ms.Source)
+		}
+	}
+	for i, dev := range rc.Devices {
+		if !filepath.IsAbs(dev.Path) {
+			return fmt.Errorf("device path '%s' is not absolute", dev.Path)
+		}
+		if dev.ContainerPath == "" {
+			rc.Devices[i].ContainerPath = dev.Path
+		} else if !filepath.IsAbs(dev.ContainerPath) {
+			return fmt.Errorf("device container path '%s' is not absolute", dev.ContainerPath)
+		}
+		if dev.Permissions == "" {
+			rc.Devices[i].Permissions = "rwm"
+		} else if strings.Trim(dev.Permissions, "rwm") != "" {
+			return fmt.Errorf("invalid permissions '%s' of device '%s'", dev.Permissions, dev.Path)
+		}
+	}
+	for _, env := range rc.Env {
+		if !strings.Contains(env, "=") || strings.HasPrefix(env, "=") {
+			return fmt.Errorf("invalid environment variable '%s'", env)
+		}
+	}
+	return nil
+}
+
+// specHasMount is whether the spec mounts something, or creates a device,
+// at dest.
+func specHasMount(spec *specs.Spec, dest string) bool {
+	dest = filepath.Clean(dest)
+	for _, ms := range spec.Mounts {
+		if filepath.Clean(ms.Destination) == dest {
+			return true
+		}
+	}
+	if spec.Linux != nil {
+		for _, dev := range spec.Linux.Devices {
+			if filepath.Clean(dev.Path) == dest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyToSpec adds the mounts and environment to the spec, so that they
+// are translated like the spec's own.
+func (rc *runtimeConfig) applyToSpec(spec *specs.Spec) {
+	for _, ms := range rc.Mounts {
+		if specHasMount(spec, ms.Destination) {
+			log.Debugf("not adding default mount %s, the spec has one", ms.Destination)
+			continue
+		}
+		ms.Destination = filepath.Clean(ms.Destination)
+		spec.Mounts = append(spec.Mounts, ms)
+	}
+	for _, env := range rc.Env {
+		key := env[:strings.Index(env, "=")]
+		if _, ok := specEnv(spec, key); ok {
+			log.Debugf("not adding default environment variable %s, the spec sets it", key)
+			continue
+		}
+		spec.Process.Env = append(spec.Process.Env, env)
+	}
+}
+
+// configureDevices bind mounts the device nodes and allows them in the
+// devices cgroup.
+func (rc *runtimeConfig) configureDevices(cfg *lxcConfig, spec *specs.Spec) error {
+	for _, dev := range rc.Devices {
+		if specHasMount(spec, dev.ContainerPath) {
+			log.Debugf("not adding default device %s, the spec has one", dev.ContainerPath)
+			continue
+		}
+		var st unix.Stat_t
+		if err := unix.Stat(dev.Path, &st); err != nil {
+			return errors.Wrapf(err, "failed to stat device '%s'", dev.Path)
+		}
+		kind := "c"
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR:
+		case unix.S_IFBLK:
+			kind = "b"
+		default:
+			return fmt.Errorf("'%s' is not a device node", dev.Path)
+		}
+
+		mnt := fmt.Sprintf("%s %s none bind,create=file 0 0", dev.Path, strings.TrimPrefix(filepath.Clean(dev.ContainerPath), "/"))
+		if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
+			return errors.Wrapf(err, "failed to mount device '%s'", dev.Path)
+		}
+		rdev := uint64(st.Rdev)
+		rule := fmt.Sprintf("%s %d:%d %s", kind, unix.Major(rdev), unix.Minor(rdev), dev.Permissions)
+		if err := allowDevice(cfg, rule); err != nil {
+			return errors.Wrapf(err, "failed to allow device '%s'", dev.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// specProcessExt has the fields of the spec's process that are newer than
+// the vendored runtime-spec, read separately from the bundle config like
+// the domainname.
+type specProcessExt struct {
+	Scheduler  *linuxScheduler  `json:"scheduler,omitempty"`
+	IOPriority *linuxIOPriority `json:"ioPriority,omitempty"`
+}
+
+// linuxScheduler is the OCI 1.1 process.scheduler.
+type linuxScheduler struct {
+	Policy   string   `json:"policy"`
+	Nice     int32    `json:"nice,omitempty"`
+	Priority int32    `json:"priority,omitempty"`
+	Flags    []string `json:"flags,omitempty"`
+	Runtime  uint64   `json:"runtime,omitempty"`
+	Deadline uint64   `json:"deadline,omitempty"`
+	Period   uint64   `json:"period,omitempty"`
+}
+
+func readBundleProcessExt(bundle string) (*specProcessExt, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var spec struct {
+		Process *specProcessExt `json:"process,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, err
+	}
+	if spec.Process == nil {
+		return &specProcessExt{}, nil
+	}
+	return spec.Process, nil
+}
+
+var specSchedPolicies = map[string]uint32{
+	"SCHED_OTHER":    0,
+	"SCHED_FIFO":     1,
+	"SCHED_RR":       2,
+	"SCHED_BATCH":    3,
+	"SCHED_ISO":      4,
+	"SCHED_IDLE":     5,
+	"SCHED_DEADLINE": 6,
+}
+
+var specSchedFlags = map[string]uint64{
+	"SCHED_FLAG_RESET_ON_FORK":  0x01,
+	"SCHED_FLAG_RECLAIM":        0x02,
+	"SCHED_FLAG_DL_OVERRUN":     0x04,
+	"SCHED_FLAG_KEEP_POLICY":    0x08,
+	"SCHED_FLAG_KEEP_PARAMS":    0x10,
+	"SCHED_FLAG_UTIL_CLAMP_MIN": 0x20,
+	"SCHED_FLAG_UTIL_CLAMP_MAX": 0x40,
+}
+
+// schedAttr is struct sched_attr in its first version (48 bytes), without
+// the utilization clamps.
+type schedAttr struct {
+	size     uint32
+	policy   uint32
+	flags    uint64
+	nice     int32
+	priority uint32
+	runtime  uint64
+	deadline uint64
+	period   uint64
+}
+
+// schedAttrOf validates the spec's scheduler and converts it.
+func schedAttrOf(s *linuxScheduler) (*schedAttr, error) {
+	policy, ok := specSchedPolicies[s.Policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown scheduler policy '%s'", s.Policy)
+	}
+	attr := &schedAttr{
+		policy:   policy,
+		nice:     s.Nice,
+		runtime:  s.Runtime,
+		deadline: s.Deadline,
+		period:   s.Period,
+	}
+	attr.size = uint32(unsafe.Sizeof(*attr))
+	for _, name := range s.Flags {
+		flag, ok := specSchedFlags[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown scheduler flag '%s'", name)
+		}
+		attr.flags |= flag
+	}
+	if s.Nice < -20 || s.Nice > 19 {
+		return nil, fmt.Errorf("scheduler nice %d out of range -20..19", s.Nice)
+	}
+	switch s.Policy {
+	case "SCHED_FIFO", "SCHED_RR":
+		if s.Priority < 1 || s.Priority > 99 {
+			return nil, fmt.Errorf("scheduler priority %d out of range 1..99 for %s", s.Priority, s.Policy)
+		}
+		attr.priority = uint32(s.Priority)
+	case "SCHED_DEADLINE":
+		if s.Runtime == 0 || s.Runtime > s.Deadline || (s.Period != 0 && s.Deadline > s.Period) {
+			return nil, fmt.Errorf("SCHED_DEADLINE needs 0 < runtime <= deadline <= period")
+		}
+	default:
+		if s.Priority != 0 {
+			return nil, fmt.Errorf("scheduler priority must be 0 for %s", s.Policy)
+		}
+	}
+	return attr, nil
+}
+
+// setSchedAttr is sched_setattr(2), which x/sys/unix doesn't wrap.
+func setSchedAttr(pid int, attr *schedAttr) error {
+	_, _, errno := unix.Syscall(unix.SYS_SCHED_SETATTR, uintptr(pid), uintptr(unsafe.Pointer(attr)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
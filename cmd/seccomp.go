@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	seccompRuntimeDefault = "runtime/default"
+	seccompUnconfined     = "unconfined"
+	seccompSpec           = "spec"
+	// profileLXCDefault is recorded for seccomp and apparmor when the
+	// profile is left to liblxc.
+	profileLXCDefault = "lxc-default"
+)
+
+// actErrnoENOSYS fails a syscall with ENOSYS. It isn't an OCI action, the
+// spec has no errno value, and is only used by the default profile.
+const actErrnoENOSYS specs.LinuxSeccompAction = "SCMP_ACT_ERRNO_ENOSYS"
+
+// seccompAction converts an OCI action to lxc's policy syntax.
+func seccompAction(action specs.LinuxSeccompAction) (string, error) {
+	switch string(action) {
+	case "SCMP_ACT_ALLOW":
+		return "allow", nil
+	case "SCMP_ACT_ERRNO":
+		// the vendored spec has no errno value, EPERM is what runc
+		// uses; checkSeccompErrnoRet rejects bundles asking for another
+		return "errno 1", nil
+	case string(actErrnoENOSYS):
+		return "errno 38", nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD", "SCMP_ACT_KILL_PROCESS":
+		return "kill", nil
+	case "SCMP_ACT_TRAP":
+		return "trap", nil
+	}
+	return "", fmt.Errorf("seccomp action %s is not supported by lxc", action)
+}
+
+// seccompErrnoEPERM is the errno of SCMP_ACT_ERRNO, the only errnoRet
+// a bundle can have.
+const seccompErrnoEPERM = 1
+
+// checkSeccompErrnoRet reads linux.seccomp.defaultErrnoRet and the
+// errnoRet of the syscalls, which OCI 1.1 added, from the bundle. The
+// vendored runtime-spec doesn't have them, so anything but EPERM would be
+// dropped silently and is rejected instead.
+func checkSeccompErrnoRet(bundle string) error {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var spec struct {
+		Linux *struct {
+			Seccomp *struct {
+				DefaultErrnoRet *uint `json:"defaultErrnoRet,omitempty"`
+				Syscalls        []struct {
+					Names    []string `json:"names"`
+					ErrnoRet *uint    `json:"errnoRet,omitempty"`
+				} `json:"syscalls,omitempty"`
+			} `json:"seccomp,omitempty"`
+		} `json:"linux,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return err
+	}
+	if spec.Linux == nil || spec.Linux.Seccomp == nil {
+		return nil
+	}
+	seccomp := spec.Linux.Seccomp
+	if ret := seccomp.DefaultErrnoRet; ret != nil && *ret != seccompErrnoEPERM {
+		return fmt.Errorf("seccomp defaultErrnoRet %d is not supported, only %d (EPERM) is", *ret, seccompErrnoEPERM)
+	}
+	for _, sc := range seccomp.Syscalls {
+		if ret := sc.ErrnoRet; ret != nil && *ret != seccompErrnoEPERM {
+			return fmt.Errorf("seccomp errnoRet %d of %s is not supported, only %d (EPERM) is", *ret, strings.Join(sc.Names, ","), seccompErrnoEPERM)
+		}
+	}
+	return nil
+}
+
+// seccompArg converts an argument filter to lxc's [index,value,op,mask].
+// For SCMP_CMP_MASKED_EQ the spec's value is the mask and valueTwo the
+// value to compare against.
+func seccompArg(arg specs.LinuxSeccompArg) string {
+	if arg.Op == specs.OpMaskedEqual {
+		return fmt.Sprintf("[%d,%d,%s,%d]", arg.Index, arg.ValueTwo, arg.Op, arg.Value)
+	}
+	return fmt.Sprintf("[%d,%d,%s]", arg.Index, arg.Value, arg.Op)
+}
+
+// seccompPolicy renders a spec seccomp config as an lxc version 2 policy.
+// The rules apply to all architectures lxc loads the filter for.
+func seccompPolicy(seccomp *specs.LinuxSeccomp) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("2\n")
+
+	defaultAction, err := seccompAction(seccomp.DefaultAction)
+	if err != nil {
+		return nil, err
+	}
+	if defaultAction == "allow" {
+		b.WriteString("blacklist\n")
+	} else {
+		fmt.Fprintf(&b, "whitelist %s\n", defaultAction)
+	}
+	b.WriteString("[all]\n")
+
+	for _, syscall := range seccomp.Syscalls {
+		action, err := seccompAction(syscall.Action)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]string, 0, len(syscall.Args))
+		for _, arg := range syscall.Args {
+			args = append(args, seccompArg(arg))
+		}
+		for _, name := range syscall.Names {
+			line := name + " " + action
+			if len(args) > 0 {
+				line += " " + strings.Join(args, " ")
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// seccompSource is where the seccomp config of a container comes from:
+// the spec, the built in default profile, or none because the container
+// is unconfined. The default profile is used if the seccomp annotation
+// asks for runtime/default, or if --default-seccomp is set and the
+// container is neither privileged nor asks to be unconfined. Without
+// either, the profile liblxc's default config sets, if it is included,
+// is kept.
+func seccompSource(ctx *cli.Context, spec *specs.Spec) (string, error) {
+	if spec.Linux != nil && spec.Linux.Seccomp != nil {
+		return seccompSpec, nil
+	}
+	switch value := spec.Annotations[ANNOTATION_SECCOMP]; value {
+	case "":
+	case seccompRuntimeDefault, seccompUnconfined:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid value '%s' for annotation %s", value, ANNOTATION_SECCOMP)
+	}
+	if isPrivileged(spec) {
+		return seccompUnconfined, nil
+	}
+	if ctx.GlobalBool("default-seccomp") {
+		return seccompRuntimeDefault, nil
+	}
+	if ctx.GlobalBool("lxc-defaults") {
+		return profileLXCDefault, nil
+	}
+	return seccompUnconfined, nil
+}
+
+// containerSeccomp returns the seccomp config to apply, nil for none.
+func containerSeccomp(ctx *cli.Context, spec *specs.Spec) (*specs.LinuxSeccomp, error) {
+	source, err := seccompSource(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	switch source {
+	case seccompSpec:
+		return spec.Linux.Seccomp, nil
+	case seccompRuntimeDefault:
+		var caps []string
+		if spec.Process.Capabilities != nil {
+			caps = spec.Process.Capabilities.Bounding
+		}
+		return defaultSeccompProfile(caps), nil
+	}
+	return nil, nil
+}
+
+func configureSeccomp(ctx *cli.Context, cfg *lxcConfig, containerID string, spec *specs.Spec) error {
+	source, err := seccompSource(ctx, spec)
+	if err != nil {
+		return err
+	}
+	if source == seccompUnconfined && ctx.GlobalBool("lxc-defaults") {
+		// clears the profile of liblxc's default config
+		return cfg.Set("lxc.seccomp.profile", "")
+	}
+
+	seccomp, err := containerSeccomp(ctx, spec)
+	if err != nil {
+		return err
+	}
+	if seccomp == nil {
+		return nil
+	}
+
+	policy, err := seccompPolicy(seccomp)
+	if err != nil {
+		return errors.Wrap(err, "failed to translate seccomp config")
+	}
+	path := lxcPath(containerID, seccompFileName)
+	cfg.AddFile(path, policy)
+	return cfg.Set("lxc.seccomp.profile", path)
+}
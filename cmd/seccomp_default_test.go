@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultSeccompProfileOrder(t *testing.T) {
+	caps := []string{"CAP_SYS_PTRACE", "CAP_SYS_ADMIN", "CAP_SYS_TIME", "CAP_SYSLOG"}
+	want := defaultSeccompProfile(caps)
+	for i := 0; i < 20; i++ {
+		if got := defaultSeccompProfile(caps); !reflect.DeepEqual(got, want) {
+			t.Fatalf("profile differs between calls:\n%v\n%v", got, want)
+		}
+	}
+}
+
+func TestDefaultSeccompProfileClone(t *testing.T) {
+	if n := len(defaultSeccompProfile(nil).Syscalls); n != 3 {
+		t.Errorf("profile without CAP_SYS_ADMIN has %d rules, want 3 with the clone and clone3 rules", n)
+	}
+	if n := len(defaultSeccompProfile([]string{"CAP_SYS_ADMIN"}).Syscalls); n != 1 {
+		t.Errorf("profile with CAP_SYS_ADMIN has %d rules, want 1", n)
+	}
+}
+
+func TestDefaultSeccompProfileClone3(t *testing.T) {
+	for _, tc := range []struct {
+		caps []string
+		want string
+	}{
+		{nil, "clone3 errno 38\n"},
+		{[]string{"CAP_SYS_ADMIN"}, "clone3 allow\n"},
+	} {
+		policy, err := seccompPolicy(defaultSeccompProfile(tc.caps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(policy), tc.want) {
+			t.Errorf("policy for %v has no %q:\n%s", tc.caps, tc.want, policy)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSeccompErrnoRet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crio-lxc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		seccomp string
+		ok      bool
+	}{
+		{``, true},
+		{`"seccomp": {"defaultAction": "SCMP_ACT_ERRNO"}`, true},
+		{`"seccomp": {"defaultAction": "SCMP_ACT_ERRNO", "defaultErrnoRet": 1}`, true},
+		{`"seccomp": {"defaultAction": "SCMP_ACT_ERRNO", "defaultErrnoRet": 38}`, false},
+		{`"seccomp": {"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["clone3"], "action": "SCMP_ACT_ERRNO", "errnoRet": 1}]}`, true},
+		{`"seccomp": {"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["clone3"], "action": "SCMP_ACT_ERRNO", "errnoRet": 38}]}`, false},
+	}
+	for _, tt := range tests {
+		config := `{"ociVersion": "1.1.0", "linux": {` + tt.seccomp + `}}`
+		if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := checkSeccompErrnoRet(dir)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.seccomp, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted", tt.seccomp)
+		}
+	}
+}
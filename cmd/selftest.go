@@ -0,0 +1,523 @@
+package main
+
+import (
+	"compress/gzip"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// selftest runs test containers through what cri-o does with them -
+// create, start, exec, kill and delete - with the global flags it is given,
+// so that it tests the runtime as cri-o would run it. Each feature gets a
+// container of its own, the features the host lacks are skipped.
+//
+// The rootfs is the busybox one built into crio-lxc with the
+// selftest_rootfs tag (see selftest_rootfs_gen.go), or made of a static
+// busybox of the host, or unpacked from --image.
+
+var selftestCmd = cli.Command{
+	Name:   "selftest",
+	Usage:  "runs test containers through their lifecycle and reports the features that work",
+	Action: doSelftest,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "busybox",
+			Usage: "the statically linked busybox to make the rootfs of, instead of the built in one",
+		},
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "an OCI image layout or docker archive to unpack the rootfs from, instead of busybox; it needs /bin/sleep and /bin/true",
+		},
+		cli.StringFlag{
+			Name:  "ref",
+			Usage: "the image of --image to unpack, if it has more than one",
+		},
+		cli.BoolFlag{
+			Name:  "keep",
+			Usage: "keep the bundles of the test containers",
+		},
+	},
+}
+
+const (
+	// selftestIDBase is where the user namespace test maps root to.
+	selftestIDBase  = 100000
+	selftestIDRange = 65536
+	// selftestTimeout is how long the container may take to stop.
+	selftestTimeout = 10 * time.Second
+)
+
+var selftestApplets = []string{"sh", "sleep", "true", "cat"}
+
+type selftestCheck struct {
+	name string
+	// unsupported is why the host can't run the check, empty if it can.
+	unsupported func() string
+	// prepare changes the spec and rootfs of the container.
+	prepare func(spec *specs.Spec, rootfs string) error
+	// verify checks the state of the running container.
+	verify   func(state *specs.State) error
+	terminal bool
+}
+
+var selftestChecks = []selftestCheck{
+	{name: "lifecycle"},
+	{name: "userns", unsupported: selftestUserNSUnsupported, prepare: prepareSelftestUserNS},
+	{name: "cgroupv2", unsupported: selftestCgroup2Unsupported, prepare: prepareSelftestCgroup2, verify: verifySelftestCgroup2},
+	{name: "seccomp", unsupported: selftestSeccompUnsupported, prepare: prepareSelftestSeccomp, verify: verifySelftestSeccomp},
+	{name: "terminal", terminal: true},
+}
+
+type selftest struct {
+	binary     string
+	globalArgs []string
+	dir        string
+	// makeRootfs fills the rootfs and returns the image config of it.
+	makeRootfs func(rootfs string) (*imageConfig, error)
+}
+
+// globalArgs are the global flags of the command line, what precedes the
+// command.
+func globalArgs(ctx *cli.Context) []string {
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		if arg == ctx.Command.Name {
+			break
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+func doSelftest(ctx *cli.Context) error {
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	st := &selftest{binary: binary, globalArgs: globalArgs(ctx)}
+
+	if image := ctx.String("image"); image != "" {
+		ref := ctx.String("ref")
+		st.makeRootfs = func(rootfs string) (*imageConfig, error) {
+			return unpackSelftestImage(image, ref, rootfs)
+		}
+	} else if ctx.String("busybox") == "" && selftestRootfs != "" {
+		st.makeRootfs = unpackSelftestRootfs
+	} else {
+		busybox := ctx.String("busybox")
+		if busybox == "" {
+			if busybox, err = exec.LookPath("busybox"); err != nil {
+				return errors.Wrap(err, "crio-lxc has no rootfs built in and there is no busybox, use --busybox or --image")
+			}
+		}
+		if err := checkStaticBinary(busybox); err != nil {
+			return err
+		}
+		st.makeRootfs = func(rootfs string) (*imageConfig, error) {
+			return makeBusyboxRootfs(busybox, rootfs)
+		}
+	}
+
+	st.dir, err = ioutil.TempDir("", "crio-lxc-selftest")
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("keep") {
+		fmt.Fprintf(os.Stdout, "bundles are kept in %s\n", st.dir)
+	} else {
+		defer os.RemoveAll(st.dir)
+	}
+	// the container's root, mapped or not, has to reach the rootfs
+	if err := os.Chmod(st.dir, 0755); err != nil {
+		return err
+	}
+
+	failed := 0
+	lifecycleFailed := false
+	for _, c := range selftestChecks {
+		reason := ""
+		if lifecycleFailed {
+			reason = "the lifecycle failed"
+		} else if c.unsupported != nil {
+			reason = c.unsupported()
+		}
+		if reason != "" {
+			fmt.Fprintf(os.Stdout, "%-10s skip  %s\n", c.name, reason)
+			continue
+		}
+
+		if err := st.check(c); err != nil {
+			fmt.Fprintf(os.Stdout, "%-10s FAIL  %v\n", c.name, err)
+			failed++
+			lifecycleFailed = c.name == "lifecycle"
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%-10s ok\n", c.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d selftest check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkStaticBinary fails for dynamically linked binaries, which don't
+// run in a rootfs without their libraries.
+func checkStaticBinary(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read '%s'", path)
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return fmt.Errorf("'%s' is dynamically linked, use a static busybox or --image", path)
+		}
+	}
+	return nil
+}
+
+func makeBusyboxRootfs(busybox string, rootfs string) (*imageConfig, error) {
+	for _, dir := range []string{"bin", "dev", "etc", "proc", "root", "sys", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := copyFile(busybox, filepath.Join(rootfs, "bin", "busybox"), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to copy busybox")
+	}
+	for _, applet := range selftestApplets {
+		if err := os.Symlink("busybox", filepath.Join(rootfs, "bin", applet)); err != nil {
+			return nil, err
+		}
+	}
+	files := map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/sh\n",
+		"etc/group":  "root:x:0:\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(rootfs, name), []byte(data), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return &imageConfig{}, nil
+}
+
+// unpackSelftestRootfs unpacks the rootfs built into crio-lxc.
+func unpackSelftestRootfs(rootfs string) (*imageConfig, error) {
+	r, err := gzip.NewReader(strings.NewReader(selftestRootfs))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the built in rootfs")
+	}
+	la := &layerApplier{rootfs: rootfs, rootless: isRootless()}
+	if err := la.apply(r); err != nil {
+		return nil, errors.Wrap(err, "failed to unpack the built in rootfs")
+	}
+	return &imageConfig{}, nil
+}
+
+func unpackSelftestImage(image string, ref string, rootfs string) (*imageConfig, error) {
+	cfg, layers, err := openImage(image, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return nil, err
+	}
+	la := &layerApplier{rootfs: rootfs, rootless: isRootless()}
+	for i, layer := range layers {
+		if err := applyImageLayer(la, layer); err != nil {
+			return nil, errors.Wrapf(err, "failed to unpack layer %d", i)
+		}
+	}
+	return cfg, nil
+}
+
+// check runs the container of c through its lifecycle.
+func (st *selftest) check(c selftestCheck) error {
+	containerID := fmt.Sprintf("selftest-%s-%d", c.name, os.Getpid())
+	bundle := filepath.Join(st.dir, c.name)
+	rootfs := filepath.Join(bundle, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+	cfg, err := st.makeRootfs(rootfs)
+	if err != nil {
+		return errors.Wrap(err, "failed to make rootfs")
+	}
+	cfg.Config.Entrypoint = nil
+	cfg.Config.Cmd = []string{"/bin/sleep", "3600"}
+	cfg.Config.User = ""
+	spec, err := imageSpec(cfg, rootfs, isRootless())
+	if err != nil {
+		return err
+	}
+	spec.Hostname = containerID
+	spec.Process.Terminal = c.terminal
+	if c.prepare != nil {
+		if err := c.prepare(spec, rootfs); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal spec")
+	}
+	if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), data, 0644); err != nil {
+		return err
+	}
+
+	args := []string{"create", "--bundle", bundle}
+	var console *net.UnixListener
+	if c.terminal {
+		socketPath := filepath.Join(st.dir, c.name+".sock")
+		if console, err = net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"}); err != nil {
+			return errors.Wrap(err, "failed to listen on console socket")
+		}
+		defer console.Close()
+		args = append(args, "--console-socket", socketPath)
+	}
+	if _, err := st.run(append(args, containerID)...); err != nil {
+		return err
+	}
+	deleted := false
+	defer func() {
+		if !deleted {
+			st.run("delete", "--force", containerID)
+		}
+	}()
+
+	if console != nil {
+		if err := receiveSelftestConsole(console); err != nil {
+			return err
+		}
+	}
+	if _, err := st.run("start", containerID); err != nil {
+		return err
+	}
+	state, err := st.waitStatus(containerID, "running")
+	if err != nil {
+		return err
+	}
+	if c.verify != nil {
+		if err := c.verify(state); err != nil {
+			return err
+		}
+	}
+	if _, err := st.run("exec", containerID, "/bin/true"); err != nil {
+		return err
+	}
+	if _, err := st.run("kill", "--signal", "KILL", containerID); err != nil {
+		return err
+	}
+	if _, err := st.waitStatus(containerID, "stopped"); err != nil {
+		return err
+	}
+	if _, err := st.run("delete", containerID); err != nil {
+		return err
+	}
+	deleted = true
+	return nil
+}
+
+// run runs a command of the runtime and returns its output. The output
+// goes to files, not pipes, since the container inherits the stdio of
+// create and would keep a pipe open.
+func (st *selftest) run(args ...string) ([]byte, error) {
+	stdout, err := ioutil.TempFile(st.dir, "stdout")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+	stderr, err := ioutil.TempFile(st.dir, "stderr")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	cmd := exec.Command(st.binary, append(append([]string{}, st.globalArgs...), args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		msg, _ := ioutil.ReadFile(stderr.Name())
+		lines := strings.Split(strings.TrimSpace(string(msg)), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			return nil, fmt.Errorf("%s: %s", args[0], last)
+		}
+		return nil, errors.Wrap(err, args[0])
+	}
+	return ioutil.ReadFile(stdout.Name())
+}
+
+func (st *selftest) waitStatus(containerID string, status string) (*specs.State, error) {
+	deadline := time.Now().Add(selftestTimeout)
+	for {
+		out, err := st.run("state", containerID)
+		if err != nil {
+			return nil, err
+		}
+		state := &specs.State{}
+		if err := json.Unmarshal(out, state); err != nil {
+			return nil, errors.Wrap(err, "failed to parse state")
+		}
+		if state.Status == status {
+			return state, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the container is %s, not %s", state.Status, status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// receiveSelftestConsole checks that create sent a pty master to the
+// console socket.
+func receiveSelftestConsole(l *net.UnixListener) error {
+	// create has sent it by the time it returns
+	if err := l.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		return err
+	}
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return errors.Wrap(err, "create didn't connect to the console socket")
+	}
+	defer conn.Close()
+
+	name := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(name, oob)
+	if err != nil {
+		return errors.Wrap(err, "failed to receive the console")
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return fmt.Errorf("create sent no console")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return fmt.Errorf("create sent no console")
+	}
+	defer unix.Close(fds[0])
+	if _, err := unix.IoctlGetTermios(fds[0], unix.TCGETS); err != nil {
+		return fmt.Errorf("the console create sent is not a terminal")
+	}
+	return nil
+}
+
+func selftestUserNSUnsupported() string {
+	if _, err := checkUserNamespaces(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// prepareSelftestUserNS maps root to selftestIDBase. Rootless containers
+// have a user namespace already.
+func prepareSelftestUserNS(spec *specs.Spec, rootfs string) error {
+	if len(spec.Linux.UIDMappings) > 0 {
+		return nil
+	}
+	spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+	spec.Linux.UIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: selftestIDBase, Size: selftestIDRange}}
+	spec.Linux.GIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: selftestIDBase, Size: selftestIDRange}}
+	return filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st := info.Sys().(*unix.Stat_t)
+		return os.Lchown(path, selftestIDBase+int(st.Uid), selftestIDBase+int(st.Gid))
+	})
+}
+
+func selftestCgroup2Unsupported() string {
+	layout, err := cgroupLayout()
+	if err != nil {
+		return err.Error()
+	}
+	if layout != "unified" {
+		return fmt.Sprintf("the cgroup layout is %s", layout)
+	}
+	return ""
+}
+
+// selftestMemoryHigh is the memory reservation of the cgroup v2 test,
+// which becomes memory.high.
+const selftestMemoryHigh = 64 << 20
+
+// prepareSelftestCgroup2 sets a memory reservation, cri-o's memory
+// request.
+func prepareSelftestCgroup2(spec *specs.Spec, rootfs string) error {
+	reservation := int64(selftestMemoryHigh)
+	spec.Linux.Resources = &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{Reservation: &reservation},
+	}
+	return nil
+}
+
+// verifySelftestCgroup2 reads memory.high back from the cgroup of the
+// container, or the first of its parents to set it, since the container
+// may run in a cgroup below the one lxc limits.
+func verifySelftestCgroup2(state *specs.State) error {
+	cgroups, err := procCgroups(state.Pid)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the cgroup of the container")
+	}
+	cgroup, ok := cgroups[""]
+	if !ok {
+		return fmt.Errorf("the container is in no cgroup v2")
+	}
+	want := strconv.Itoa(selftestMemoryHigh)
+	for dir := filepath.Join("/sys/fs/cgroup", cgroup); dir != "/sys/fs/cgroup"; dir = filepath.Dir(dir) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "memory.high"))
+		if os.IsNotExist(err) {
+			// the memory controller isn't enabled for this cgroup
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read memory.high of the container")
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			continue
+		}
+		if value != want {
+			return fmt.Errorf("memory.high of the container is %s, not %s", value, want)
+		}
+		return nil
+	}
+	return fmt.Errorf("memory.high of the container is max, not %s", want)
+}
+
+func selftestSeccompUnsupported() string {
+	if _, err := checkSeccomp(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func prepareSelftestSeccomp(spec *specs.Spec, rootfs string) error {
+	spec.Annotations[ANNOTATION_SECCOMP] = seccompRuntimeDefault
+	return nil
+}
+
+func verifySelftestSeccomp(state *specs.State) error {
+	if applied := state.Annotations[ANNOTATION_SECCOMP_APPLIED]; applied != seccompRuntimeDefault {
+		return fmt.Errorf("the container has seccomp profile '%s', not %s", applied, seccompRuntimeDefault)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !selftest_rootfs
+// +build !selftest_rootfs
+
+package main
+
+// selftestRootfs is empty without the selftest_rootfs tag, selftest then
+// needs --busybox or --image.
+const selftestRootfs = ""
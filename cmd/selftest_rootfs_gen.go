@@ -0,0 +1,96 @@
+//go:build ignore
+// +build ignore
+
+// selftest_rootfs_gen makes the rootfs selftest runs its containers in of
+// a statically linked busybox, and writes it as a gzipped tar to the Go
+// source selftest_rootfs.go, which builds with the selftest_rootfs tag.
+//
+//	go run selftest_rootfs_gen.go /path/to/busybox selftest_rootfs.go
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var applets = []string{"sh", "sleep", "true", "cat"}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: selftest_rootfs_gen BUSYBOX OUTPUT")
+		os.Exit(1)
+	}
+	if err := generate(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(busybox string, output string) error {
+	f, err := elf.Open(busybox)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return fmt.Errorf("%s is dynamically linked", busybox)
+		}
+	}
+	data, err := ioutil.ReadFile(busybox)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	// fixed times, so that the output only changes with busybox
+	mtime := time.Unix(0, 0)
+	for _, dir := range []string{"bin", "dev", "etc", "proc", "root", "sys", "tmp"} {
+		hdr := &tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	files := []struct {
+		name string
+		mode int64
+		data []byte
+	}{
+		{"bin/busybox", 0755, data},
+		{"etc/passwd", 0644, []byte("root:x:0:0:root:/root:/bin/sh\n")},
+		{"etc/group", 0644, []byte("root:x:0:\n")},
+	}
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: file.mode, Size: int64(len(file.data)), ModTime: mtime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	for _, applet := range applets {
+		hdr := &tar.Header{Name: "bin/" + applet, Typeflag: tar.TypeSymlink, Linkname: "busybox", Mode: 0777, ModTime: mtime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	src := fmt.Sprintf("// Code generated by selftest_rootfs_gen.go from %s. DO NOT EDIT.\n\n"+
+		"//go:build selftest_rootfs\n// +build selftest_rootfs\n\npackage main\n\nconst selftestRootfs = %q\n", busybox, buf.String())
+	return ioutil.WriteFile(output, []byte(src), 0644)
+}
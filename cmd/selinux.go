@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Bind mounts with the z or Z option get their source relabeled before
+// the container starts, so that a container confined by SELinux can use
+// them: Z with the spec's mount label, private to the container, and z
+// with the label stripped of its MCS categories, shared by all containers.
+// The options are removed from the lxc mount entries.
+
+const selinuxXattr = "security.selinux"
+
+// selinuxRelabelDenied can't be relabeled, doing so would break the host.
+var selinuxRelabelDenied = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib64",
+	"/proc", "/root", "/run", "/sbin", "/sys", "/usr", "/var",
+}
+
+func selinuxEnabled() bool {
+	var st unix.Statfs_t
+	return unix.Statfs("/sys/fs/selinux", &st) == nil && st.Type == unix.SELINUX_MAGIC
+}
+
+// relabelOption returns "z", "Z" or "" for a mount.
+func relabelOption(ms specs.Mount) string {
+	relabel := ""
+	for _, opt := range ms.Options {
+		if opt == "z" || opt == "Z" {
+			relabel = opt
+		}
+	}
+	return relabel
+}
+
+// withoutRelabelOptions returns options without z and Z.
+func withoutRelabelOptions(options []string) []string {
+	out := []string{}
+	for _, opt := range options {
+		if opt != "z" && opt != "Z" {
+			out = append(out, opt)
+		}
+	}
+	return out
+}
+
+// sharedLabel replaces the level of label with s0, e.g.
+// system_u:object_r:container_file_t:s0:c1,c2 becomes
+// system_u:object_r:container_file_t:s0.
+func sharedLabel(label string) (string, error) {
+	parts := strings.SplitN(label, ":", 4)
+	if len(parts) < 3 {
+		return "", fmt.Errorf("invalid selinux label %q", label)
+	}
+	return strings.Join(parts[:3], ":") + ":s0", nil
+}
+
+// relabel sets the label of path and everything below it, without
+// following symlinks.
+func relabel(path string, label string) error {
+	for _, denied := range selinuxRelabelDenied {
+		if filepath.Clean(path) == denied {
+			return fmt.Errorf("relabeling %s is not allowed", path)
+		}
+	}
+	buf := make([]byte, 256)
+	return filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if n, err := unix.Lgetxattr(p, selinuxXattr, buf); err == nil && strings.TrimRight(string(buf[:n]), "\x00") == label {
+			return nil
+		}
+		if err := unix.Lsetxattr(p, selinuxXattr, []byte(label), 0); err != nil {
+			return errors.Wrapf(err, "failed to relabel %s", p)
+		}
+		return nil
+	})
+}
+
+// relabelMounts relabels the sources of the bind mounts that ask for it.
+func relabelMounts(spec *specs.Spec) error {
+	mountLabel := ""
+	if spec.Linux != nil {
+		mountLabel = spec.Linux.MountLabel
+	}
+	for _, ms := range spec.Mounts {
+		option := relabelOption(ms)
+		if option == "" || !isBindMount(ms) {
+			continue
+		}
+		if !selinuxEnabled() {
+			log.Debugf("selinux is disabled, not relabeling %s", ms.Source)
+			return nil
+		}
+		if mountLabel == "" {
+			log.Warnf("no mount label in the spec, not relabeling %s", ms.Source)
+			continue
+		}
+		label := mountLabel
+		if option == "z" {
+			var err error
+			label, err = sharedLabel(mountLabel)
+			if err != nil {
+				return err
+			}
+		}
+		log.Debugf("relabeling %s to %s", ms.Source, label)
+		if err := relabel(ms.Source, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
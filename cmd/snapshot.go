@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// Snapshots and clones are made by liblxc and need the container to be
+// stopped, so that the rootfs doesn't change while it is copied. Clones
+// are stopped containers too, for the lxc tools or to be snapshotted
+// again.
+
+var snapshotCmd = cli.Command{
+	Name:   "snapshot",
+	Usage:  "snapshots the rootfs and config of a stopped container",
+	Action: doSnapshot,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to snapshot
+`,
+}
+
+var cloneCmd = cli.Command{
+	Name:   "clone",
+	Usage:  "copies a stopped container, with its rootfs, to a new container",
+	Action: doClone,
+	ArgsUsage: `<containerID> <newID>
+
+<containerID> is the ID of the container to copy
+<newID> is the ID of the new container
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "overlay",
+			Usage: "make the new rootfs an overlay of the old one instead of a copy",
+		},
+	},
+}
+
+// loadStoppedContainer loads a container that must exist and be stopped.
+func loadStoppedContainer(ctx *cli.Context, containerID string, op string) (*lxc.Container, error) {
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return nil, errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load container")
+	}
+	if err := configureLogging(ctx, c); err != nil {
+		c.Release()
+		return nil, errors.Wrap(err, "failed to configure logging")
+	}
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		c.Release()
+		return nil, errors.Wrap(err, "failed to check if container is running")
+	}
+	if running {
+		c.Release()
+		return nil, errRunning(containerID, "cannot "+op)
+	}
+	return c, nil
+}
+
+func doSnapshot(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "snapshot", 1)
+	}
+
+	c, err := loadStoppedContainer(ctx, containerID, "snapshot")
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	snap, err := c.CreateSnapshot()
+	if err != nil {
+		return errors.Wrap(err, "failed to snapshot container")
+	}
+	fmt.Fprintf(os.Stdout, "%s %s\n", snap.Name, snap.Path)
+	return nil
+}
+
+func doClone(ctx *cli.Context) (retErr error) {
+	containerID := ctx.Args().Get(0)
+	newID := ctx.Args().Get(1)
+	if len(containerID) == 0 || len(newID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID or new ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "clone", 1)
+	}
+
+	c, err := loadStoppedContainer(ctx, containerID, "clone")
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	if err := validateContainerID(newID); err != nil {
+		return err
+	}
+	lock, err := lockContainer(newID)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	exists, err := containerExists(newID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if exists {
+		return errExists(newID)
+	}
+	if err := checkIDCollision(newID); err != nil {
+		return err
+	}
+	cloned := false
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if cloned {
+			destroyClone(newID)
+		}
+		os.RemoveAll(runtimeDir(newID))
+	}()
+
+	opts := lxc.DefaultCloneOptions
+	opts.ConfigPath = LXC_PATH
+	opts.Backend = lxc.Directory
+	if ctx.Bool("overlay") {
+		opts.Backend = lxc.Overlayfs
+		opts.Snapshot = true
+	}
+	if err := c.Clone(newID, opts); err != nil {
+		return errors.Wrap(err, "failed to clone container")
+	}
+	cloned = true
+	if err := rewriteClonedConfig(containerID, newID); err != nil {
+		return errors.Wrap(err, "failed to update the config of the clone")
+	}
+
+	// crio-lxc's own state, without what belongs to the old container's
+	// processes
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container metadata")
+	}
+	clone := &containerMetadata{Bundle: md.Bundle, Annotations: md.Annotations, OCIVersion: md.OCIVersion}
+	if err := writeMetadata(newID, clone); err != nil {
+		return errors.Wrap(err, "failed to save container metadata")
+	}
+	env, err := ioutil.ReadFile(envFilePath(containerID))
+	if err != nil {
+		return errors.Wrap(err, "failed to read environment file")
+	}
+	if err := writeFileAtomic(envFilePath(newID), env, 0600); err != nil {
+		return errors.Wrap(err, "failed to write environment file")
+	}
+	// the files the config refers to
+	for _, dir := range []string{syncDirName, notifyDirName, hooksDirName} {
+		if err := copyDir(runtimePath(containerID, dir), runtimePath(newID, dir)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to copy %s", dir)
+		}
+	}
+	return nil
+}
+
+// rewriteClonedConfig points the paths in the clone's config that are in
+// the dirs of the old container to the clone's dirs. liblxc only updates
+// the rootfs, the name and its own paths.
+func rewriteClonedConfig(containerID string, newID string) error {
+	path := lxcPath(newID, configFileName)
+	items, err := readLXCConfig(path)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		for _, dir := range [][2]string{
+			{runtimeDir(containerID), runtimeDir(newID)},
+			{lxcDir(containerID), lxcDir(newID)},
+		} {
+			items[i].Value = replacePath(items[i].Value, dir[0], dir[1])
+		}
+	}
+	cfg := &lxcConfig{items: items}
+	return cfg.Write(path)
+}
+
+// replacePath replaces from with to in value where from is a whole path
+// or the start of one, so that the dir of c1 doesn't match that of c10.
+func replacePath(value string, from string, to string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(value, from)
+		if i < 0 {
+			b.WriteString(value)
+			return b.String()
+		}
+		end := i + len(from)
+		b.WriteString(value[:i])
+		if end == len(value) || value[end] == '/' || value[end] == ' ' {
+			b.WriteString(to)
+		} else {
+			b.WriteString(from)
+		}
+		value = value[end:]
+	}
+}
+
+// destroyClone removes a clone that couldn't be set up completely.
+func destroyClone(newID string) {
+	c, err := lxc.NewContainer(newID, LXC_PATH)
+	if err == nil {
+		err = c.Destroy()
+		c.Release()
+	}
+	if err != nil {
+		log.Warnf("failed to destroy clone %s: %v", newID, err)
+		os.RemoveAll(lxcDir(newID))
+	}
+}
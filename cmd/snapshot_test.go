@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestReplacePath(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/run/c1", "/run/c2"},
+		{"/run/c1/sync crio-lxc-sync none bind,create=dir 0 0", "/run/c2/sync crio-lxc-sync none bind,create=dir 0 0"},
+		{"/run/c10/sync", "/run/c10/sync"},
+		{"/run/c1.old", "/run/c1.old"},
+		{"/run/c1/a /run/c1/b", "/run/c2/a /run/c2/b"},
+		{"/run/c10 /run/c1", "/run/c10 /run/c2"},
+		{"c1", "c1"},
+	}
+	for _, tt := range tests {
+		if got := replacePath(tt.value, "/run/c1", "/run/c2"); got != tt.want {
+			t.Errorf("replacePath(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -2,9 +2,8 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/apex/log"
 	//	"github.com/opencontainers/runtime-spec/specs-go"
@@ -37,29 +36,41 @@ func doStart(ctx *cli.Context) error {
 		return errors.Wrap(err, "failed to load container")
 	}
 	defer c.Release()
-	log.Infof("checking if running") // TODO: this will hang
-	if c.Running() {
-		return fmt.Errorf("'%s' is already running", containerID)
+	// A created container's init is alive, blocked on the sync socket.
+	running, err := containerRunning(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container is running")
+	}
+	if !running {
+		return errNotRunning(containerID, "it exited or was never created")
 	}
-	log.Infof("not running, can start")
-	fifoPath := filepath.Join(LXC_PATH, containerID, "syncfifo")
-	fifoExists, err := pathExists(fifoPath)
+	socketExists, err := pathExists(syncSocketPath(containerID))
 	if err != nil {
-		return errors.Wrap(err, "failed to check path existence of init fifo")
+		return errors.Wrap(err, "failed to check path existence of sync socket")
 	}
-	if !fifoExists {
-		return fmt.Errorf("sync fifo '%s' not found.", fifoPath)
+	if !socketExists {
+		return fmt.Errorf("'%s' is already started", containerID)
 	}
-	log.Infof("opening fifo '%s'", fifoPath)
-	f, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+
+	started := time.Now()
+	logFile := lxcLogFile(ctx, containerID)
+	logOffset := lxcLogOffset(logFile)
+	sp := startSpan("sync")
+	err = syncStart(containerID)
+	sp.End(err)
 	if err != nil {
-		return errors.Wrap(err, "failed to open sync fifo")
+		err = withLXCErrors(err, logFile, logOffset)
+		recordTimings(containerID, "start", started, err)
+		return err
 	}
-	log.Infof("opened fifo, reading")
-	data, err := ioutil.ReadAll(f)
+	// poststart hooks can't fail the start, the container runs already
+	sp = startSpan("poststart hooks")
+	err = runOCIHooks(containerID, "poststart")
+	sp.End(err)
 	if err != nil {
-		return errors.Wrap(err, "failed to read from sync fifo")
+		log.Warnf("%v", err)
 	}
-	log.Infof("read '%s' from fifo, done", data)
+	recordTimings(containerID, "start", started, nil)
+	notifyState(ctx, containerID, "running")
 	return nil
 }
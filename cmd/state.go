@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strconv"
+	"time"
 
 	//	"github.com/apex/log"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -22,7 +23,19 @@ var stateCmd = cli.Command{
 
 <containerID> is the ID of the container you want to know about.
 `,
-	Flags: []cli.Flag{},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "stats",
+			Usage: "include the current memory, CPU and pids usage",
+		},
+	},
+}
+
+// stateWithStats is the OCI state with the usage readings of --stats
+// appended.
+type stateWithStats struct {
+	*specs.State
+	Stats *containerStats `json:"stats,omitempty"`
 }
 
 func doState(ctx *cli.Context) error {
@@ -37,7 +50,7 @@ func doState(ctx *cli.Context) error {
 		return errors.Wrap(err, "failed to check if container exists")
 	}
 	if !exists {
-		return fmt.Errorf("container '%s' not found", containerID)
+		return errNotFound(containerID)
 	}
 
 	c, err := lxc.NewContainer(containerID, LXC_PATH)
@@ -51,32 +64,78 @@ func doState(ctx *cli.Context) error {
 
 	}
 
+	s, err := containerState(c, containerID)
+	if err != nil {
+		return err
+	}
+
+	var out interface{} = s
+	if ctx.Bool("stats") {
+		withStats := &stateWithStats{State: s}
+		if s.Pid != 0 {
+			withStats.Stats, err = readContainerStats(s.Pid)
+			if err != nil {
+				return errors.Wrap(err, "failed to read container stats")
+			}
+		}
+		out = withStats
+	}
+
+	stateJson, err := json.Marshal(out)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal json")
+	}
+	os.Stdout.Write(stateJson)
+
+	return nil
+}
+
+func containerState(c *lxc.Container, containerID string) (*specs.State, error) {
 	// TODO need to detect 'created' per
 	// https://github.com/opencontainers/runtime-spec/blob/v1.0.0-rc4/runtime.md#state
 	// it means "the container process has neither exited nor executed the user-specified program"
 	status := "stopped"
-	if c.Running() {
+	pid, err := containerInitPid(c, containerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get container init pid")
+	}
+	if pid != 0 {
 		status = "running"
 	}
-	pid := 0
-	// bundlePath is the enclosing directory of the rootfs:
-	// https://github.com/opencontainers/runtime-spec/blob/v1.0.0-rc4/bundle.md
-	bundlePath := filepath.Dir(c.ConfigItem("lxc.rootfs.path")[0])
-	annotations := map[string]string{}
-	s := specs.State{
-		Version:     CURRENT_OCI_VERSION,
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load container metadata")
+	}
+	// don't modify the persisted map
+	annotations := make(map[string]string, len(md.Annotations)+5)
+	for k, v := range md.Annotations {
+		annotations[k] = v
+	}
+	if md.Seccomp != "" {
+		annotations[ANNOTATION_SECCOMP_APPLIED] = md.Seccomp
+	}
+	if md.AppArmor != "" {
+		annotations[ANNOTATION_APPARMOR_APPLIED] = md.AppArmor
+	}
+	if md.OOMKilled {
+		annotations[ANNOTATION_OOM_KILLED] = "true"
+	}
+	if status == "stopped" {
+		exit, err := readExitStatus(containerID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read exit status")
+		}
+		if exit != nil {
+			annotations[ANNOTATION_EXIT_CODE] = strconv.Itoa(exit.ExitCode)
+			annotations[ANNOTATION_FINISHED_AT] = time.Unix(0, exit.FinishedAt).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return &specs.State{
+		Version:     md.stateVersion(),
 		ID:          containerID,
 		Status:      status,
 		Pid:         pid,
-		Bundle:      bundlePath,
+		Bundle:      md.Bundle,
 		Annotations: annotations,
-	}
-
-	stateJson, err := json.Marshal(s)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal json")
-	}
-	fmt.Fprintf(os.Stdout, string(stateJson))
-
-	return nil
+	}, nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/urfave/cli"
+)
+
+// With --state-socket, state transitions are pushed to a unix socket as
+// json lines, one connection per transition, so that cri-o or a node
+// agent doesn't have to poll state. created and running are sent by
+// create, restore and start, stopped by the container's monitor, see
+// notify_stopped in internal.go, which gets the socket from
+// stateSocketEnv, see monitorEnv.
+
+const (
+	stateSocketEnv     = "CRIO_LXC_STATE_SOCKET"
+	stateNotifyTimeout = time.Second
+)
+
+// stateNotification is the message for a transition.
+type stateNotification struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Pid    int    `json:"pid,omitempty"`
+	// ExitCode is only set for stopped.
+	ExitCode *int      `json:"exitCode,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// notifyState pushes a transition. Failures are only logged, the receiver
+// may be down.
+func notifyState(ctx *cli.Context, containerID string, status string) {
+	socket := ctx.GlobalString("state-socket")
+	if socket == "" {
+		return
+	}
+	n := stateNotification{ID: containerID, Status: status, Time: time.Now().UTC()}
+	if md, err := readMetadata(containerID); err == nil {
+		n.Pid = md.InitPid
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		log.Warnf("failed to marshal state notification: %v", err)
+		return
+	}
+	conn, err := net.DialTimeout("unix", socket, stateNotifyTimeout)
+	if err != nil {
+		log.Warnf("failed to connect to state socket: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(stateNotifyTimeout))
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		log.Warnf("failed to send state notification: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// containerStats are the current resource usage readings of a container's
+// cgroup. Readings that aren't available are left out.
+type containerStats struct {
+	MemoryBytes  uint64 `json:"memoryBytes,omitempty"`
+	CPUUsageNsec uint64 `json:"cpuUsageNsec,omitempty"`
+	Pids         uint64 `json:"pids,omitempty"`
+	// Pressure is the pressure stall information by resource, only
+	// available with cgroup v2.
+	Pressure map[string]*psiStats `json:"pressure,omitempty"`
+}
+
+func readCgroupUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCgroupStat returns a value from a flat keyed file like cpu.stat.
+func readCgroupStat(path string, key string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
+
+// v1CgroupDir returns the directory of pid's cgroup in the legacy
+// hierarchy with the controller, "" if there is none.
+func v1CgroupDir(cgroups map[string]string, controller string) string {
+	for controllers, path := range cgroups {
+		for _, c := range strings.Split(controllers, ",") {
+			if c == controller {
+				return filepath.Join("/sys/fs/cgroup", controllers, path)
+			}
+		}
+	}
+	return ""
+}
+
+// readContainerStats reads the usage of the cgroup the container init is in.
+func readContainerStats(initPid int) (*containerStats, error) {
+	cgroups, err := procCgroups(initPid)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := cgroupLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &containerStats{}
+	if layout == "unified" {
+		dir := filepath.Join("/sys/fs/cgroup", cgroups[""])
+		stats.MemoryBytes, _ = readCgroupUint(filepath.Join(dir, "memory.current"))
+		if usec, err := readCgroupStat(filepath.Join(dir, "cpu.stat"), "usage_usec"); err == nil {
+			stats.CPUUsageNsec = usec * 1000
+		}
+		stats.Pids, _ = readCgroupUint(filepath.Join(dir, "pids.current"))
+		stats.Pressure = readCgroupPressure(dir)
+		return stats, nil
+	}
+
+	if dir := v1CgroupDir(cgroups, "memory"); dir != "" {
+		stats.MemoryBytes, _ = readCgroupUint(filepath.Join(dir, "memory.usage_in_bytes"))
+	}
+	if dir := v1CgroupDir(cgroups, "cpuacct"); dir != "" {
+		stats.CPUUsageNsec, _ = readCgroupUint(filepath.Join(dir, "cpuacct.usage"))
+	}
+	if dir := v1CgroupDir(cgroups, "pids"); dir != "" {
+		stats.Pids, _ = readCgroupUint(filepath.Join(dir, "pids.current"))
+	}
+	return stats, nil
+}
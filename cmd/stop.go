@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// stopSignalAnnotation is the image's stop signal, as podman and cri-o
+// pass it on.
+const stopSignalAnnotation = "org.opencontainers.image.stopSignal"
+
+var stopCmd = cli.Command{
+	Name:   "stop",
+	Usage:  "stops a container, with SIGKILL if it doesn't exit in time",
+	Action: doStop,
+	ArgsUsage: `[containerID]
+
+<containerID> is the ID of the container to stop
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal",
+			Usage: "the signal to stop with (default: the image's stop signal or TERM)",
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "seconds to wait for the container to exit before sending SIGKILL",
+			Value: 10,
+		},
+	},
+}
+
+// parseSignal accepts "TERM", "SIGTERM" and "15".
+func parseSignal(name string) (unix.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 && n < 65 {
+		return unix.Signal(n), nil
+	}
+	sig, ok := signalMap[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal '%s'", name)
+	}
+	return sig, nil
+}
+
+// stopSignal returns the signal of the flag, or else of the image.
+func stopSignal(ctx *cli.Context, containerID string) (unix.Signal, error) {
+	name := "TERM"
+	if ctx.IsSet("signal") {
+		name = ctx.String("signal")
+	} else if md, err := readMetadata(containerID); err == nil && md.Annotations[stopSignalAnnotation] != "" {
+		name = md.Annotations[stopSignalAnnotation]
+	}
+	return parseSignal(name)
+}
+
+// waitExitStatus waits for the monitor to record the exit status, so that
+// state reports it once stop returned.
+func waitExitStatus(containerID string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if st, err := readExitStatus(containerID); err != nil || st != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	log.Warnf("no exit status was recorded for container '%s'", containerID)
+}
+
+func doStop(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "stop", 1)
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	sig, err := stopSignal(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	pid, err := containerInitPid(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get container init pid")
+	}
+	// stopping a stopped container is not an error
+	if pid == 0 {
+		return nil
+	}
+
+	log.Infof("stopping container '%s' with %s", containerID, sig.String())
+	sp := startSpan("signal")
+	sp.attrs["signal"] = sig.String()
+	err = unix.Kill(pid, sig)
+	sp.End(err)
+	if err != nil && err != unix.ESRCH {
+		return errors.Wrap(err, "failed to send signal")
+	}
+
+	if err := escalateKill(c, containerID, pid, time.Duration(ctx.Int("timeout"))*time.Second); err != nil {
+		return err
+	}
+	waitExitStatus(containerID, forceStopTimeout)
+	return nil
+}
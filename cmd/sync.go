@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/lxc/crio-lxc/cmd/internal/initsync"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// The container init is crio-lxc-init, which blocks on a unix socket in the
+// container's sync dir until start connects to it, see the initsync
+// package. The socket is bound by create and exists until start.
+
+const (
+	initBinaryName = "crio-lxc-init"
+	// syncSocketTimeout bounds how long create waits for the init to
+	// answer on the sync socket.
+	syncSocketTimeout = 10 * time.Second
+)
+
+func syncDir(containerID string) string {
+	return runtimePath(containerID, syncDirName)
+}
+
+func syncSocketPath(containerID string) string {
+	return filepath.Join(syncDir(containerID), initsync.SocketName)
+}
+
+// initBinary finds crio-lxc-init next to the running binary or in $PATH.
+func initBinary() (string, error) {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(filepath.Dir(self), initBinaryName)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return exec.LookPath(initBinaryName)
+}
+
+// makeSyncDir creates the sync dir and writes the process args for the
+// init to it, with the fd the init gets the sync socket as. The mount
+// point of the init binary is created too, the sync dir is mounted
+// read-only. Leftovers from an earlier failed create are removed.
+func makeSyncDir(containerID string, spec *specs.Spec, socketFd int) error {
+	dir := syncDir(containerID)
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed to remove stale sync dir '%s'", dir)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create sync dir '%s'", dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, initsync.InitName), nil, 0600); err != nil {
+		return errors.Wrap(err, "failed to create init mount point")
+	}
+	data, err := json.Marshal(&initsync.Args{Args: spec.Process.Args, SocketFd: socketFd})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal process args")
+	}
+	return writeFileAtomic(filepath.Join(dir, initsync.ArgsName), data, 0600)
+}
+
+// configureSync mounts the sync dir and the init binary into the container
+// and makes the init binary the command lxc executes.
+func configureSync(cfg *lxcConfig, containerID string) error {
+	init, err := initBinary()
+	if err != nil {
+		return errors.Wrapf(err, "failed to find %s", initBinaryName)
+	}
+
+	mnt := fmt.Sprintf("%s %s none ro,bind,create=dir 0 0", syncDir(containerID), initsync.DirInContainer[1:])
+	if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
+		return errors.Wrap(err, "failed to set sync dir mount config entry")
+	}
+	mnt = fmt.Sprintf("%s %s none ro,bind 0 0", init, initsync.InitPath[1:])
+	if err := cfg.Set("lxc.mount.entry", mnt); err != nil {
+		return errors.Wrap(err, "failed to set init mount config entry")
+	}
+	return cfg.Set("lxc.execute.cmd", initsync.InitPath)
+}
+
+// listenSyncSocket binds the sync socket and returns it as a file, to be
+// passed to the init.
+func listenSyncSocket(containerID string) (*os.File, error) {
+	socketPath := syncSocketPath(containerID)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on sync socket '%s'", socketPath)
+	}
+	// the socket file's existence means "created", it must outlive the
+	// listener of this process
+	l.SetUnlinkOnClose(false)
+	defer l.Close()
+	return l.File()
+}
+
+// waitSyncSocket waits for the container init to answer on the sync
+// socket.
+func waitSyncSocket(containerID string) error {
+	socketPath := syncSocketPath(containerID)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to sync socket '%s'", socketPath)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syncSocketTimeout))
+
+	if _, err := conn.Write([]byte(initsync.Ping)); err != nil {
+		return errors.Wrap(err, "failed to ping container init")
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != initsync.Ping {
+		return fmt.Errorf("container init didn't answer on '%s': %q, %v", socketPath, line, err)
+	}
+	return nil
+}
+
+// syncStart tells the container init to exec the container process. An
+// immediate EOF means the exec succeeded, otherwise the init sends an
+// InitError.
+func syncStart(containerID string) error {
+	socketPath := syncSocketPath(containerID)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to sync socket '%s'", socketPath)
+	}
+	defer conn.Close()
+	// the container is started from here on
+	if err := os.Remove(socketPath); err != nil {
+		return errors.Wrapf(err, "failed to remove sync socket '%s'", socketPath)
+	}
+
+	if _, err := conn.Write([]byte(initsync.Start)); err != nil {
+		return errors.Wrap(err, "failed to send start")
+	}
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return errors.Wrap(err, "failed to read from sync socket")
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	ie := &initsync.InitError{}
+	if err := json.Unmarshal(data, ie); err != nil {
+		return fmt.Errorf("container init failed: %s", data)
+	}
+	return ie
+}
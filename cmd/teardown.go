@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// containerCgroupDir returns the cgroup directory the container init is in,
+// in the unified hierarchy if there is one, otherwise in the freezer or
+// pids hierarchy.
+func containerCgroupDir(pid int) (string, error) {
+	cgroups, err := procCgroups(pid)
+	if err != nil {
+		return "", err
+	}
+	layout, err := cgroupLayout()
+	if err != nil {
+		return "", err
+	}
+	if layout == "unified" {
+		return filepath.Join("/sys/fs/cgroup", cgroups[""]), nil
+	}
+	for _, controller := range []string{"freezer", "pids"} {
+		if dir := v1CgroupDir(cgroups, controller); dir != "" {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no freezer or pids cgroup for pid %d", pid)
+}
+
+// cgroupProcs lists the processes in dir and all cgroups below it.
+func cgroupProcs(dir string) ([]int, error) {
+	pids := []int{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// cgroups may go away while we walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || fi.Name() != "cgroup.procs" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			pid, err := strconv.Atoi(scanner.Text())
+			if err != nil {
+				continue
+			}
+			pids = append(pids, pid)
+		}
+		return scanner.Err()
+	})
+	return pids, err
+}
+
+// killAll signals every process of the container. The container is frozen
+// while the processes are listed and signalled, so that nothing can fork
+// past the kill and escape onto the host.
+func killAll(c *lxc.Container, initPid int, sig unix.Signal) error {
+	dir, err := containerCgroupDir(initPid)
+	if err != nil {
+		return errors.Wrap(err, "failed to find container cgroup")
+	}
+
+	frozen := true
+	if err := c.Freeze(); err != nil {
+		log.Warnf("failed to freeze container, signalling without freezing: %v", err)
+		frozen = false
+	}
+
+	pids, err := cgroupProcs(dir)
+	if err == nil {
+		for _, pid := range pids {
+			if kerr := unix.Kill(pid, sig); kerr != nil && kerr != unix.ESRCH {
+				err = errors.Wrapf(kerr, "failed to signal pid %d", pid)
+				break
+			}
+		}
+	} else {
+		err = errors.Wrap(err, "failed to list container processes")
+	}
+
+	// frozen tasks only act on the signal once thawed
+	if frozen {
+		if uerr := c.Unfreeze(); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, "failed to thaw container")
+		}
+	}
+	return err
+}
+
+// forceStopTimeout is how long to wait for the container to die after
+// SIGKILL.
+const forceStopTimeout = 10 * time.Second
+
+// escalateKill waits up to timeout for the container to exit after it was
+// signalled and then kills all of its processes.
+func escalateKill(c *lxc.Container, containerID string, initPid int, timeout time.Duration) error {
+	stopped, err := waitStopped(c, containerID, timeout)
+	if err != nil {
+		return err
+	}
+	if stopped {
+		return nil
+	}
+
+	log.Infof("container '%s' still running after %s, sending SIGKILL", containerID, timeout)
+	sp := startSpan("kill all")
+	err = killAll(c, initPid, unix.SIGKILL)
+	sp.End(err)
+	if err != nil {
+		return errors.Wrap(err, "failed to kill container")
+	}
+	stopped, err = waitStopped(c, containerID, forceStopTimeout)
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return fmt.Errorf("container '%s' still running %s after SIGKILL", containerID, forceStopTimeout)
+	}
+	return nil
+}
+
+// waitStopped polls until the container init exited, up to timeout.
+func waitStopped(c *lxc.Container, containerID string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := containerRunning(c, containerID)
+		if err != nil {
+			return false, err
+		}
+		if !running {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
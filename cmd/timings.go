@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// The durations of the steps of create and start, and of the lxc hooks,
+// are kept in the runtime dir and shown by inspect, so that slow starts
+// can be looked into without tracing. The steps are the spans of the
+// operation, which are recorded whether tracing is enabled or not.
+
+type stepTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type operationTimings struct {
+	Started time.Time    `json:"started"`
+	Seconds float64      `json:"seconds"`
+	Error   string       `json:"error,omitempty"`
+	Steps   []stepTiming `json:"steps,omitempty"`
+}
+
+// containerTimings are keyed by operation, hooks by "hook <type> <path>".
+type containerTimings map[string]*operationTimings
+
+func timingsPath(containerID string) string {
+	return runtimePath(containerID, timingsFileName)
+}
+
+func readTimings(containerID string) (containerTimings, error) {
+	timings := containerTimings{}
+	data, err := ioutil.ReadFile(timingsPath(containerID))
+	if os.IsNotExist(err) {
+		return timings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &timings); err != nil {
+		return nil, errors.Wrap(err, "invalid timings")
+	}
+	return timings, nil
+}
+
+// updateTimings sets the timings of an operation. Hooks record their
+// timings while create holds the container lock, so the file has a lock
+// of its own.
+func updateTimings(containerID string, operation string, t *operationTimings) error {
+	lock, err := os.OpenFile(timingsPath(containerID)+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	timings, err := readTimings(containerID)
+	if err != nil {
+		return err
+	}
+	timings[operation] = t
+	data, err := json.Marshal(timings)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(timingsPath(containerID), data, 0600)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// recordTimings records the spans of the operation started at started.
+// Failures are only logged, the timings are for debugging.
+func recordTimings(containerID string, operation string, started time.Time, opErr error) {
+	t := &operationTimings{
+		Started: started,
+		Seconds: time.Since(started).Seconds(),
+		Error:   errorString(opErr),
+	}
+	for _, s := range tracer.spans {
+		if s == tracer.root || s.end.IsZero() {
+			continue
+		}
+		t.Steps = append(t.Steps, stepTiming{
+			Name:    s.name,
+			Seconds: s.end.Sub(s.start).Seconds(),
+			Error:   errorString(s.err),
+		})
+	}
+	if err := updateTimings(containerID, operation, t); err != nil {
+		log.Debugf("failed to record timings of %s: %v", operation, err)
+	}
+}
+
+var hookTimerCmd = cli.Command{
+	Name:            "hook-timer",
+	Usage:           "internal: run an lxc hook and record its duration",
+	ArgsUsage:       "<hook> [args...]",
+	Hidden:          true,
+	SkipFlagParsing: true,
+	Action:          doHookTimer,
+}
+
+// timedHook returns the lxc.hook value that runs path through hook-timer.
+func timedHook(path string) (string, error) {
+	binary, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return "", err
+	}
+	return binary + " --root " + RUNTIME_ROOT + " hook-timer " + path, nil
+}
+
+// doHookTimer runs the hook with liblxc's arguments and environment and
+// exits with its status.
+func doHookTimer(ctx *cli.Context) error {
+	if !ctx.Args().Present() {
+		return errors.New("missing hook")
+	}
+	path := ctx.Args().First()
+	cmd := exec.Command(path, ctx.Args().Tail()...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	started := time.Now()
+	err := cmd.Run()
+	// liblxc sets LXC_NAME to the container ID
+	if containerID := os.Getenv("LXC_NAME"); containerID != "" {
+		operation := "hook " + os.Getenv("LXC_HOOK_TYPE") + " " + path
+		t := &operationTimings{
+			Started: started,
+			Seconds: time.Since(started).Seconds(),
+			Error:   errorString(err),
+		}
+		if err := updateTimings(containerID, operation, t); err != nil {
+			log.Debugf("failed to record timings of %s: %v", operation, err)
+		}
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return errExitStatus(unix.WaitStatus(exitErr.Sys().(syscall.WaitStatus)))
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// With --tmpfs-state the runtime root and the lxc path are kept on tmpfs,
+// for hosts with a read-only or no root disk. Nothing the runtime writes
+// then survives a reboot, which matches the containers: the container
+// configs, hooks and caches are all written again by the next create.
+// Unless --lxc-path is given, the lxc path moves below the runtime root, so
+// that a read-only /var/lib doesn't need a mount point.
+
+// useTmpfsState makes sure RUNTIME_ROOT and LXC_PATH are on tmpfs, mounting
+// one over an empty directory that isn't.
+func useTmpfsState(ctx *cli.Context) error {
+	if !ctx.IsSet("lxc-path") {
+		LXC_PATH = filepath.Join(RUNTIME_ROOT, "lxc")
+	}
+	for _, dir := range []string{RUNTIME_ROOT, LXC_PATH} {
+		if err := ensureTmpfs(dir, ctx.String("tmpfs-size")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTmpfs(dir string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return false, err
+	}
+	return st.Type == unix.TMPFS_MAGIC || st.Type == unix.RAMFS_MAGIC, nil
+}
+
+func ensureTmpfs(dir string, size string) error {
+	if err := os.MkdirAll(dir, stateDirMode()); err != nil {
+		return errors.Wrapf(err, "failed to create '%s'", dir)
+	}
+	onTmpfs, err := isTmpfs(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat filesystem of '%s'", dir)
+	}
+	if onTmpfs {
+		return nil
+	}
+	if isRootless() {
+		return fmt.Errorf("'%s' is not on tmpfs, and mounting one requires root", dir)
+	}
+
+	// concurrent invocations lock the directory below the mount, and the
+	// ones that get the lock late see the tmpfs
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Wrapf(err, "failed to lock '%s'", dir)
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	if onTmpfs, err := isTmpfs(dir); err != nil || onTmpfs {
+		return err
+	}
+	// the tmpfs would hide what is there, e.g. the state of containers
+	// created without --tmpfs-state
+	if names, err := f.Readdirnames(1); err != io.EOF {
+		if err != nil {
+			return errors.Wrapf(err, "failed to read '%s'", dir)
+		}
+		return fmt.Errorf("'%s' is not on tmpfs and not empty (it has %s), refusing to mount a tmpfs over it", dir, names[0])
+	}
+
+	options := fmt.Sprintf("mode=%o", stateDirMode())
+	if size != "" {
+		options += ",size=" + size
+	}
+	log.Debugf("mounting tmpfs on %s (%s)", dir, options)
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+		return errors.Wrapf(err, "failed to mount tmpfs on '%s'", dir)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Tracing is a minimal OTLP/HTTP json exporter. When an OTLP endpoint is
+// configured through the standard OTEL_EXPORTER_OTLP_* environment
+// variables, every invocation is exported as a trace with one root span for
+// the command and child spans for the interesting steps. A W3C TRACEPARENT
+// in the environment makes the invocation part of the caller's trace.
+
+const otlpExportTimeout = 2 * time.Second
+
+type span struct {
+	name     string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+var tracer struct {
+	// mu guards root and spans, batches start spans concurrently
+	mu       sync.Mutex
+	endpoint string
+	traceID  string
+	root     *span
+	spans    []*span
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func otlpEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// initTracing starts the root span for the command, if tracing is enabled.
+func initTracing(command string, containerID string) {
+	// the daemon runs many commands
+	tracer.root = nil
+	tracer.spans = nil
+	tracer.endpoint = otlpEndpoint()
+	if tracer.endpoint == "" {
+		return
+	}
+
+	tracer.traceID = randomHex(16)
+	parentID := ""
+	// version-traceid-parentid-flags
+	if parts := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		tracer.traceID = parts[1]
+		parentID = parts[2]
+	}
+
+	tracer.root = startSpan(command)
+	tracer.root.parentID = parentID
+	if containerID != "" {
+		tracer.root.attrs["container.id"] = containerID
+	}
+}
+
+// startSpan starts a span below the command's root span. Spans are
+// recorded when tracing is disabled as well, for the timings.
+func startSpan(name string) *span {
+	s := &span{
+		name:   name,
+		spanID: randomHex(8),
+		start:  time.Now(),
+		attrs:  map[string]string{},
+	}
+	if tracer.root != nil {
+		s.parentID = tracer.root.spanID
+	}
+	tracer.mu.Lock()
+	tracer.spans = append(tracer.spans, s)
+	tracer.mu.Unlock()
+	return s
+}
+
+// End finishes the span, recording err as its status.
+func (s *span) End(err error) {
+	s.end = time.Now()
+	s.err = err
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for key, value := range attrs {
+		a := otlpAttribute{Key: key}
+		a.Value.StringValue = value
+		out = append(out, a)
+	}
+	return out
+}
+
+// flushTracing ends the root span and exports all spans, once: create's
+// signal handler flushes before it exits. Export failures are only
+// logged, tracing must never fail a runtime operation.
+func flushTracing(err error) {
+	tracer.mu.Lock()
+	root, started := tracer.root, tracer.spans
+	tracer.root, tracer.spans = nil, nil
+	tracer.mu.Unlock()
+	if tracer.endpoint == "" || root == nil {
+		return
+	}
+	root.End(err)
+
+	var spans []otlpSpan
+	for _, s := range started {
+		if s.end.IsZero() {
+			s.End(fmt.Errorf("span not ended"))
+		}
+		out := otlpSpan{
+			TraceID:           tracer.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: fmt.Sprintf("%d", s.start.UnixNano()),
+			EndTimeUnixNano:   fmt.Sprintf("%d", s.end.UnixNano()),
+			Attributes:        otlpAttributes(s.attrs),
+			Status:            otlpStatus{Code: 1}, // STATUS_CODE_OK
+		}
+		if s.err != nil {
+			out.Status = otlpStatus{Code: 2, Message: s.err.Error()} // STATUS_CODE_ERROR
+		}
+		spans = append(spans, out)
+	}
+
+	resource := map[string]interface{}{
+		"attributes": otlpAttributes(map[string]string{"service.name": "crio-lxc", "service.version": version}),
+	}
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": resource,
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "crio-lxc"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		log.Debugf("failed to marshal trace: %v", err)
+		return
+	}
+	client := &http.Client{Timeout: otlpExportTimeout}
+	resp, err := client.Post(tracer.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Debugf("failed to export trace to %s: %v", tracer.endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Debugf("failed to export trace to %s: %s", tracer.endpoint, resp.Status)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var translateCmd = cli.Command{
+	Name:   "translate",
+	Usage:  "prints the lxc config that create would generate for a bundle",
+	Action: doTranslate,
+	ArgsUsage: `[containerID]
+
+[containerID] is used for paths that depend on the container ID (default "translate")
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle",
+			Usage: "set bundle directory",
+			Value: ".",
+		},
+	},
+}
+
+func doTranslate(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		containerID = "translate"
+	}
+
+	bundle, err := resolveBundle(ctx.String("bundle"))
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve bundle path")
+	}
+
+	spec, err := readBundleSpec(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return errors.Wrap(err, "couldn't load bundle spec")
+	}
+
+	if err := normalizeSpec(spec); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+	if err := checkSeccompErrnoRet(bundle); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+	if err := validateSpec(spec); err != nil {
+		return errors.Wrap(err, "invalid bundle spec")
+	}
+
+	if err := resolveBundlePaths(bundle, spec); err != nil {
+		return errors.Wrap(err, "invalid bundle paths")
+	}
+
+	runtimeCfg, err := loadRuntimeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	runtimeCfg.applyToSpec(spec)
+
+	cfg := &lxcConfig{}
+	if err := configureContainer(ctx, cfg, containerID, spec); err != nil {
+		return errors.Wrap(err, "failed to configure container")
+	}
+	resolveBindCreate(cfg)
+	if err := configureUnified(cfg, bundle); err != nil {
+		return errors.Wrap(err, "failed to configure cgroup v2 resources")
+	}
+	if err := runtimeCfg.configureDevices(cfg, spec); err != nil {
+		return errors.Wrap(err, "failed to configure default devices")
+	}
+	os.Stdout.Write(cfg.Bytes())
+
+	// The environment is not part of the saved config, see writeEnvFile.
+	for _, envVar := range spec.Process.Env {
+		fmt.Fprintf(os.Stdout, "lxc.environment = %s\n", envVar)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// unifiedFileName matches the names of cgroup v2 interface files. They
+// end up in config keys, which must not have room for anything else.
+var unifiedFileName = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_.]+$`)
+
+// validUnifiedFile reports whether a bundle may set a cgroup v2 file, a
+// controller.setting like memory.high. The cgroup core files and device
+// access, which lxc.cgroup2.devices controls with a bpf program, are left
+// to the runtime.
+func validUnifiedFile(file string) bool {
+	return unifiedFileName.MatchString(file) && !strings.HasPrefix(file, "cgroup.") && !strings.HasPrefix(file, "devices.")
+}
+
+// readBundleUnified reads linux.resources.unified, the OCI 1.1 cgroup v2
+// files, from the bundle.
+func readBundleUnified(bundle string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var spec struct {
+		Linux *struct {
+			Resources *struct {
+				Unified map[string]string `json:"unified,omitempty"`
+			} `json:"resources,omitempty"`
+		} `json:"linux,omitempty"`
+	}
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, err
+	}
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return nil, nil
+	}
+	return spec.Linux.Resources.Unified, nil
+}
+
+// configureUnified writes the spec's cgroup v2 files as they are. They
+// are set after the other resources, so they override them, e.g. a
+// cpu.idle of the annotation.
+func configureUnified(cfg *lxcConfig, bundle string) error {
+	unified, err := readBundleUnified(bundle)
+	if err != nil || len(unified) == 0 {
+		return err
+	}
+	if layout, err := cgroupLayout(); err != nil || layout != "unified" {
+		return fmt.Errorf("linux.resources.unified needs the unified cgroup hierarchy")
+	}
+	files := []string{}
+	for file := range unified {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if !validUnifiedFile(file) {
+			return fmt.Errorf("invalid cgroup file %q in linux.resources.unified", file)
+		}
+		if err := cfg.Set("lxc.cgroup2."+file, unified[file]); err != nil {
+			return errors.Wrapf(err, "failed to set %s", file)
+		}
+	}
+	return nil
+}
+
+// configureCPUIdle marks the container's cgroup cpu.idle with the
+// annotation, so it only gets cpu time no other cgroup wants. It needs
+// cgroup v2 and Linux 5.15.
+func configureCPUIdle(cfg *lxcConfig, spec *specs.Spec) error {
+	idle, err := annotationBool(spec, ANNOTATION_CPU_IDLE, false)
+	if err != nil || !idle {
+		return err
+	}
+	if layout, err := cgroupLayout(); err != nil || layout != "unified" {
+		return fmt.Errorf("annotation %s needs the unified cgroup hierarchy", ANNOTATION_CPU_IDLE)
+	}
+	return cfg.Set("lxc.cgroup2.cpu.idle", "1")
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestValidUnifiedFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"memory.high", true},
+		{"io.bfq.weight", true},
+		{"cpu.idle", true},
+		{"memory", false},
+		{"../memory.high", false},
+		{"memory.high\nlxc.rootfs.path", false},
+		{"memory.high = 1", false},
+		{"memory.high=1", false},
+		{".high", false},
+		{"Memory.high", false},
+		{"cgroup.procs", false},
+		{"cgroup.subtree_control", false},
+		{"devices.allow", false},
+		{"devices.deny", false},
+	}
+	for _, tt := range tests {
+		if got := validUnifiedFile(tt.file); got != tt.want {
+			t.Errorf("validUnifiedFile(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// unpack turns an image into a bundle, for using crio-lxc without a
+// container engine. Images are OCI image layouts or docker archives (the
+// output of docker save), either as directories or as tar files. The spec
+// is made from the image config as the image spec's conversion document
+// describes, with the defaults runc spec uses.
+
+var unpackCmd = cli.Command{
+	Name:   "unpack",
+	Usage:  "unpacks an OCI image layout or docker archive into a bundle",
+	Action: doUnpack,
+	ArgsUsage: `<image> <bundle>
+
+<image> is an OCI image layout or docker archive, a directory or tar file
+<bundle> is the directory to create the bundle in
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "ref",
+			Usage: "the image to unpack, by its org.opencontainers.image.ref.name annotation or docker tag, if the image has more than one",
+		},
+	},
+}
+
+const (
+	mediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationImageRefName = "org.opencontainers.image.ref.name"
+	defaultImagePath       = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+type imagePlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type imageDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *imagePlatform    `json:"platform"`
+}
+
+type imageIndex struct {
+	Manifests []imageDescriptor `json:"manifests"`
+}
+
+type imageManifest struct {
+	Config imageDescriptor   `json:"config"`
+	Layers []imageDescriptor `json:"layers"`
+}
+
+// dockerManifest is an entry of the manifest.json of a docker archive.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+type imageConfig struct {
+	Created      string `json:"created"`
+	Author       string `json:"author"`
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		User         string              `json:"User"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Env          []string            `json:"Env"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		Cmd          []string            `json:"Cmd"`
+		Volumes      map[string]struct{} `json:"Volumes"`
+		WorkingDir   string              `json:"WorkingDir"`
+		Labels       map[string]string   `json:"Labels"`
+		StopSignal   string              `json:"StopSignal"`
+	} `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// imageSource opens the files of an image directory or tar file.
+type imageSource interface {
+	open(name string) (io.ReadCloser, error)
+}
+
+type dirImageSource string
+
+func (dir dirImageSource) open(name string) (io.ReadCloser, error) {
+	path, err := securejoin.SecureJoin(string(dir), name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// tarImageSource reads the tar file up to the entry for each file it opens,
+// docker archives aren't big enough for an index to pay off.
+type tarImageSource string
+
+type tarEntry struct {
+	io.Reader
+	f *os.File
+}
+
+func (e *tarEntry) Close() error {
+	return e.f.Close()
+}
+
+func (path tarImageSource) open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Clean("/" + name)
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to read '%s'", path)
+		}
+		if filepath.Clean("/"+hdr.Name) == name && (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) {
+			return &tarEntry{tr, f}, nil
+		}
+	}
+	f.Close()
+	return nil, &os.PathError{Op: "open", Path: string(path) + ":" + name, Err: os.ErrNotExist}
+}
+
+func readImageJSON(src imageSource, name string, v interface{}) error {
+	f, err := src.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to parse '%s'", name)
+	}
+	return nil
+}
+
+// digestReader fails the read that reaches EOF if the data doesn't match
+// the digest.
+type digestReader struct {
+	r      io.Reader
+	h      hash.Hash
+	digest string
+}
+
+func newDigestReader(r io.Reader, digest string) (*digestReader, error) {
+	if !digestRegexp.MatchString(digest) {
+		return nil, fmt.Errorf("unsupported digest '%s'", digest)
+	}
+	return &digestReader{r, sha256.New(), digest}, nil
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF {
+		if actual := "sha256:" + hex.EncodeToString(d.h.Sum(nil)); actual != d.digest {
+			return n, fmt.Errorf("digest mismatch, expected %s, got %s", d.digest, actual)
+		}
+	}
+	return n, err
+}
+
+func blobPath(digest string) (string, error) {
+	if !digestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("unsupported digest '%s'", digest)
+	}
+	return filepath.Join("blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), nil
+}
+
+// openBlob opens a blob of an OCI layout, verifying it as it is read.
+func openBlob(src imageSource, digest string) (io.ReadCloser, error) {
+	path, err := blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := src.open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newDigestReader(f, digest)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+func readBlobJSON(src imageSource, digest string, v interface{}) error {
+	f, err := openBlob(src, digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read blob %s", digest)
+	}
+	return errors.Wrapf(json.Unmarshal(data, v), "failed to parse blob %s", digest)
+}
+
+// selectDescriptor picks the manifest of the running platform if the
+// candidates have platforms, and otherwise requires there to be only one.
+func selectDescriptor(candidates []imageDescriptor) (imageDescriptor, error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	for _, desc := range candidates {
+		if desc.Platform != nil && desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+	if len(candidates) == 0 {
+		return imageDescriptor{}, fmt.Errorf("no image found")
+	}
+	return imageDescriptor{}, fmt.Errorf("%d images found and none for %s/%s, select one with --ref", len(candidates), runtime.GOOS, runtime.GOARCH)
+}
+
+// unpackedLayer is a layer to apply, with the digest its uncompressed
+// content must have if the image config has one.
+type unpackedLayer struct {
+	open   func() (io.ReadCloser, error)
+	diffID string
+}
+
+func ociLayout(src imageSource, ref string) (*imageConfig, []unpackedLayer, error) {
+	index := imageIndex{}
+	if err := readImageJSON(src, "index.json", &index); err != nil {
+		return nil, nil, err
+	}
+	candidates := index.Manifests
+	if ref != "" {
+		candidates = nil
+		for _, desc := range index.Manifests {
+			if desc.Annotations[annotationImageRefName] == ref {
+				candidates = append(candidates, desc)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, nil, fmt.Errorf("no image with ref '%s'", ref)
+		}
+	}
+
+	desc, err := selectDescriptor(candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	// nested indexes are for multi platform images
+	for desc.MediaType == mediaTypeImageIndex || desc.MediaType == mediaTypeDockerList {
+		nested := imageIndex{}
+		if err := readBlobJSON(src, desc.Digest, &nested); err != nil {
+			return nil, nil, err
+		}
+		if desc, err = selectDescriptor(nested.Manifests); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	manifest := imageManifest{}
+	if err := readBlobJSON(src, desc.Digest, &manifest); err != nil {
+		return nil, nil, err
+	}
+	cfg := &imageConfig{}
+	if err := readBlobJSON(src, manifest.Config.Digest, cfg); err != nil {
+		return nil, nil, err
+	}
+	layers := []unpackedLayer{}
+	for _, layer := range manifest.Layers {
+		digest := layer.Digest
+		layers = append(layers, unpackedLayer{open: func() (io.ReadCloser, error) { return openBlob(src, digest) }})
+	}
+	return cfg, layers, nil
+}
+
+func dockerArchive(src imageSource, ref string) (*imageConfig, []unpackedLayer, error) {
+	manifests := []dockerManifest{}
+	if err := readImageJSON(src, "manifest.json", &manifests); err != nil {
+		return nil, nil, err
+	}
+	var manifest *dockerManifest
+	if ref != "" {
+		if !strings.Contains(filepath.Base(ref), ":") {
+			ref += ":latest"
+		}
+		for i := range manifests {
+			for _, tag := range manifests[i].RepoTags {
+				if tag == ref {
+					manifest = &manifests[i]
+				}
+			}
+		}
+		if manifest == nil {
+			return nil, nil, fmt.Errorf("no image tagged '%s'", ref)
+		}
+	} else if len(manifests) == 1 {
+		manifest = &manifests[0]
+	} else {
+		return nil, nil, fmt.Errorf("%d images found, select one with --ref", len(manifests))
+	}
+
+	cfg := &imageConfig{}
+	if err := readImageJSON(src, manifest.Config, cfg); err != nil {
+		return nil, nil, err
+	}
+	layers := []unpackedLayer{}
+	for _, layer := range manifest.Layers {
+		name := layer
+		layers = append(layers, unpackedLayer{open: func() (io.ReadCloser, error) { return src.open(name) }})
+	}
+	return cfg, layers, nil
+}
+
+// openImage reads the image config and layers of an OCI layout or docker
+// archive. Newer docker archives are OCI layouts too, which is preferred.
+func openImage(path string, ref string) (*imageConfig, []unpackedLayer, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var src imageSource = tarImageSource(path)
+	if fi.IsDir() {
+		src = dirImageSource(path)
+	}
+
+	var cfg *imageConfig
+	var layers []unpackedLayer
+	if f, err := src.open("oci-layout"); err == nil {
+		f.Close()
+		cfg, layers, err = ociLayout(src, ref)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid OCI image layout")
+		}
+	} else if f, err := src.open("manifest.json"); err == nil {
+		f.Close()
+		cfg, layers, err = dockerArchive(src, ref)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid docker archive")
+		}
+	} else {
+		return nil, nil, fmt.Errorf("'%s' is neither an OCI image layout nor a docker archive", path)
+	}
+
+	if len(cfg.RootFS.DiffIDs) == len(layers) {
+		for i := range layers {
+			layers[i].diffID = cfg.RootFS.DiffIDs[i]
+		}
+	} else if len(cfg.RootFS.DiffIDs) > 0 {
+		return nil, nil, fmt.Errorf("image config has %d diff_ids for %d layers", len(cfg.RootFS.DiffIDs), len(layers))
+	}
+	return cfg, layers, nil
+}
+
+// verifyImageLayer reads a layer without extracting it, so that its
+// digests are checked before anything of it is written to the rootfs.
+func verifyImageLayer(layer unpackedLayer) error {
+	f, err := layer.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if layer.diffID != "" {
+		r, err := decompressLayer(f)
+		if err != nil {
+			return err
+		}
+		if r, err = newDigestReader(r, layer.diffID); err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return err
+		}
+	}
+	// the blob digest of OCI layouts
+	_, err = io.Copy(ioutil.Discard, f)
+	return err
+}
+
+// applyImageLayer verifies and extracts a layer. The digests are checked
+// again while extracting, in case the layer changed in between.
+func applyImageLayer(la *layerApplier, layer unpackedLayer) error {
+	if err := verifyImageLayer(layer); err != nil {
+		return err
+	}
+	f, err := layer.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompressLayer(f)
+	if err != nil {
+		return err
+	}
+	if layer.diffID != "" {
+		if r, err = newDigestReader(r, layer.diffID); err != nil {
+			return err
+		}
+	}
+	if err := la.apply(r); err != nil {
+		return err
+	}
+	// the tar reader stops at the end marker, the digests are checked
+	// at EOF
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, f)
+	return err
+}
+
+func doUnpack(ctx *cli.Context) error {
+	image := ctx.Args().Get(0)
+	bundle := ctx.Args().Get(1)
+	if len(image) == 0 || len(bundle) == 0 {
+		fmt.Fprintf(os.Stderr, "missing image or bundle\n")
+		cli.ShowCommandHelpAndExit(ctx, "unpack", 1)
+	}
+
+	cfg, layers, err := openImage(image, ctx.String("ref"))
+	if err != nil {
+		return err
+	}
+	if cfg.OS != "" && cfg.OS != runtime.GOOS || cfg.Architecture != "" && cfg.Architecture != runtime.GOARCH {
+		log.Warnf("the image is for %s/%s", cfg.OS, cfg.Architecture)
+	}
+
+	specPath := filepath.Join(bundle, "config.json")
+	if exists, err := pathExists(specPath); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("'%s' already exists", specPath)
+		}
+		return err
+	}
+	rootfs := filepath.Join(bundle, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return errors.Wrap(err, "failed to create rootfs")
+	}
+	if names, err := ioutil.ReadDir(rootfs); err != nil || len(names) > 0 {
+		if err == nil {
+			err = fmt.Errorf("rootfs '%s' is not empty", rootfs)
+		}
+		return err
+	}
+
+	la := &layerApplier{rootfs: rootfs, rootless: isRootless()}
+	for i, layer := range layers {
+		if err := applyImageLayer(la, layer); err != nil {
+			return errors.Wrapf(err, "failed to unpack layer %d", i)
+		}
+	}
+	if la.skippedDevices > 0 {
+		log.Warnf("skipped %d device nodes, which rootless can't create", la.skippedDevices)
+	}
+
+	spec, err := imageSpec(cfg, rootfs, la.rootless)
+	if err != nil {
+		return err
+	}
+	if ref := ctx.String("ref"); ref != "" {
+		spec.Annotations[annotationImageRefName] = ref
+	}
+	data, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal spec")
+	}
+	return writeFileAtomic(specPath, data, 0644)
+}
+
+var defaultImageCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// imageSpec makes the spec of an image config, rootless specs get a user
+// namespace that maps the user to root.
+func imageSpec(cfg *imageConfig, rootfs string, rootless bool) (*specs.Spec, error) {
+	args := append(append([]string{}, cfg.Config.Entrypoint...), cfg.Config.Cmd...)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the image has neither an entrypoint nor a cmd")
+	}
+	user, home, err := imageUser(rootfs, cfg.Config.User)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve user '%s'", cfg.Config.User)
+	}
+	env := cfg.Config.Env
+	if !hasEnv(env, "PATH") {
+		env = append(env, "PATH="+defaultImagePath)
+	}
+	if !hasEnv(env, "HOME") {
+		env = append(env, "HOME="+home)
+	}
+	cwd := cfg.Config.WorkingDir
+	if cwd == "" {
+		cwd = "/"
+	}
+
+	for volume := range cfg.Config.Volumes {
+		dir, err := securejoin.SecureJoin(rootfs, volume)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed to create volume '%s'", volume)
+		}
+		log.Infof("volume %s is part of the rootfs, add a mount for it to the spec to keep its data", volume)
+	}
+
+	spec := &specs.Spec{
+		Version:  specs.Version,
+		Root:     &specs.Root{Path: "rootfs"},
+		Hostname: "crio-lxc",
+		Process: &specs.Process{
+			User: user,
+			Args: args,
+			Env:  env,
+			Cwd:  cwd,
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  defaultImageCapabilities,
+				Effective: defaultImageCapabilities,
+				Permitted: defaultImageCapabilities,
+			},
+		},
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620", "gid=5"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+			{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup", Options: []string{"nosuid", "noexec", "nodev", "relatime", "ro"}},
+		},
+		Annotations: imageAnnotations(cfg),
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace},
+				{Type: specs.IPCNamespace},
+				{Type: specs.UTSNamespace},
+				{Type: specs.MountNamespace},
+			},
+			MaskedPaths: []string{
+				"/proc/acpi", "/proc/asound", "/proc/kcore", "/proc/keys", "/proc/latency_stats",
+				"/proc/timer_list", "/proc/timer_stats", "/proc/sched_debug", "/sys/firmware", "/proc/scsi",
+			},
+			ReadonlyPaths: []string{
+				"/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+			},
+		},
+	}
+
+	if rootless {
+		// the same changes as runc spec --rootless
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+		spec.Linux.UIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Geteuid()), Size: 1}}
+		spec.Linux.GIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Getegid()), Size: 1}}
+		mounts := []specs.Mount{}
+		for _, ms := range spec.Mounts {
+			switch ms.Destination {
+			case "/sys":
+				ms = specs.Mount{Destination: "/sys", Type: "none", Source: "/sys", Options: []string{"rbind", "nosuid", "noexec", "nodev", "ro"}}
+			case "/sys/fs/cgroup":
+				continue
+			case "/dev/pts":
+				ms.Options = ms.Options[:len(ms.Options)-1]
+			}
+			mounts = append(mounts, ms)
+		}
+		spec.Mounts = mounts
+	}
+	return spec, nil
+}
+
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageAnnotations are the labels and the annotations the image spec
+// derives from the image config, which labels can't override.
+func imageAnnotations(cfg *imageConfig) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range cfg.Config.Labels {
+		annotations[k] = v
+	}
+	derived := map[string]string{
+		"org.opencontainers.image.os":           cfg.OS,
+		"org.opencontainers.image.architecture": cfg.Architecture,
+		"org.opencontainers.image.author":       cfg.Author,
+		"org.opencontainers.image.created":      cfg.Created,
+		"org.opencontainers.image.stopSignal":   cfg.Config.StopSignal,
+	}
+	ports := []string{}
+	for port := range cfg.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	derived["org.opencontainers.image.exposedPorts"] = strings.Join(ports, ",")
+	for k, v := range derived {
+		if v != "" {
+			annotations[k] = v
+		} else {
+			delete(annotations, k)
+		}
+	}
+	return annotations
+}
+
+// imageUser resolves the user of the image config, which is
+// user[:group] by name or id, in the rootfs' /etc/passwd and /etc/group.
+// It also returns the user's home.
+func imageUser(rootfs string, spec string) (specs.User, string, error) {
+	user := specs.User{}
+	home := "/"
+	if spec == "" {
+		spec = "0"
+	}
+	name, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+
+	passwd, err := readImageDB(rootfs, "/etc/passwd")
+	if err != nil {
+		return user, home, err
+	}
+	uid, uidErr := strconv.ParseUint(name, 10, 32)
+	userName := ""
+	for _, entry := range passwd {
+		if len(entry) < 6 || (entry[0] != name && (uidErr != nil || entry[2] != name)) {
+			continue
+		}
+		entryUID, err := strconv.ParseUint(entry[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		entryGID, err := strconv.ParseUint(entry[3], 10, 32)
+		if err != nil {
+			continue
+		}
+		userName, uid = entry[0], entryUID
+		user.GID = uint32(entryGID)
+		home = entry[5]
+		break
+	}
+	if userName == "" && uidErr != nil {
+		return user, home, fmt.Errorf("no user '%s' in /etc/passwd", name)
+	}
+	user.UID = uint32(uid)
+
+	groups, err := readImageDB(rootfs, "/etc/group")
+	if err != nil {
+		return user, home, err
+	}
+	if group != "" {
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			found := false
+			for _, entry := range groups {
+				if len(entry) >= 3 && entry[0] == group {
+					if gid, err = strconv.ParseUint(entry[2], 10, 32); err == nil {
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				return user, home, fmt.Errorf("no group '%s' in /etc/group", group)
+			}
+		}
+		user.GID = uint32(gid)
+	}
+	if userName != "" {
+		for _, entry := range groups {
+			if len(entry) < 4 {
+				continue
+			}
+			for _, member := range strings.Split(entry[3], ",") {
+				if member != userName {
+					continue
+				}
+				if gid, err := strconv.ParseUint(entry[2], 10, 32); err == nil && uint32(gid) != user.GID {
+					user.AdditionalGids = append(user.AdditionalGids, uint32(gid))
+				}
+			}
+		}
+	}
+	return user, home, nil
+}
+
+// readImageDB reads the colon separated entries of a file like
+// /etc/passwd in the rootfs, a missing file has none.
+func readImageDB(rootfs string, name string) ([][]string, error) {
+	path, err := securejoin.SecureJoin(rootfs, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := [][]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, nil
+}
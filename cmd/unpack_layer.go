@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// decompressLayer returns the tar stream of a layer, which is gzip
+// compressed or not. The media types aren't trusted for this, docker
+// archives don't have any.
+func decompressLayer(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, zstdMagic):
+		return nil, errors.New("zstd compressed layers are not supported")
+	}
+	return br, nil
+}
+
+// layerApplier extracts the layers of an image into rootfs, one after the
+// other.
+type layerApplier struct {
+	rootfs string
+	// rootless can't chown or create devices, the files are owned by the
+	// user and devices are skipped.
+	rootless       bool
+	skippedDevices int
+	// extracted are the paths of the current layer, which an opaque
+	// whiteout in the same layer must keep.
+	extracted map[string]bool
+	// dirs get their mode and times once the layer is done, so that a
+	// read-only dir doesn't stop its entries from being extracted.
+	dirs []*tar.Header
+}
+
+func (la *layerApplier) apply(r io.Reader) error {
+	la.extracted = map[string]bool{}
+	la.dirs = nil
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+		if err := la.applyEntry(hdr, tr); err != nil {
+			return errors.Wrapf(err, "failed to extract '%s'", hdr.Name)
+		}
+	}
+	// deepest first, so that a parent's times are set after its entries
+	// were changed
+	for i := len(la.dirs) - 1; i >= 0; i-- {
+		hdr := la.dirs[i]
+		path, err := la.entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		// a later entry may have replaced the dir
+		if fi, err := os.Lstat(path); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err := la.setMetadata(path, hdr); err != nil {
+			return errors.Wrapf(err, "failed to set metadata of '%s'", hdr.Name)
+		}
+	}
+	return nil
+}
+
+// entryPath resolves the parent of name in the scope of the rootfs, the
+// entry itself is never followed.
+func (la *layerApplier) entryPath(name string) (string, error) {
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return la.rootfs, nil
+	}
+	dir, base := filepath.Split(name)
+	parent, err := securejoin.SecureJoin(la.rootfs, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, base), nil
+}
+
+func (la *layerApplier) applyEntry(hdr *tar.Header, r io.Reader) error {
+	switch hdr.Typeflag {
+	case tar.TypeXGlobalHeader:
+		return nil
+	case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink,
+		tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+	default:
+		log.Warnf("skipping '%s' of unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	path, err := la.entryPath(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if path == la.rootfs {
+		if hdr.Typeflag == tar.TypeDir {
+			la.dirs = append(la.dirs, hdr)
+		}
+		return nil
+	}
+	parent, base := filepath.Split(path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	if base == whiteoutOpaque {
+		return la.clearDir(parent)
+	}
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		target := strings.TrimPrefix(base, whiteoutPrefix)
+		// ".wh.." would remove the parent's parent
+		if target == "" || target == "." || target == ".." || strings.Contains(target, "/") {
+			return errors.Errorf("invalid whiteout '%s'", hdr.Name)
+		}
+		return os.RemoveAll(filepath.Join(parent, target))
+	}
+
+	la.extracted[path] = true
+	if fi, err := os.Lstat(path); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		la.dirs = append(la.dirs, hdr)
+		return nil
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := la.entryPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// the target has its metadata already
+		return os.Link(target, path)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			mode = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			mode = unix.S_IFBLK
+		}
+		if la.rootless && mode != unix.S_IFIFO {
+			la.skippedDevices++
+			return nil
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, mode|0600, int(dev)); err != nil {
+			return err
+		}
+	}
+	return la.setMetadata(path, hdr)
+}
+
+// clearDir removes what lower layers put into dir.
+func (la *layerApplier) clearDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if la.extracted[path] {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMetadata sets the owner, xattrs, mode and times of an entry, in that
+// order since chown clears the setuid and setgid bits.
+func (la *layerApplier) setMetadata(path string, hdr *tar.Header) error {
+	if !la.rootless {
+		if err := unix.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return errors.Wrap(err, "failed to chown")
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, "SCHILY.xattr.") {
+			continue
+		}
+		name := strings.TrimPrefix(key, "SCHILY.xattr.")
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+			// security.* xattrs need privileges and filesystem
+			// support the image can't count on
+			if err == unix.ENOTSUP || err == unix.EPERM {
+				log.Debugf("skipping xattr %s of '%s': %v", name, hdr.Name, err)
+				continue
+			}
+			return errors.Wrapf(err, "failed to set xattr %s", name)
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := unix.Chmod(path, uint32(hdr.Mode&07777)); err != nil {
+			return errors.Wrap(err, "failed to chmod")
+		}
+	}
+	times := []unix.Timespec{
+		unix.NsecToTimespec(hdr.AccessTime.UnixNano()),
+		unix.NsecToTimespec(hdr.ModTime.UnixNano()),
+	}
+	if hdr.AccessTime.IsZero() {
+		times[0] = times[1]
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return errors.Wrap(err, "failed to set times")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyWhiteout(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+		removed string
+	}{
+		{"dir/.wh.file", false, "dir/file"},
+		{"dir/.wh.", true, ""},
+		{"dir/.wh..", true, ""},
+		{"dir/.wh...", true, ""},
+	}
+	for _, tt := range tests {
+		rootfs, err := ioutil.TempDir("", "unpack")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(rootfs)
+		if err := os.MkdirAll(filepath.Join(rootfs, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(rootfs, "dir", "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: tt.name, Typeflag: tar.TypeReg, Mode: 0644})
+		tw.Close()
+
+		la := &layerApplier{rootfs: rootfs, rootless: true}
+		err = la.apply(&buf)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: apply error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if _, err := os.Stat(filepath.Join(rootfs, "dir")); err != nil {
+			t.Errorf("%s: dir was removed", tt.name)
+		}
+		if tt.removed != "" {
+			if _, err := os.Lstat(filepath.Join(rootfs, tt.removed)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s was not removed", tt.name, tt.removed)
+			}
+		}
+	}
+}
+
+func TestApplyImageLayerVerifiesFirst(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "unpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	layer := unpackedLayer{
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		},
+		diffID: "sha256:" + strings.Repeat("0", 64),
+	}
+
+	la := &layerApplier{rootfs: rootfs, rootless: true}
+	if err := applyImageLayer(la, layer); err == nil {
+		t.Fatal("applyImageLayer succeeded with a wrong diff_id")
+	}
+	if _, err := os.Lstat(filepath.Join(rootfs, "file")); !os.IsNotExist(err) {
+		t.Error("layer was extracted before its diff_id was verified")
+	}
+}
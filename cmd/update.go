@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var updateCmd = cli.Command{
+	Name:   "update",
+	Usage:  "updates the resource limits and scheduling of a running container",
+	Action: doUpdate,
+	ArgsUsage: `<containerID>
+
+<containerID> is the ID of the container to update
+`,
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "rlimit",
+			Usage: "set a resource limit of the container init, as TYPE=SOFT[:HARD], e.g. nofile=1024:4096 (repeatable)",
+		},
+		cli.IntFlag{
+			Name:  "nice",
+			Usage: "set the nice value of the container init",
+		},
+		cli.StringFlag{
+			Name:  "sched-policy",
+			Usage: "set the scheduling policy of the container init (other, batch, idle, fifo or rr)",
+		},
+		cli.IntFlag{
+			Name:  "sched-priority",
+			Usage: "static priority for the fifo and rr scheduling policies",
+		},
+	},
+}
+
+// rlimitTypes maps the rlimit names, without the RLIMIT_ prefix, to the
+// kernel's resource numbers.
+var rlimitTypes = map[string]int{
+	"cpu":        0,
+	"fsize":      1,
+	"data":       2,
+	"stack":      3,
+	"core":       4,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     7,
+	"memlock":    8,
+	"as":         9,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+var schedPolicies = map[string]int{
+	"other": 0,
+	"fifo":  1,
+	"rr":    2,
+	"batch": 3,
+	"idle":  5,
+}
+
+// rlimitType accepts both "nofile" and the OCI spelling "RLIMIT_NOFILE".
+func rlimitType(name string) (int, error) {
+	resource, ok := rlimitTypes[strings.TrimPrefix(strings.ToLower(name), "rlimit_")]
+	if !ok {
+		return 0, fmt.Errorf("unknown rlimit type '%s'", name)
+	}
+	return resource, nil
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" || value == "-1" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseRlimit parses TYPE=SOFT[:HARD], the hard limit defaults to the soft
+// limit.
+func parseRlimit(arg string) (int, *unix.Rlimit, error) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return 0, nil, fmt.Errorf("invalid rlimit '%s', expected TYPE=SOFT[:HARD]", arg)
+	}
+	resource, err := rlimitType(parts[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	values := strings.SplitN(parts[1], ":", 2)
+	soft, err := parseRlimitValue(values[0])
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "invalid soft limit in '%s'", arg)
+	}
+	hard := soft
+	if len(values) == 2 {
+		hard, err = parseRlimitValue(values[1])
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "invalid hard limit in '%s'", arg)
+		}
+	}
+	if soft > hard {
+		return 0, nil, fmt.Errorf("soft limit exceeds hard limit in '%s'", arg)
+	}
+	return resource, &unix.Rlimit{Cur: soft, Max: hard}, nil
+}
+
+// setScheduler is sched_setscheduler(2), which x/sys/unix doesn't wrap.
+func setScheduler(pid int, policy int, priority int) error {
+	param := struct{ priority int32 }{int32(priority)}
+	_, _, errno := unix.Syscall(unix.SYS_SCHED_SETSCHEDULER, uintptr(pid), uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func doUpdate(ctx *cli.Context) error {
+	containerID := ctx.Args().Get(0)
+	if len(containerID) == 0 {
+		fmt.Fprintf(os.Stderr, "missing container ID\n")
+		cli.ShowCommandHelpAndExit(ctx, "update", 1)
+	}
+
+	// parse everything before changing anything
+	type rlimit struct {
+		resource int
+		limit    *unix.Rlimit
+	}
+	rlimits := []rlimit{}
+	for _, arg := range ctx.StringSlice("rlimit") {
+		resource, limit, err := parseRlimit(arg)
+		if err != nil {
+			return err
+		}
+		rlimits = append(rlimits, rlimit{resource, limit})
+	}
+	policy := -1
+	if ctx.IsSet("sched-policy") {
+		p, ok := schedPolicies[ctx.String("sched-policy")]
+		if !ok {
+			return fmt.Errorf("unknown scheduling policy '%s'", ctx.String("sched-policy"))
+		}
+		policy = p
+	}
+
+	exists, err := containerExists(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if container exists")
+	}
+	if !exists {
+		return errNotFound(containerID)
+	}
+
+	c, err := lxc.NewContainer(containerID, LXC_PATH)
+	if err != nil {
+		return errors.Wrap(err, "failed to load container")
+	}
+	defer c.Release()
+
+	if err := configureLogging(ctx, c); err != nil {
+		return errors.Wrap(err, "failed to configure logging")
+	}
+
+	pid, err := containerInitPid(c, containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get container init pid")
+	}
+	if pid == 0 {
+		return errNotRunning(containerID, "cannot update")
+	}
+
+	for _, r := range rlimits {
+		if err := unix.Prlimit(pid, r.resource, r.limit, nil); err != nil {
+			return errors.Wrapf(err, "failed to set rlimit %d", r.resource)
+		}
+	}
+	if ctx.IsSet("nice") {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, ctx.Int("nice")); err != nil {
+			return errors.Wrap(err, "failed to set nice value")
+		}
+	}
+	if policy >= 0 {
+		if err := setScheduler(pid, policy, ctx.Int("sched-priority")); err != nil {
+			return errors.Wrapf(err, "failed to set scheduling policy %s", ctx.String("sched-policy"))
+		}
+	}
+	return nil
+}
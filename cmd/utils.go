@@ -3,12 +3,18 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/apex/log"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 
 	lxc "gopkg.in/lxc/go-lxc.v2"
 )
@@ -28,9 +34,21 @@ func readBundleSpec(specFilePath string) (spec *specs.Spec, err error) {
 }
 
 func configureLogging(ctx *cli.Context, c *lxc.Container) error {
-	if ctx.GlobalIsSet("log-level") {
+	level, levelSet := ctx.GlobalString("log-level"), ctx.GlobalIsSet("log-level")
+	// a per container log level overrides the global one, for both lxc
+	// and the runtime's own logging
+	if containerLevel, ok := containerLogLevel(c.Name()); ok {
+		runtimeLevel, err := runtimeLogLevel(containerLevel)
+		if err != nil {
+			return errors.Wrapf(err, "invalid annotation %s", ANNOTATION_LOG_LEVEL)
+		}
+		log.SetLevel(runtimeLevel)
+		level, levelSet = containerLevel, true
+	}
+
+	if levelSet {
 		logLevel := lxc.TRACE
-		switch ctx.GlobalString("log-level") {
+		switch level {
 		case "trace":
 			logLevel = lxc.TRACE
 		case "debug":
@@ -47,12 +65,137 @@ func configureLogging(ctx *cli.Context, c *lxc.Container) error {
 		c.SetLogLevel(logLevel)
 	}
 
+	logFile := lxcLogFile(ctx, c.Name())
 	if ctx.GlobalIsSet("log-file") {
-		c.SetLogFile(ctx.GlobalString("log-file"))
+		c.SetLogFile(logFile)
+	}
+
+	if maxSize := ctx.GlobalInt64("log-max-size"); maxSize > 0 {
+		if err := rotateLog(logFile, maxSize, ctx.GlobalInt("log-max-files")); err != nil {
+			return errors.Wrapf(err, "failed to rotate log file '%s'", logFile)
+		}
 	}
 	return nil
 }
 
+// containerLogLevel returns the log level annotation of the container, if
+// any.
+func containerLogLevel(containerID string) (string, bool) {
+	md, err := readMetadata(containerID)
+	if err != nil {
+		return "", false
+	}
+	level, ok := md.Annotations[ANNOTATION_LOG_LEVEL]
+	return level, ok
+}
+
+// configureLogLevel makes the container's liblxc log at the level of the
+// log level annotation, also when it is started or attached to later.
+func configureLogLevel(cfg *lxcConfig, spec *specs.Spec) error {
+	level, ok := spec.Annotations[ANNOTATION_LOG_LEVEL]
+	if !ok {
+		return nil
+	}
+	if _, err := runtimeLogLevel(level); err != nil {
+		return errors.Wrapf(err, "invalid annotation %s", ANNOTATION_LOG_LEVEL)
+	}
+	if level == "" {
+		level = "error"
+	}
+	return cfg.Set("lxc.log.level", strings.ToUpper(level))
+}
+
+// lxcLogFile returns the lxc log file used for the container, by default
+// the one in the lxc dir.
+func lxcLogFile(ctx *cli.Context, containerID string) string {
+	if ctx.GlobalIsSet("log-file") {
+		return ctx.GlobalString("log-file")
+	}
+	return withLegacyPath(lxcPath(containerID, lxcLogFileName), lxcPath(containerID, containerID+".log"))
+}
+
+// rotateLog rotates the log file once it is bigger than maxSize, keeping
+// up to maxFiles old logs as path.1 ... path.maxFiles. With maxFiles 0 the
+// log is truncated instead.
+//
+// The log is copied and truncated rather than renamed, since the
+// container's monitor keeps it open for appending and would go on writing
+// to the rotated file. Lines written during the copy are lost. Rotations
+// of the same log are serialized by a lock on it.
+func rotateLog(path string, maxSize int64, maxFiles int) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to lock log")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() <= maxSize {
+		return nil
+	}
+
+	if maxFiles > 0 {
+		for i := maxFiles - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", path, i)
+			if err := os.Rename(src, fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		rotated, err := os.OpenFile(path+".1", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(rotated, f)
+		if cerr := rotated.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to copy log")
+		}
+	}
+	return f.Truncate(0)
+}
+
+// rotateContainerLogs rotates the lxc logs of all containers, for
+// containers that run for long without any command rotating their log.
+func rotateContainerLogs(ctx *cli.Context) {
+	maxSize := ctx.GlobalInt64("log-max-size")
+	if maxSize <= 0 {
+		return
+	}
+	ids, err := listContainerIDs()
+	if err != nil {
+		log.Warnf("failed to list containers: %v", err)
+		return
+	}
+	rotated := map[string]bool{}
+	for _, id := range ids {
+		logFile := lxcLogFile(ctx, id)
+		if rotated[logFile] {
+			continue
+		}
+		rotated[logFile] = true
+		if err := rotateLog(logFile, maxSize, ctx.GlobalInt("log-max-files")); err != nil {
+			log.Warnf("failed to rotate log file '%s': %v", logFile, err)
+		}
+	}
+}
+
 func pathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -65,13 +208,61 @@ func pathExists(path string) (bool, error) {
 }
 
 func containerExists(containerID string) (bool, error) {
+	if err := validateContainerID(containerID); err != nil {
+		return false, err
+	}
 	// check for container existence by looking for config file.
 	// otherwise NewContainer will return an empty container
 	// struct and we'll report wrong info
-	configExists, err := pathExists(filepath.Join(LXC_PATH, containerID, "config"))
+	configExists, err := pathExists(lxcPath(containerID, configFileName))
 	if err != nil {
 		return false, errors.Wrap(err, "failed to check path existence of config")
 	}
 
 	return configExists, nil
 }
+
+func writePidFile(path string, pid int) error {
+	return writeFileAtomic(path, []byte(strconv.Itoa(pid)), 0644)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into
+// place, so readers never see a partial file. The file and its directory
+// are synced, so that after a crash the file is either the old or the new
+// one.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return fsyncDir(filepath.Dir(path))
+}
+
+// fsyncDir makes the changes of the entries of dir durable.
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
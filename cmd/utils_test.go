@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readLog(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotateLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		maxSize  int64
+		maxFiles int
+		want     []string
+	}{
+		{"below max size", "0123", 4, 2, []string{"0123", "old1", "<missing>"}},
+		{"truncated", "01234", 4, 0, []string{"", "old1", "<missing>"}},
+		{"rotated", "01234", 4, 2, []string{"", "01234", "old1"}},
+		{"rotated, one file", "01234", 4, 1, []string{"", "01234", "<missing>"}},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "rotate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "lxc.log")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path+".1", []byte("old1"), 0640); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rotateLog(path, tt.maxSize, tt.maxFiles); err != nil {
+			t.Errorf("%s: rotateLog: %v", tt.name, err)
+			continue
+		}
+		for i, p := range []string{path, path + ".1", path + ".2"} {
+			if got := readLog(t, p); got != tt.want[i] {
+				t.Errorf("%s: %s = %q, want %q", tt.name, filepath.Base(p), got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRotateLogKeepsWriters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lxc.log")
+
+	// as liblxc opens its log
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.WriteString("before\n")
+	if err := rotateLog(path, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("after\n")
+
+	if got := readLog(t, path); got != "after\n" {
+		t.Errorf("log = %q, want the lines written after the rotation", got)
+	}
+	if got := readLog(t, path+".1"); got != "before\n" {
+		t.Errorf("rotated log = %q, want the lines written before the rotation", got)
+	}
+}
+
+func TestRotateLogMissing(t *testing.T) {
+	if err := rotateLog("/nonexistent/lxc.log", 1, 1); err != nil {
+		t.Errorf("rotateLog of a missing log: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+var validNamespaceTypes = map[specs.LinuxNamespaceType]bool{
+	specs.PIDNamespace:     true,
+	specs.NetworkNamespace: true,
+	specs.MountNamespace:   true,
+	specs.IPCNamespace:     true,
+	specs.UTSNamespace:     true,
+	specs.UserNamespace:    true,
+	specs.CgroupNamespace:  true,
+}
+
+// validateSpec checks the parts of the spec that create relies on, so that
+// problems are reported before anything is created rather than as an lxc
+// failure halfway through.
+func validateSpec(spec *specs.Spec) error {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return fmt.Errorf("root.path is required")
+	}
+
+	if spec.Process == nil {
+		return fmt.Errorf("process is required")
+	}
+	if len(spec.Process.Args) == 0 {
+		return fmt.Errorf("process.args must not be empty")
+	}
+	if !filepath.IsAbs(spec.Process.Cwd) {
+		return fmt.Errorf("process.cwd '%s' must be an absolute path", spec.Process.Cwd)
+	}
+
+	for _, ms := range spec.Mounts {
+		if !filepath.IsAbs(ms.Destination) {
+			return fmt.Errorf("mount destination '%s' must be an absolute path", ms.Destination)
+		}
+	}
+
+	if spec.Linux != nil {
+		if err := validateNamespaces(spec.Linux.Namespaces); err != nil {
+			return errors.Wrap(err, "invalid linux.namespaces")
+		}
+	}
+
+	return nil
+}
+
+func validateNamespaces(namespaces []specs.LinuxNamespace) error {
+	seen := map[specs.LinuxNamespaceType]bool{}
+	for _, ns := range namespaces {
+		if !validNamespaceTypes[ns.Type] {
+			return fmt.Errorf("unknown namespace type '%s'", ns.Type)
+		}
+		if seen[ns.Type] {
+			return fmt.Errorf("duplicate namespace type '%s'", ns.Type)
+		}
+		seen[ns.Type] = true
+		if ns.Path != "" && !filepath.IsAbs(ns.Path) {
+			return fmt.Errorf("%s namespace path '%s' must be an absolute path", ns.Type, ns.Path)
+		}
+	}
+	return nil
+}
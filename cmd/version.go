@@ -0,0 +1,67 @@
+package main
+
+// #cgo LDFLAGS: -llxc
+// #include <lxc/version.h>
+//
+// static const char *lxc_compile_version(void)
+// {
+// 	return LXC_VERSION;
+// }
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+var versionCmd = cli.Command{
+	Name:   "version",
+	Usage:  "prints version information",
+	Action: doVersion,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print version information as json",
+		},
+	},
+}
+
+type versionInfo struct {
+	Version           string `json:"version"`
+	Commit            string `json:"commit"`
+	LiblxcVersion     string `json:"liblxcVersion"`
+	LiblxcCompiledFor string `json:"liblxcCompiledFor"`
+	OCIVersion        string `json:"ociVersion"`
+}
+
+func doVersion(ctx *cli.Context) error {
+	info := versionInfo{
+		Version:           version,
+		Commit:            commit,
+		LiblxcVersion:     lxc.Version(),
+		LiblxcCompiledFor: C.GoString(C.lxc_compile_version()),
+		OCIVersion:        specs.Version,
+	}
+
+	if ctx.Bool("json") {
+		data, err := json.Marshal(info)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal json")
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "crio-lxc version %s\n", info.Version)
+	fmt.Fprintf(os.Stdout, "commit: %s\n", info.Commit)
+	fmt.Fprintf(os.Stdout, "liblxc: %s (compiled against %s)\n", info.LiblxcVersion, info.LiblxcCompiledFor)
+	fmt.Fprintf(os.Stdout, "spec: %s\n", info.OCIVersion)
+	return nil
+}
@@ -3,6 +3,7 @@ module github.com/lxc/crio-lxc
 require (
 	github.com/anuvu/stacker v0.4.0
 	github.com/apex/log v1.1.0
+	github.com/cyphar/filepath-securejoin v0.2.2
 	github.com/gorilla/websocket v1.4.0 // indirect
 	github.com/juju/loggo v0.0.0-20190212223446-d976af380377 // indirect
 	github.com/lxc/lxd v0.0.0-20190404234020-f51c28a37443
@@ -17,6 +18,7 @@ require (
 	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3 // indirect
 	golang.org/x/sys v0.0.0-20190405154228-4b34438f7a67
 	golang.org/x/tools v0.0.0-20190405180640-052fc3cfdbc2 // indirect
+	google.golang.org/grpc v1.18.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/lxc/go-lxc.v2 v2.0.0-20190324192716-2f350e4a2980
 	gopkg.in/yaml.v2 v2.2.2